@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/orange-cloudfoundry/logs-service-broker/dbservices"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
 	"github.com/orange-cloudfoundry/logs-service-broker/utils"
 
 	"github.com/jinzhu/gorm"
@@ -68,6 +69,66 @@ func (b LoghostBroker) newDBError(phase string, err error) error {
 	return nerr
 }
 
+// processingParams - log processing settings requested by user in params
+type processingParams struct {
+	rateLimits model.RateLimits
+	multiline  model.MultilineParams
+	rules      []model.FilterRule
+	redaction  model.Redaction
+	mutations  []model.Mutation
+	timestamp  model.TimestampExtraction
+}
+
+// validateParams - ensure processing settings requested by user are usable before storing them,
+// redaction is checked with hash key of plan
+func validateParams(params model.ProvisionParams, syslogAddr model.SyslogAddress) (processingParams, error) {
+	processing := processingParams{
+		rules:     params.Rules,
+		mutations: params.Mutate,
+	}
+	if params.RateLimit != nil {
+		processing.rateLimits = *params.RateLimit
+		if err := processing.rateLimits.Validate(); err != nil {
+			return processingParams{}, err
+		}
+	}
+	if params.Multiline != nil && !params.Multiline.IsZero() {
+		processing.multiline = *params.Multiline
+		if _, err := parser.NewMultilineRule(processing.multiline); err != nil {
+			return processingParams{}, err
+		}
+	}
+	if _, err := parser.NewRules(params.Rules); err != nil {
+		return processingParams{}, err
+	}
+	if params.Redaction != nil && !params.Redaction.IsZero() {
+		processing.redaction = *params.Redaction
+		if _, err := parser.NewRedactor(processing.redaction, syslogAddr.Redaction.HashKey); err != nil {
+			return processingParams{}, err
+		}
+	}
+	if _, err := parser.NewMutations(params.Mutate); err != nil {
+		return processingParams{}, err
+	}
+	if params.Timestamp != nil && !params.Timestamp.IsZero() {
+		processing.timestamp = *params.Timestamp
+		if _, err := parser.NewTimestampExtractor(processing.timestamp); err != nil {
+			return processingParams{}, err
+		}
+	}
+	return processing, nil
+}
+
+// apply - store processing settings on instance
+func (p processingParams) apply(param *model.InstanceParam) {
+	param.SetRateLimits(p.rateLimits)
+	param.SetMultiline(p.multiline)
+	param.SetFilterRules(p.rules)
+	param.SetRedaction(p.redaction)
+	param.SetMutations(p.mutations)
+	param.SetTimestampExtraction(p.timestamp)
+}
+
 func (b LoghostBroker) Provision(_ context.Context, instanceID string, details domain.ProvisionDetails, _ bool) (domain.ProvisionedServiceSpec, error) {
 	syslogAddr, err := model.SyslogAddresses(b.config.SyslogAddresses).FoundSyslogWriter(details.PlanID)
 	if err != nil {
@@ -84,6 +145,10 @@ func (b LoghostBroker) Provision(_ context.Context, instanceID string, details d
 	if err != nil && len(details.RawParameters) > 0 {
 		return domain.ProvisionedServiceSpec{}, fmt.Errorf("error when loading params: %s", err.Error())
 	}
+	processing, err := validateParams(params, syslogAddr)
+	if err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
 
 	// copy to not modify parent map
 	tags := utils.CopyMapString(syslogAddr.Tags)
@@ -117,7 +182,7 @@ func (b LoghostBroker) Provision(_ context.Context, instanceID string, details d
 		return domain.ProvisionedServiceSpec{}, b.newDBError("provision", err)
 	}
 
-	err = b.db.Create(&model.InstanceParam{
+	newParam := &model.InstanceParam{
 		InstanceID:   instanceID,
 		SpaceID:      ctx.SpaceGUID,
 		OrgID:        ctx.OrganizationGUID,
@@ -130,7 +195,9 @@ func (b LoghostBroker) Provision(_ context.Context, instanceID string, details d
 		UseTls:       params.UseTLS || b.config.HasTLS(),
 		DrainType:    model.DrainType(strings.ToLower(string(drainType))),
 		Revision:     1,
-	}).Error
+	}
+	processing.apply(newParam)
+	err = b.db.Create(newParam).Error
 	if err != nil {
 		return domain.ProvisionedServiceSpec{}, b.newDBError("provision", err)
 	}
@@ -219,7 +286,16 @@ func (b LoghostBroker) genDocURL() string {
 	return fmt.Sprintf("https://%s/docs", b.config.Broker.PublicHost)
 }
 
+// genURL -
+// 1. use native syslog listener when plan asks for it and listener is enabled
+// 2. fallback on http(s) forwarder
 func (b LoghostBroker) genURL(instanceParam model.InstanceParam, bindingID string) string {
+	// 1.
+	if syslogDrainURL, ok := b.genSyslogURL(instanceParam, bindingID); ok {
+		return syslogDrainURL
+	}
+
+	// 2.
 	scheme := "http"
 	port := b.config.Web.Port
 
@@ -239,6 +315,25 @@ func (b LoghostBroker) genURL(instanceParam model.InstanceParam, bindingID strin
 	return syslogDrainURL
 }
 
+// genSyslogURL -
+// in: syslog-tls://{bindingID}-r{rev}.{drainHost}:{port}
+func (b LoghostBroker) genSyslogURL(instanceParam model.InstanceParam, bindingID string) (string, bool) {
+	plan, err := model.SyslogAddresses(b.config.SyslogAddresses).FoundSyslogWriter(instanceParam.SyslogName)
+	// binding is only known through sni, plain tcp drains would lose all messages
+	if err != nil || plan.GetDrainScheme() != model.DrainSchemeSyslog || !b.config.Listener.HasTLS() {
+		return "", false
+	}
+
+	host := model.BindingHostLabel(bindingID, instanceParam.Revision)
+	syslogDrainURL := fmt.Sprintf("syslog-tls://%s.%s:%d", host, b.config.Broker.DrainHost, b.config.Listener.TLS.Port)
+	if instanceParam.DrainType != "" {
+		queryValues := make(url.Values)
+		queryValues.Add(model.DrainTypeKey, string(instanceParam.DrainType))
+		syslogDrainURL += fmt.Sprintf("?%s", queryValues.Encode())
+	}
+	return syslogDrainURL, true
+}
+
 func (b LoghostBroker) Unbind(
 	_ context.Context,
 	_ string,
@@ -288,6 +383,10 @@ func (b LoghostBroker) Update(
 	if err != nil && len(details.RawParameters) > 0 {
 		return domain.UpdateServiceSpec{}, fmt.Errorf("error when loading params: %s", err.Error())
 	}
+	processing, err := validateParams(params, syslogAddr)
+	if err != nil {
+		return domain.UpdateServiceSpec{}, err
+	}
 
 	// copy to not modify parent map
 	tags := utils.CopyMapString(syslogAddr.Tags)
@@ -320,7 +419,7 @@ func (b LoghostBroker) Update(
 		drainType = ""
 	}
 	patterns := append(syslogAddr.Patterns, params.Patterns...)
-	err = b.db.Create(&model.InstanceParam{
+	newParam := &model.InstanceParam{
 		InstanceID:   instanceID,
 		SpaceID:      instanceParam.SpaceID,
 		OrgID:        instanceParam.OrgID,
@@ -333,7 +432,9 @@ func (b LoghostBroker) Update(
 		UseTls:       b.config.HasTLS(),
 		DrainType:    model.DrainType(strings.ToLower(string(drainType))),
 		Revision:     instanceParam.Revision + 1,
-	}).Error
+	}
+	processing.apply(newParam)
+	err = b.db.Create(newParam).Error
 	if err != nil {
 		return domain.UpdateServiceSpec{}, b.newDBError("update", err)
 	}
@@ -375,14 +476,48 @@ func (b LoghostBroker) GetInstance(
 		return domain.GetInstanceDetailsSpec{}, err
 	}
 
+	rules, err := instanceParam.FilterRules()
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+	redaction, err := instanceParam.GetRedaction()
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+	mutations, err := instanceParam.GetMutations()
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+	timestamp, err := instanceParam.GetTimestampExtraction()
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+
+	params := model.ProvisionParams{
+		Tags:     instanceParam.TagsToMap(),
+		Patterns: model.Patterns(instanceParam.Patterns).ToList(),
+		Rules:    rules,
+	}
+	if multiline := instanceParam.Multiline(); !multiline.IsZero() {
+		params.Multiline = &multiline
+	}
+	if !redaction.IsZero() {
+		params.Redaction = &redaction
+	}
+	if len(mutations) > 0 {
+		params.Mutate = mutations
+	}
+	if !timestamp.IsZero() {
+		params.Timestamp = &timestamp
+	}
+	if rateLimits := instanceParam.RateLimits(); !rateLimits.IsZero() {
+		params.RateLimit = &rateLimits
+	}
 	return domain.GetInstanceDetailsSpec{
 		PlanID:       syslogAddr.ID,
 		ServiceID:    serviceId,
 		DashboardURL: b.genDashboardURL(instanceID),
-		Parameters: model.ProvisionParams{
-			Tags:     instanceParam.TagsToMap(),
-			Patterns: model.Patterns(instanceParam.Patterns).ToList(),
-		},
+		Parameters:   params,
 	}, nil
 }
 
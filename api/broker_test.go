@@ -15,6 +15,20 @@ import (
 	"github.com/orange-cloudfoundry/logs-service-broker/model"
 )
 
+// invalidParams - processing params refused by broker with the error expected
+var invalidParams = []struct {
+	name string
+	raw  string
+	err  string
+}{
+	{"rate limit", `{"rate_limit": {"binding": {"messages_per_second": -1}}}`, "rate limit of binding must not be negative"},
+	{"multiline", `{"multiline": {"start": "("}}`, "invalid multiline start pattern"},
+	{"rules", `{"rules": [{"expression": "(", "action": "drop"}]}`, "rule 1: invalid expression"},
+	{"redaction", `{"redaction": {"detectors": ["unknown"]}}`, "unknown redaction detector 'unknown'"},
+	{"mutate", `{"mutate": [{}]}`, "mutation 1: no operation is set"},
+	{"timestamp", `{"timestamp": {"keys": ["time"], "format": "unknown"}}`, "invalid timestamp format 'unknown'"},
+}
+
 var _ = Describe("Broker", func() {
 	//var db *gorm.DB
 	//var err error
@@ -162,6 +176,26 @@ var _ = Describe("Broker", func() {
 				Expect(specs.DashboardURL).To(Equal("https://logservice.public.domain/docs/ad45d7cc-4795-4554"))
 			})
 		})
+
+		When("processing params are invalid", func() {
+			for _, invalid := range invalidParams {
+				invalid := invalid
+				It("refuses to provision with invalid "+invalid.name, func() {
+					details := domain.ProvisionDetails{
+						ServiceID:     "11c147f0-297f-4fd6-9401-e94e64f37094",
+						PlanID:        planID,
+						RawContext:    []byte(`{"organization_guid": "1", "space_guid": "2", "service_guid": "11c147f0-297f-4fd6-9401-e94e64f37094", "plateform": "cloudfoundry"}`),
+						RawParameters: []byte(invalid.raw),
+					}
+					_, err = broker.Provision(context.Background(), serviceID, details, true)
+					Expect(err).To(MatchError(ContainSubstring(invalid.err)))
+
+					var count int
+					db.Model(&model.InstanceParam{}).Where("instance_id = ?", serviceID).Count(&count)
+					Expect(count).To(Equal(0))
+				})
+			}
+		})
 	})
 
 	Context("Deprovision()", func() {
@@ -290,6 +324,46 @@ var _ = Describe("Broker", func() {
 			})
 		})
 
+		When("plan asks for syslog drain scheme", func() {
+
+			BeforeEach(func() {
+				config.SyslogAddresses[0].DrainScheme = model.DrainSchemeSyslog
+				config.Listener = model.ListenerConfig{
+					Port: 6514,
+					TLS: model.WebTLSConfig{
+						Port:     6515,
+						CertFile: "path/to/cert.pem",
+						KeyFile:  "path/to/key.pem",
+					},
+				}
+				result := db.Create(&model.InstanceParam{
+					InstanceID: serviceID,
+					Revision:   2,
+					OrgID:      "1",
+					SpaceID:    "2",
+					SyslogName: "loghost",
+					UseTls:     true,
+				})
+				Expect(result.Error).To(BeNil())
+				details := domain.BindDetails{
+					ServiceID:  "11c147f0-297f-4fd6-9401-e94e64f37094",
+					PlanID:     planID,
+					AppGUID:    "3",
+					RawContext: []byte(`{"app_guid": "3"}`),
+				}
+				specs, err = broker.Bind(context.Background(), serviceID, bindingID, details, false)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				db.Exec("DELETE FROM instance_params;")
+			})
+
+			It("returns syslog-tls drain url with binding in host", func() {
+				Expect(specs.SyslogDrainURL).To(Equal("syslog-tls://125ce4a5-7845-14ae-r2.logservice.private.domain:6515"))
+			})
+		})
+
 	})
 
 	Context("UnBind()", func() {
@@ -443,6 +517,26 @@ var _ = Describe("Broker", func() {
 				Expect(specs.DashboardURL).To(Equal("https://logservice.public.domain/docs/ad45d7cc-4795-4554"))
 			})
 		})
+
+		When("processing params are invalid", func() {
+			for _, invalid := range invalidParams {
+				invalid := invalid
+				It("refuses to update with invalid "+invalid.name, func() {
+					details := domain.UpdateDetails{
+						ServiceID:     "11c147f0-297f-4fd6-9401-e94e64f37094",
+						PlanID:        planID,
+						RawContext:    []byte(`{"organization_guid": "1", "space_guid": "2", "service_guid": "11c147f0-297f-4fd6-9401-e94e64f37094", "plateform": "cloudfoundry"}`),
+						RawParameters: []byte(invalid.raw),
+					}
+					_, err = broker.Update(context.Background(), serviceID, details, true)
+					Expect(err).To(MatchError(ContainSubstring(invalid.err)))
+
+					var inst model.InstanceParam
+					db.Order("revision desc").First(&inst, "instance_id = ?", serviceID)
+					Expect(inst.Revision).To(Equal(2))
+				})
+			}
+		})
 	})
 
 	Context("GetInstance()", func() {
@@ -455,11 +549,12 @@ var _ = Describe("Broker", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 
 			result := db.Create(&model.InstanceParam{
-				InstanceID: serviceID,
-				Revision:   1,
-				OrgID:      "1",
-				SpaceID:    "2",
-				SyslogName: "loghost",
+				InstanceID:          serviceID,
+				Revision:            1,
+				OrgID:               "1",
+				SpaceID:             "2",
+				SyslogName:          "loghost",
+				BindingMessagesRate: 10,
 			})
 			Expect(result.Error).To(BeNil())
 		})
@@ -474,6 +569,9 @@ var _ = Describe("Broker", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(specs.PlanID).To(Equal(planID))
 			Expect(specs.DashboardURL).To(Equal("https://logservice.public.domain/docs/ad45d7cc-4795-4554"))
+			Expect(specs.Parameters.(model.ProvisionParams).RateLimit).To(Equal(&model.RateLimits{
+				Binding: model.RateLimit{MessagesPerSecond: 10},
+			}))
 		})
 	})
 
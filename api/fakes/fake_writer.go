@@ -1,17 +1,26 @@
 package fakes
 
+import "sync"
+
 type FakeWriter struct {
-	Buffer *string
+	Buffer   *string
+	messages *[]string
+	mu       *sync.Mutex
 }
 
 func NewFakeWriter() *FakeWriter {
 	return &FakeWriter{
-		Buffer: new(string),
+		Buffer:   new(string),
+		messages: new([]string),
+		mu:       new(sync.Mutex),
 	}
 }
 
 func (fw FakeWriter) Write(b []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
 	*fw.Buffer = string(b)
+	*fw.messages = append(*fw.messages, string(b))
 	return len(*fw.Buffer), nil
 }
 
@@ -22,3 +31,43 @@ func (fw FakeWriter) Close() error {
 func (fw FakeWriter) GetBuffer() *string {
 	return fw.Buffer
 }
+
+// GetMessages - all messages written in order
+func (fw FakeWriter) GetMessages() []string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return append([]string{}, *fw.messages...)
+}
+
+// BlockingWriter - writer which hangs on each write until Release is called
+type BlockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func NewBlockingWriter() *BlockingWriter {
+	return &BlockingWriter{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (bw BlockingWriter) Write(b []byte) (int, error) {
+	bw.started <- struct{}{}
+	<-bw.release
+	return len(b), nil
+}
+
+func (bw BlockingWriter) Close() error {
+	return nil
+}
+
+// Started - channel receiving a value each time a write begins
+func (bw BlockingWriter) Started() <-chan struct{} {
+	return bw.started
+}
+
+// Release - unlock all pending and future writes
+func (bw BlockingWriter) Release() {
+	close(bw.release)
+}
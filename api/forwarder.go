@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,9 +12,11 @@ import (
 
 	"github.com/orange-cloudfoundry/logs-service-broker/dbservices"
 	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
 	"github.com/orange-cloudfoundry/logs-service-broker/utils"
 
 	"github.com/gorilla/mux"
+	"github.com/influxdata/go-syslog/v3/rfc5424"
 	"github.com/orange-cloudfoundry/logs-service-broker/model"
 	"github.com/orange-cloudfoundry/logs-service-broker/parser"
 	"github.com/prometheus/client_golang/prometheus"
@@ -27,10 +31,16 @@ type Forwarder struct {
 	parser     *parser.Parser
 	config     *model.ForwarderConfig
 	authorizer AuthorizeFunc
+	queue      *forwardQueue
+	plans      model.SyslogAddresses
+	limiter    *rateLimiter
+	multiline  *parser.Multiline
 }
 
 // NewForwarder -
 // 1. compute once for all the authorization function instead of switching at each requests
+// 2. set schema of fields, redaction, mutations and timestamp extraction for each plan, logs of plans with invalid redaction are not sent
+// 3. start workers consuming forward queue, multi-line events are forwarded on timeout outside of them
 func NewForwarder(
 	cacher *dbservices.MetaCacher,
 	writers map[string]io.WriteCloser,
@@ -42,20 +52,109 @@ func NewForwarder(
 		parser:     parser.NewParser(config.Forwarder.ParsingKeys, config.Forwarder.IgnoreTagsStructuredData),
 		config:     &config.Forwarder,
 		authorizer: alwaysAuthorized,
+		queue:      newForwardQueue(&config.Forwarder.Queue),
+		plans:      config.SyslogAddresses,
+		limiter:    &rateLimiter{},
 	}
 
 	// 1.
 	if len(config.Forwarder.AllowedHosts) != 0 {
 		f.authorizer = f.isAuthorized
 	}
+
+	// 2.
+	for _, plan := range config.SyslogAddresses {
+		if err := f.parser.SetPlanSchema(plan.Name, plan.GetSchema()); err != nil {
+			logrus.Warnf("plan '%s': keeping native fields: %s", plan.Name, err.Error())
+		}
+		if err := f.parser.SetPlanRedaction(plan.Name, plan.Redaction); err != nil {
+			logrus.Errorf("plan '%s': invalid redaction: %s", plan.Name, err.Error())
+		}
+		if err := f.parser.SetPlanMutations(plan.Name, plan.Mutate); err != nil {
+			logrus.Warnf("plan '%s': fields are not mutated: %s", plan.Name, err.Error())
+		}
+		if err := f.parser.SetPlanTimestamp(plan.Name, plan.Timestamp); err != nil {
+			logrus.Warnf("plan '%s': timestamp is not extracted: %s", plan.Name, err.Error())
+		}
+	}
+
+	// 3.
+	f.multiline = parser.NewMultiline(f.forwardMultiline)
+	f.queue.start(f.forwardJob)
 	return f
 }
 
+// Forward - parse message and forward it
 func (f Forwarder) Forward(bindingID string, rev int, message []byte) error {
-	if len(message) == 0 {
+	return f.forward(f.newForwardJob(bindingID, rev, message, nil, 0))
+}
+
+// newForwardJob - job of message parsed as rfc 5424, it is parsed only once until sent when not already given
+func (f Forwarder) newForwardJob(bindingID string, rev int, message []byte, parsed *rfc5424.SyslogMessage, index int) forwardJob {
+	job := forwardJob{
+		bindingID: bindingID,
+		rev:       rev,
+		message:   message,
+		parsed:    parsed,
+		index:     index,
+	}
+	if parsed == nil && len(message) > 0 {
+		job.parsed, job.parseErr = f.parser.ParseSyslog(message)
+	}
+	return job
+}
+
+// forward -
+// 1. drop message above rate limits
+// 2. aggregate lines of multi-line events when requested by instance, nothing is sent until event is complete,
+// lines of a binding and app instance are given in order as their jobs are consumed by a single worker
+// 3. build and send message
+func (f Forwarder) forward(job forwardJob) error {
+	if len(job.message) == 0 {
+		return nil
+	}
+	bindingID, rev := job.bindingID, job.rev
+	meta, labels, err := f.logMetadata(bindingID, rev, job.parsed)
+	if err != nil {
+		return err
+	}
+
+	// 1.
+	if scope, ok := f.allowRate(meta, len(job.message)); !ok {
+		metrics.LogsRateLimited.WithLabelValues(
+			labels["instance_id"], bindingID, labels["plan_name"], scope,
+		).Inc()
 		return nil
 	}
-	org, space, app := f.parser.ParseHostFromMessage(message)
+	if job.parseErr != nil {
+		metrics.LogsSentFailure.With(labels).Inc()
+		return job.parseErr
+	}
+
+	// 2.
+	message := job.parsed
+	if params := meta.InstanceParam.Multiline(); !params.IsZero() {
+		rule, err := f.multiline.Rule(params)
+		if err != nil {
+			metrics.LogsSentFailure.With(labels).Inc()
+			return err
+		}
+		message = f.multiline.Add(bindingID, rev, rule, message)
+		if message == nil {
+			return nil
+		}
+	}
+
+	// 3.
+	return f.send(meta, labels, message)
+}
+
+// logMetadata - metadata of binding with labels of metrics for message, message is nil when it is not valid
+func (f Forwarder) logMetadata(bindingID string, rev int, message *rfc5424.SyslogMessage) (*model.LogMetadata, prometheus.Labels, error) {
+	var org, space, app string
+	if message != nil && message.Hostname != nil {
+		org, space, app = f.parser.ParseHost(message)
+	}
 	labels := prometheus.Labels{
 		"instance_id": "",
 		"binding_id":  bindingID,
@@ -68,11 +167,27 @@ func (f Forwarder) Forward(bindingID string, rev int, message []byte) error {
 	meta, err := f.cacher.LogMetadata(bindingID, rev, labels)
 	if err != nil {
 		metrics.LogsSentFailure.With(labels).Inc()
-		return err
+		return nil, labels, err
 	}
 	labels["instance_id"] = meta.InstanceParam.InstanceID
 	labels["plan_name"] = meta.InstanceParam.SyslogName
+	return meta, labels, nil
+}
 
+// forwardMultiline - send multi-line event completed on timeout
+func (f Forwarder) forwardMultiline(bindingID string, rev int, message *rfc5424.SyslogMessage) {
+	meta, labels, err := f.logMetadata(bindingID, rev, message)
+	if err == nil {
+		err = f.send(meta, labels, message)
+	}
+	if err != nil {
+		logrus.WithField("binding_id", bindingID).Error(err.Error())
+	}
+}
+
+// send - build message from rfc 5424 one received and write it to syslog writer of plan
+func (f Forwarder) send(meta *model.LogMetadata, labels prometheus.Labels, message *rfc5424.SyslogMessage) error {
+	bindingID := meta.BindingID
 	// catch panic to prevent exit
 	defer func() {
 		if r := recover(); r != nil {
@@ -89,7 +204,11 @@ func (f Forwarder) Forward(bindingID string, rev int, message []byte) error {
 		patterns = append(patterns, model.Patterns(meta.InstanceParam.Patterns).ToList()...)
 	}
 
-	parsed, err := f.parser.Parse(meta, message, patterns)
+	parsed, data, err := f.parser.ParseMessageWithData(meta, message, patterns)
+	if errors.Is(err, parser.ErrDropped) {
+		metrics.LogsDroppedByRules.WithLabelValues(labels["instance_id"], bindingID, labels["plan_name"]).Inc()
+		return nil
+	}
 	if err != nil {
 		metrics.LogsSentFailure.With(labels).Inc()
 		return err
@@ -111,7 +230,7 @@ func (f Forwarder) Forward(bindingID string, rev int, message []byte) error {
 		return err
 	}
 
-	_, err = writer.Write([]byte(fMes))
+	_, err = syslog.WriteWithKey(writer, bindingID, []byte(fMes), &syslog.Parsed{Message: parsed, Data: data, Labels: labels})
 	if err != nil {
 		metrics.LogsSentFailure.With(labels).Inc()
 		return err
@@ -121,6 +240,48 @@ func (f Forwarder) Forward(bindingID string, rev int, message []byte) error {
 	return nil
 }
 
+// allowRate - check message against effective rate limits of instance and binding
+func (f Forwarder) allowRate(meta *model.LogMetadata, size int) (string, bool) {
+	plan, err := f.plans.FoundSyslogWriter(meta.InstanceParam.SyslogName)
+	if err != nil {
+		return "", true
+	}
+	limits := plan.EffectiveRateLimits(&meta.InstanceParam)
+	if limits.IsZero() {
+		return "", true
+	}
+	return f.limiter.allow(meta.InstanceID, meta.BindingID, limits, size)
+}
+
+// Enqueue -
+// Add a message in forward queue, it will be given to Forward by a worker.
+// This is the entrypoint for ingress other than http, message is parsed again when parsed form is nil.
+func (f Forwarder) Enqueue(bindingID string, rev int, message []byte, parsed *rfc5424.SyslogMessage) error {
+	return f.queue.push(f.newForwardJob(bindingID, rev, message, parsed, 0))
+}
+
+// Shutdown -
+// Stop accepting logs and wait for queued ones to be forwarded until context is done.
+// Pending multi-line events are forwarded once queue is drained.
+// This must be called before closing writers.
+func (f Forwarder) Shutdown(ctx context.Context) error {
+	err := f.queue.close(ctx)
+	f.multiline.Close()
+	return err
+}
+
+func (f Forwarder) forwardJob(job forwardJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("binding_id", job.bindingID).Error(r)
+		}
+	}()
+	err := f.forward(job)
+	if err != nil {
+		logrus.WithField("binding_id", job.bindingID).WithField("message_index", job.index).Error(err.Error())
+	}
+}
+
 func (f Forwarder) foundWriter(writerName string) (io.WriteCloser, error) {
 	w, ok := f.sw[writerName]
 	if !ok {
@@ -149,10 +310,7 @@ func (f Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer utils.CloseAndLogError(r.Body)
 
 	if !f.authorizer(r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if _, err := w.Write([]byte(http.StatusText(http.StatusUnauthorized))); err != nil {
-			logrus.Errorf("failed to write response: %v", err)
-		}
+		f.writeStatus(w, http.StatusUnauthorized)
 		return
 	}
 
@@ -178,15 +336,39 @@ func (f Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	b, _ := io.ReadAll(r.Body)
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.WithField("binding_id", bindingId).Panic(r)
-			}
-		}()
-		err := f.Forward(bindingId, rev, b)
+	messages, err := syslog.SplitFrames(b)
+	if err != nil {
+		metrics.LogsInvalidFrames.WithLabelValues(bindingId).Inc()
+		logrus.WithField("binding_id", bindingId).Warnf(
+			"invalid batch received, %d message(s) before error will be forwarded: %s", len(messages), err.Error(),
+		)
+		if len(messages) == 0 {
+			f.writeStatus(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var pushErr error
+	for i, message := range messages {
+		err := f.queue.push(f.newForwardJob(bindingId, rev, message, nil, i))
 		if err != nil {
-			logrus.WithField("binding_id", bindingId).Error(err.Error())
+			logrus.WithField("binding_id", bindingId).WithField("message_index", i).Debug(err.Error())
+			if pushErr == nil {
+				pushErr = err
+			}
 		}
-	}()
+	}
+	switch {
+	case errors.Is(pushErr, ErrQueueRejected):
+		f.writeStatus(w, f.config.Queue.GetRejectStatusCode())
+	case errors.Is(pushErr, ErrQueueClosed):
+		f.writeStatus(w, http.StatusServiceUnavailable)
+	}
+}
+
+func (f Forwarder) writeStatus(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	if _, err := w.Write([]byte(http.StatusText(code))); err != nil {
+		logrus.Errorf("failed to write response: %v", err)
+	}
 }
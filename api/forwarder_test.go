@@ -2,10 +2,13 @@ package api_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -13,10 +16,13 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/orange-cloudfoundry/logs-service-broker/api"
 	"github.com/orange-cloudfoundry/logs-service-broker/api/fakes"
 	"github.com/orange-cloudfoundry/logs-service-broker/dbservices"
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
 	"github.com/orange-cloudfoundry/logs-service-broker/model"
 )
 
@@ -26,8 +32,32 @@ var _ = Describe("Forwarder", func() {
 	var db *gorm.DB
 	var err error
 	var writers map[string]io.WriteCloser
+	var cacher *dbservices.MetaCacher
 	var serviceID = "ad45d7cc-4795-4554"
 	var bindingID = "125ce4a5-7845-14ae"
+	var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [metrics] - [timer@47450 name="my-timer" start="0" stop="10"] - app.hbx.geo.francetelecom.fr:443`
+
+	sendBodyTo := func(bindingID, body string) int {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/%s?rev=4", bindingID), bytes.NewBufferString(body))
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = "logservice.private.domain:8089"
+		r := mux.NewRouter()
+		rr := httptest.NewRecorder()
+		r.HandleFunc("/{bindingId}", forwarder.ServeHTTP)
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	sendBody := func(body string) int {
+		return sendBodyTo(bindingID, body)
+	}
+	sendMessage := func() int {
+		return sendBody(message)
+	}
+	// replaceForwarder - shutdown forwarder built for each test before using one with given config
+	replaceForwarder := func(config *model.Config) {
+		Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+		forwarder = api.NewForwarder(cacher, writers, config)
+	}
 
 	BeforeEach(func() {
 		//init BDD
@@ -57,7 +87,7 @@ var _ = Describe("Forwarder", func() {
 		Expect(result2.Error).To(BeNil())
 
 		// init forwarder
-		var cacher, err = dbservices.NewMetaCacher(db, "5m")
+		cacher, err = dbservices.NewMetaCacher(db, "5m")
 		Expect(err).ToNot(HaveOccurred())
 
 		writers = make(map[string]io.WriteCloser)
@@ -78,6 +108,8 @@ var _ = Describe("Forwarder", func() {
 	})
 
 	AfterEach(func() {
+		// nolint:errcheck
+		forwarder.Shutdown(context.Background())
 		// shutdown all servers
 		db.Exec("DELETE FROM log_metadata;")
 		db.Exec("DELETE FROM instance_params;")
@@ -88,7 +120,6 @@ var _ = Describe("Forwarder", func() {
 	Context("When a message is received", func() {
 
 		It("formards the message", func() {
-			var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [metrics] - [timer@47450 name="my-timer" start="0" stop="10"] - app.hbx.geo.francetelecom.fr:443`
 			var forwardedMessage = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [metrics] - [logsbroker@1368 app="org/space/app" app_id="3" app_name="app" org="org" org_id="1" space="space" space_id="2"] {"@cf":{"app":"app","app_id":"3","app_instance":0,"org":"org","org_id":"1","space":"space","space_id":"2"},"@input":"syslog","@level":"INFO","@metric":{"name":"my-timer","start":0,"stop":10,"type":"timer"},"@shipper":{"name":"log-service","priority":14},"@source":{"details":"","type":"metrics"},"@timestamp":"2006-01-02T15:04:05.999999Z","@type":"Metrics"}
 `
 			req, err := http.NewRequest("GET", fmt.Sprintf("/%s?rev=4", bindingID), bytes.NewBufferString(message))
@@ -107,4 +138,343 @@ var _ = Describe("Forwarder", func() {
 			Expect(*(writers["loghost"].(*fakes.FakeWriter).GetBuffer())).To(Equal(forwardedMessage))
 		})
 	})
+
+	Context("When a batch of octet counted messages is received", func() {
+		var other = `<14>1 2006-01-02T15:04:06.999999Z org.space.app - [APP/PROC/WEB/0] - - my log`
+
+		frame := func(msg string) string {
+			return fmt.Sprintf("%d %s", len(msg), msg)
+		}
+
+		It("forwards each message separately", func() {
+			Expect(sendBody(frame(message) + frame(other))).To(Equal(http.StatusOK))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			messages := writers["loghost"].(*fakes.FakeWriter).GetMessages()
+			Expect(messages).To(HaveLen(2))
+			Expect(messages).To(ContainElement(ContainSubstring(`"@metric":{"name":"my-timer"`)))
+			Expect(messages).To(ContainElement(ContainSubstring("my log")))
+		})
+
+		It("forwards messages found before an invalid frame", func() {
+			Expect(sendBody(frame(message) + "999 " + other)).To(Equal(http.StatusOK))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(HaveLen(1))
+		})
+
+		It("answers bad request when no valid frame is found", func() {
+			Expect(sendBody("999 " + other)).To(Equal(http.StatusBadRequest))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(BeEmpty())
+		})
+	})
+
+	Context("When rate limit is reached", func() {
+		var config *model.Config
+
+		BeforeEach(func() {
+			config = &model.Config{
+				SyslogAddresses: []model.SyslogAddress{
+					{
+						Name: "loghost",
+						RateLimit: model.RateLimits{
+							Binding: model.RateLimit{MessagesPerSecond: 3},
+						},
+					},
+				},
+			}
+		})
+
+		It("drops messages above plan limits", func() {
+			replaceForwarder(config)
+			for i := 0; i < 5; i++ {
+				Expect(sendMessage()).To(Equal(http.StatusOK))
+			}
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(HaveLen(3))
+		})
+
+		It("drops messages above limits lowered by user", func() {
+			db.Model(&model.InstanceParam{}).
+				Where("instance_id = ?", serviceID).
+				Update("binding_messages_rate", 1)
+			replaceForwarder(config)
+			for i := 0; i < 5; i++ {
+				Expect(sendMessage()).To(Equal(http.StatusOK))
+			}
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(HaveLen(1))
+		})
+
+		It("ignores user limits higher than plan limits", func() {
+			db.Model(&model.InstanceParam{}).
+				Where("instance_id = ?", serviceID).
+				Update("binding_messages_rate", 100)
+			replaceForwarder(config)
+			for i := 0; i < 5; i++ {
+				Expect(sendMessage()).To(Equal(http.StatusOK))
+			}
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(HaveLen(3))
+		})
+
+		It("does not count messages dropped by binding limits in instance limits", func() {
+			otherBindingID := "98a1bd3c-1c4e-4a2f"
+			Expect(db.Create(&model.LogMetadata{
+				InstanceID: serviceID,
+				BindingID:  otherBindingID,
+				AppID:      "4",
+			}).Error).To(BeNil())
+			config.SyslogAddresses[0].RateLimit = model.RateLimits{
+				Instance: model.RateLimit{MessagesPerSecond: 3},
+				Binding:  model.RateLimit{MessagesPerSecond: 1},
+			}
+			replaceForwarder(config)
+			for i := 0; i < 3; i++ {
+				Expect(sendMessage()).To(Equal(http.StatusOK))
+			}
+			Expect(sendBodyTo(otherBindingID, message)).To(Equal(http.StatusOK))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			Expect(writers["loghost"].(*fakes.FakeWriter).GetMessages()).To(HaveLen(2))
+		})
+	})
+
+	Context("When multi-line aggregation is requested by user", func() {
+
+		frameOf := func(instance int, msg string) string {
+			msg = fmt.Sprintf(`<14>1 2006-01-02T15:04:06.999999Z org.space.app - [APP/PROC/WEB/%d] - - %s`, instance, msg)
+			return fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		frame := func(msg string) string {
+			return frameOf(0, msg)
+		}
+
+		It("forwards lines of a stack trace as a single message", func() {
+			db.Model(&model.InstanceParam{}).
+				Where("instance_id = ?", serviceID).
+				Update("multiline_preset", "java")
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{Workers: 1},
+				},
+			})
+			Expect(sendBody(frame("java.lang.IllegalStateException: boom") +
+				frame("\tat com.example.Foo.bar(Foo.java:12)") +
+				frame("next log"))).To(Equal(http.StatusOK))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			messages := writers["loghost"].(*fakes.FakeWriter).GetMessages()
+			Expect(messages).To(HaveLen(2))
+			Expect(messages[0]).To(ContainSubstring(`"@message":"java.lang.IllegalStateException: boom\n\tat com.example.Foo.bar(Foo.java:12)"`))
+			Expect(messages[1]).To(ContainSubstring(`"@message":"next log"`))
+		})
+
+		It("keeps lines of each app instance in order with several workers", func() {
+			db.Model(&model.InstanceParam{}).
+				Where("instance_id = ?", serviceID).
+				Update("multiline_preset", "java")
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{Workers: 8},
+				},
+			})
+			body := ""
+			for instance := 0; instance < 2; instance++ {
+				body += frameOf(instance, fmt.Sprintf("java.lang.IllegalStateException: boom %d", instance))
+			}
+			var traces [2]string
+			for i := 0; i < 50; i++ {
+				for instance := 0; instance < 2; instance++ {
+					line := fmt.Sprintf("\tat com.example.Foo.bar(Foo.java:%d)", i)
+					body += frameOf(instance, line)
+					traces[instance] += `\n` + strings.ReplaceAll(line, "\t", `\t`)
+				}
+			}
+			for instance := 0; instance < 2; instance++ {
+				body += frameOf(instance, "next log")
+			}
+			Expect(sendBody(body)).To(Equal(http.StatusOK))
+			Expect(forwarder.Shutdown(context.Background())).To(Succeed())
+
+			messages := writers["loghost"].(*fakes.FakeWriter).GetMessages()
+			Expect(messages).To(HaveLen(4))
+			for instance := 0; instance < 2; instance++ {
+				Expect(messages).To(ContainElement(ContainSubstring(
+					fmt.Sprintf(`"@message":"java.lang.IllegalStateException: boom %d%s"`, instance, traces[instance]))))
+			}
+		})
+	})
+
+	Context("When forward queue is full", func() {
+		var blockingWriter *fakes.BlockingWriter
+
+		BeforeEach(func() {
+			blockingWriter = fakes.NewBlockingWriter()
+			writers["loghost"] = blockingWriter
+		})
+
+		AfterEach(func() {
+			blockingWriter.Release()
+		})
+
+		It("answers configured status code to drain when policy is reject", func() {
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{
+						Size:             1,
+						Workers:          1,
+						OverflowPolicy:   model.OverflowReject,
+						RejectStatusCode: http.StatusServiceUnavailable,
+					},
+				},
+			})
+
+			// first message is taken by the only worker which hangs on writer
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+			// second message fills the queue
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Expect(sendMessage()).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("stops waiting for room in queue when forwarder is shutdown", func() {
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{
+						Size:           1,
+						Workers:        1,
+						OverflowPolicy: model.OverflowBlock,
+						BlockTimeout:   "1m",
+					},
+				},
+			})
+
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			blocked := make(chan int, 1)
+			go func() {
+				defer GinkgoRecover()
+				blocked <- sendMessage()
+			}()
+			Consistently(blocked, 50*time.Millisecond).ShouldNot(Receive())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(forwarder.Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+			Eventually(blocked, time.Second).Should(Receive(Equal(http.StatusServiceUnavailable)))
+		})
+
+		It("silently drops message when policy is drop-newest", func() {
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{
+						Size:           1,
+						Workers:        1,
+						OverflowPolicy: model.OverflowDropNewest,
+					},
+				},
+			})
+
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+		})
+
+		It("only applies policy on part of queue of worker of a noisy app instance", func() {
+			counterValue := func(counter prometheus.Counter) float64 {
+				m := &dto.Metric{}
+				Expect(counter.Write(m)).To(Succeed())
+				return m.GetCounter().GetValue()
+			}
+			sendFrom := func(instance int) int {
+				return sendBody(fmt.Sprintf(`<14>1 2006-01-02T15:04:06.999999Z org.space.app - [APP/PROC/WEB/%d] - - my log`, instance))
+			}
+			// logs of an app instance are dispatched on workers as done by forward queue
+			workerOf := func(instance int) uint32 {
+				h := fnv.New32a()
+				_, _ = h.Write([]byte(fmt.Sprintf("%s~[APP/PROC/WEB/%d]", bindingID, instance)))
+				return h.Sum32() % 2
+			}
+			other := 1
+			for workerOf(other) == workerOf(0) {
+				other++
+			}
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{
+						Size:             4,
+						Workers:          2,
+						OverflowPolicy:   model.OverflowReject,
+						RejectStatusCode: http.StatusServiceUnavailable,
+					},
+				},
+			})
+			dropped := metrics.ForwardQueueDropped.WithLabelValues(model.OverflowReject)
+			before := counterValue(dropped)
+
+			// worker of noisy instance hangs on writer, its 2 places in queue are then filled
+			Expect(sendFrom(0)).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+			Expect(sendFrom(0)).To(Equal(http.StatusOK))
+			Expect(sendFrom(0)).To(Equal(http.StatusOK))
+			Expect(sendFrom(0)).To(Equal(http.StatusServiceUnavailable))
+			Expect(counterValue(dropped) - before).To(BeEquivalentTo(1))
+
+			// other instance keeps places of its worker while half of queue is full
+			Expect(sendFrom(other)).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+			Expect(sendFrom(other)).To(Equal(http.StatusOK))
+			Expect(sendFrom(other)).To(Equal(http.StatusOK))
+			Expect(counterValue(dropped) - before).To(BeEquivalentTo(1))
+			Expect(sendFrom(other)).To(Equal(http.StatusServiceUnavailable))
+			Expect(counterValue(dropped) - before).To(BeEquivalentTo(2))
+		})
+	})
+
+	Context("When forwarder is shutdown", func() {
+
+		It("forwards queued messages before returning", func() {
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+
+			err := forwarder.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*(writers["loghost"].(*fakes.FakeWriter).GetBuffer())).ToNot(BeEmpty())
+		})
+
+		It("rejects messages received after shutdown", func() {
+			err := forwarder.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sendMessage()).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("gives up when deadline is reached", func() {
+			blockingWriter := fakes.NewBlockingWriter()
+			defer blockingWriter.Release()
+			writers["loghost"] = blockingWriter
+			replaceForwarder(&model.Config{
+				Forwarder: model.ForwarderConfig{
+					Queue: model.QueueConfig{
+						Size:    10,
+						Workers: 1,
+					},
+				},
+			})
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Expect(sendMessage()).To(Equal(http.StatusOK))
+			Eventually(blockingWriter.Started()).Should(Receive())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := forwarder.Shutdown(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
 })
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	"github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+var (
+	ErrQueueFull     = errors.New("forward queue is full, log has been dropped")
+	ErrQueueRejected = errors.New("forward queue is full, log has been rejected")
+	ErrQueueClosed   = errors.New("forward queue is closed, log has been rejected")
+)
+
+type forwardJob struct {
+	bindingID string
+	rev       int
+	message   []byte
+	// parsed - message parsed once when received, nil with parseErr when it is not a valid rfc 5424 message
+	parsed   *rfc5424.SyslogMessage
+	parseErr error
+	// position of message in received batch
+	index int
+}
+
+// key - jobs of a binding and app instance are given to the same worker
+func (j forwardJob) key() string {
+	if j.parsed == nil || j.parsed.ProcID == nil {
+		return j.bindingID
+	}
+	return j.bindingID + "~" + *j.parsed.ProcID
+}
+
+// forwardQueue -
+// bounded queue between http handler and forward, consumed by a fixed pool of workers.
+// Each worker consumes its own part of queue where jobs are dispatched by key, jobs of a binding and app instance
+// are forwarded in order they were received, e.g. for aggregating lines of multi-line events.
+// Overflow policy applies when part of a worker is full, other parts may still have room.
+type forwardQueue struct {
+	shards       []chan forwardJob
+	policy       string
+	blockTimeout time.Duration
+	mu           sync.RWMutex // guards closed and jobs closing
+	closed       bool
+	// done - closed first when closing for waking up pushes blocked on a full queue
+	done      chan struct{}
+	closeOnce sync.Once
+	aborted   atomic.Bool
+	inFlight  atomic.Int64
+	wg        sync.WaitGroup
+}
+
+// newForwardQueue - queue size is shared between workers, each of them gets at least one place
+func newForwardQueue(config *model.QueueConfig) *forwardQueue {
+	workers := config.GetWorkers()
+	shardSize := max((config.GetSize()+workers-1)/workers, 1)
+	q := &forwardQueue{
+		shards:       make([]chan forwardJob, workers),
+		policy:       config.GetOverflowPolicy(),
+		blockTimeout: *config.GetBlockTimeout(),
+		done:         make(chan struct{}),
+	}
+	for i := range q.shards {
+		q.shards[i] = make(chan forwardJob, shardSize)
+	}
+	metrics.ForwardQueueCapacity.Set(float64(shardSize * workers))
+	return q
+}
+
+// shard - part of queue of job key
+func (q *forwardQueue) shard(job forwardJob) chan forwardJob {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(job.key()))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
+// depth - number of jobs waiting in queue
+func (q *forwardQueue) depth() int {
+	depth := 0
+	for _, shard := range q.shards {
+		depth += len(shard)
+	}
+	return depth
+}
+
+// start -
+// run the pool of workers, each of them calling handler on jobs of its part of queue
+func (q *forwardQueue) start(handler func(forwardJob)) {
+	q.wg.Add(len(q.shards))
+	for _, shard := range q.shards {
+		go func() {
+			defer q.wg.Done()
+			for job := range shard {
+				metrics.ForwardQueueDepth.Set(float64(q.depth()))
+				if q.aborted.Load() {
+					metrics.ForwardShutdownLost.Inc()
+					continue
+				}
+				q.inFlight.Add(1)
+				handler(job)
+				q.inFlight.Add(-1)
+			}
+		}()
+	}
+}
+
+// close -
+// 1. stop accepting new jobs, pushes waiting for room are released before taking lock
+// 2. wait for workers to forward all remaining jobs
+// 3. when context is done before, remaining jobs are dropped and counted as lost
+func (q *forwardQueue) close(ctx context.Context) error {
+	// 1.
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	for _, shard := range q.shards {
+		close(shard)
+	}
+	q.mu.Unlock()
+
+	// 2.
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// 3.
+	q.aborted.Store(true)
+	lost := 0
+	for _, shard := range q.shards {
+		for range shard {
+			metrics.ForwardShutdownLost.Inc()
+			lost++
+		}
+	}
+	metrics.ForwardQueueDepth.Set(0)
+	logrus.Warnf("forward queue drain interrupted: %d queued logs dropped, %d logs still in flight", lost, q.inFlight.Load())
+	return ctx.Err()
+}
+
+// push -
+// add a job in part of queue of its key, when it is full configured overflow policy is applied:
+// - drop-newest: given job is dropped
+// - drop-oldest: oldest job in queue is dropped to make room for the given one
+// - block: wait for room in queue until block timeout is reached, given job is dropped after
+// - reject: given job is dropped and ErrQueueRejected is returned for letting caller answer to drain
+func (q *forwardQueue) push(job forwardJob) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	defer func() {
+		metrics.ForwardQueueDepth.Set(float64(q.depth()))
+	}()
+
+	jobs := q.shard(job)
+	select {
+	case jobs <- job:
+		return nil
+	default:
+	}
+
+	switch q.policy {
+	case model.OverflowDropOldest:
+		return q.pushDropOldest(jobs, job)
+	case model.OverflowBlock:
+		return q.pushBlock(jobs, job)
+	case model.OverflowReject:
+		metrics.ForwardQueueDropped.WithLabelValues(q.policy).Inc()
+		return ErrQueueRejected
+	}
+	metrics.ForwardQueueDropped.WithLabelValues(q.policy).Inc()
+	return ErrQueueFull
+}
+
+func (q *forwardQueue) pushDropOldest(jobs chan forwardJob, job forwardJob) error {
+	for {
+		select {
+		case <-jobs:
+			metrics.ForwardQueueDropped.WithLabelValues(q.policy).Inc()
+		default:
+		}
+		select {
+		case jobs <- job:
+			return nil
+		default:
+		}
+	}
+}
+
+// pushBlock - wait for room in queue, waiting stops as soon as queue is closing for not delaying shutdown
+func (q *forwardQueue) pushBlock(jobs chan forwardJob, job forwardJob) error {
+	timer := time.NewTimer(q.blockTimeout)
+	defer timer.Stop()
+	select {
+	case jobs <- job:
+		return nil
+	case <-timer.C:
+		metrics.ForwardQueueDropped.WithLabelValues(q.policy).Inc()
+		return ErrQueueFull
+	case <-q.done:
+		return ErrQueueClosed
+	}
+}
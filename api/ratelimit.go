@@ -0,0 +1,181 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	RateLimitScopeInstance = "instance"
+	RateLimitScopeBinding  = "binding"
+)
+
+// limiterIdleTTL - limiters of instances and bindings without logs since this duration are forgotten
+const limiterIdleTTL = 10 * time.Minute
+
+// tokenBucket -
+// bucket refilled at rate tokens per second holding at most one second of tokens.
+// A value bigger than bucket capacity, e.g. a large message on bytes limit,
+// is allowed when bucket is full and puts the bucket in debt.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// available - refill bucket and tell if n tokens can be taken, bucket must be locked
+func (b *tokenBucket) available(now time.Time, n int) bool {
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	return b.tokens >= min(float64(n), b.rate)
+}
+
+// idle - tell if bucket is full and unused since ttl, bucket must be locked
+func (b *tokenBucket) idle(now time.Time, ttl time.Duration) bool {
+	elapsed := now.Sub(b.last)
+	return elapsed >= ttl && b.tokens+elapsed.Seconds()*b.rate >= b.rate
+}
+
+// limiter - buckets for messages and bytes of a single instance or binding
+type limiter struct {
+	limit    model.RateLimit
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newLimiter(limit model.RateLimit) *limiter {
+	l := &limiter{limit: limit}
+	if limit.MessagesPerSecond > 0 {
+		l.messages = newTokenBucket(limit.MessagesPerSecond)
+	}
+	if limit.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(limit.BytesPerSecond)
+	}
+	return l
+}
+
+// idle - tell if all buckets of limiter are full and unused since ttl
+func (l *limiter) idle(now time.Time, ttl time.Duration) bool {
+	for _, b := range []*tokenBucket{l.messages, l.bytes} {
+		if b == nil {
+			continue
+		}
+		b.mu.Lock()
+		idle := b.idle(now, ttl)
+		b.mu.Unlock()
+		if !idle {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketTake - tokens to take from a bucket for a message
+type bucketTake struct {
+	scope  string
+	bucket *tokenBucket
+	n      int
+}
+
+func (l *limiter) takes(scope string, size int) []bucketTake {
+	if l == nil {
+		return nil
+	}
+	takes := make([]bucketTake, 0, 2)
+	if l.messages != nil {
+		takes = append(takes, bucketTake{scope: scope, bucket: l.messages, n: 1})
+	}
+	if l.bytes != nil {
+		takes = append(takes, bucketTake{scope: scope, bucket: l.bytes, n: size})
+	}
+	return takes
+}
+
+// rateLimiter -
+// keep a limiter for each instance and binding, limiter is recreated when limits have changed
+// and removed when idle to not keep limiters of deleted instances and bindings
+type rateLimiter struct {
+	limiters  sync.Map
+	lastSweep atomic.Int64
+}
+
+// allow -
+// 0. remove idle limiters from time to time, before locking any bucket
+// 1. gather buckets of instance limits first and binding limits after
+// 2. lock all buckets, always in the same order, to check and take tokens at once
+// 3. check every bucket, scope of the reached limit is returned when message must be dropped
+// 4. take tokens only when message is allowed by all buckets
+func (r *rateLimiter) allow(instanceID, bindingID string, limits model.RateLimits, size int) (string, bool) {
+	// 0.
+	r.sweep(time.Now())
+
+	// 1.
+	takes := append(
+		r.limiter(RateLimitScopeInstance+"~"+instanceID, limits.Instance).takes(RateLimitScopeInstance, size),
+		r.limiter(RateLimitScopeBinding+"~"+bindingID, limits.Binding).takes(RateLimitScopeBinding, size)...,
+	)
+
+	// 2.
+	for _, t := range takes {
+		t.bucket.mu.Lock()
+		defer t.bucket.mu.Unlock()
+	}
+
+	// 3.
+	now := time.Now()
+	for _, t := range takes {
+		if !t.bucket.available(now, t.n) {
+			return t.scope, false
+		}
+	}
+
+	// 4.
+	for _, t := range takes {
+		t.bucket.tokens -= float64(t.n)
+	}
+	return "", true
+}
+
+// sweep - remove limiters idle since limiterIdleTTL, at most once per limiterIdleTTL
+// an evicted limiter still in use is only replaced by a new full one which is what it was
+func (r *rateLimiter) sweep(now time.Time) {
+	last := r.lastSweep.Load()
+	if now.UnixNano()-last < int64(limiterIdleTTL) || !r.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	r.limiters.Range(func(key, iL any) bool {
+		if iL.(*limiter).idle(now, limiterIdleTTL) {
+			r.limiters.CompareAndDelete(key, iL)
+		}
+		return true
+	})
+}
+
+// limiter - limiter of given key, nil when there is no limit
+func (r *rateLimiter) limiter(key string, limit model.RateLimit) *limiter {
+	if limit.IsZero() {
+		return nil
+	}
+	iL, ok := r.limiters.Load(key)
+	if !ok {
+		iL, _ = r.limiters.LoadOrStore(key, newLimiter(limit))
+	}
+	l := iL.(*limiter)
+	if l.limit != limit {
+		l = newLimiter(limit)
+		r.limiters.Store(key, l)
+	}
+	return l
+}
@@ -114,7 +114,99 @@ func GormMigration() []*Migration {
 				return nil
 			},
 		},
+		{
+			ID: "add-rate-limits",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					InstanceMessagesRate int
+					InstanceBytesRate    int
+					BindingMessagesRate  int
+					BindingBytesRate     int
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db,
+					"instance_messages_rate", "instance_bytes_rate", "binding_messages_rate", "binding_bytes_rate")
+			},
+		},
+		{
+			ID: "add-multiline",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					MultilinePreset       string
+					MultilineStart        string `gorm:"size:600"`
+					MultilineContinuation string `gorm:"size:600"`
+					MultilineMaxLines     int
+					MultilineTimeout      string
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db,
+					"multiline_preset", "multiline_start", "multiline_continuation", "multiline_max_lines", "multiline_timeout")
+			},
+		},
+		{
+			ID: "add-rules",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					Rules string `gorm:"type:text"`
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db, "rules")
+			},
+		},
+		{
+			ID: "add-redaction",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					Redaction string `gorm:"type:text"`
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db, "redaction")
+			},
+		},
+		{
+			ID: "add-mutate",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					Mutate string `gorm:"type:text"`
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db, "mutate")
+			},
+		},
+		{
+			ID: "add-timestamp",
+			Migrate: func(db *gorm.DB, config *model.Config) error {
+				return addInstanceParamColumns(db, &struct {
+					TimestampExtraction string `gorm:"type:text"`
+				}{})
+			},
+			Rollback: func(db *gorm.DB, config *model.Config) error {
+				return dropInstanceParamColumns(db, "timestamp_extraction")
+			},
+		},
+	}
+}
+
+// addInstanceParamColumns - add columns of given fields to instance params table when they are missing,
+// only fields of a migration are given for not adding columns of later migrations
+func addInstanceParamColumns(db *gorm.DB, fields interface{}) error {
+	return db.Table("instance_params").AutoMigrate(fields).Error
+}
+
+// dropInstanceParamColumns - drop columns added by a migration from instance params table
+func dropInstanceParamColumns(db *gorm.DB, columns ...string) error {
+	for _, column := range columns {
+		err := db.Table("instance_params").DropColumn(column).Error
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func migrateLabels(db *gorm.DB, _ *model.Config) error {
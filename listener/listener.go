@@ -0,0 +1,322 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	TransportTCP = "tcp"
+	TransportTLS = "tls"
+)
+
+// ForwardFunc -
+// receive each message read on listener with its resolved binding and its rfc 5424 form,
+// parsed is nil when message is not valid for not parsing it again
+type ForwardFunc = func(bindingID string, rev int, message []byte, parsed *rfc5424.SyslogMessage) error
+
+// Listener -
+// native syslog ingress accepting RFC 5424 messages over tcp and tls with RFC 6587 framing.
+// Binding is resolved for each message from a structured data element, when not found,
+// from SNI host name given by client on tls connections.
+// Connections can be restricted to a list of networks, e.g. to trust structured data of known relays only.
+type Listener struct {
+	config     *model.ListenerConfig
+	forward    ForwardFunc
+	authorizer func(conn net.Conn) bool
+	networks   []*net.IPNet
+	mu         sync.Mutex
+	listeners  []net.Listener
+	conns      map[net.Conn]struct{}
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+// NewListener -
+// 1. compute once for all the authorization function instead of switching at each connections
+// 2. invalid networks are ignored, list is still enforced to not open listener to everyone
+func NewListener(config *model.ListenerConfig, forward ForwardFunc) *Listener {
+	l := &Listener{
+		config:     config,
+		forward:    forward,
+		authorizer: alwaysAuthorized,
+		conns:      make(map[net.Conn]struct{}),
+	}
+
+	// 1.
+	if len(config.AllowedNetworks) != 0 {
+		l.authorizer = l.isAuthorized
+	}
+
+	// 2.
+	for _, network := range config.AllowedNetworks {
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			log.Errorf("syslog listener: ignoring invalid allowed network '%s': %s", network, err.Error())
+			continue
+		}
+		l.networks = append(l.networks, ipNet)
+	}
+	return l
+}
+
+func alwaysAuthorized(_ net.Conn) bool {
+	return true
+}
+
+func (l *Listener) isAuthorized(conn net.Conn) bool {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start -
+// 1. listen on tcp port if set
+// 2. listen on tls port if certificate is given
+func (l *Listener) Start() error {
+	// 1.
+	if l.config.Port > 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", l.config.Port))
+		if err != nil {
+			return err
+		}
+		log.Infof("serving syslog on %s", ln.Addr())
+		l.Serve(ln)
+	}
+
+	// 2.
+	if l.config.HasTLS() {
+		cert, err := tls.LoadX509KeyPair(l.config.TLS.CertFile, l.config.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		ln, err := tls.Listen("tcp", fmt.Sprintf(":%d", l.config.TLS.Port), &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			return err
+		}
+		log.Infof("serving syslog-tls on %s", ln.Addr())
+		l.Serve(ln)
+	}
+	return nil
+}
+
+// Serve - accept connections on given listener in background until Shutdown is called
+func (l *Listener) Serve(ln net.Listener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, ln)
+	l.wg.Add(1)
+	go l.accept(ln)
+}
+
+// Shutdown -
+// 1. stop accepting new connections
+// 2. close opened connections, messages already read are kept in forwarder
+// 3. wait for connection handlers to end until context is done
+func (l *Listener) Shutdown(ctx context.Context) error {
+	// 1.
+	l.mu.Lock()
+	l.closed = true
+	for _, ln := range l.listeners {
+		utils.CloseAndLogError(ln)
+	}
+
+	// 2.
+	for conn := range l.conns {
+		utils.CloseAndLogError(conn)
+	}
+	l.mu.Unlock()
+
+	// 3.
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Listener) accept(ln net.Listener) {
+	defer l.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Errorf("syslog listener on %s stopped: %s", ln.Addr(), err.Error())
+			return
+		}
+		if !l.track(conn) {
+			utils.CloseAndLogError(conn)
+			return
+		}
+		go l.handle(conn)
+	}
+}
+
+func (l *Listener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.wg.Add(1)
+	return true
+}
+
+func (l *Listener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+}
+
+// handle -
+// 1. refuse connections from networks not allowed
+// 2. retrieve sni from tls handshake
+// 3. read frames until connection is closed or idle for too long
+// 4. resolve binding from parsed message and give message to forwarder
+func (l *Listener) handle(conn net.Conn) {
+	defer l.wg.Done()
+	defer l.untrack(conn)
+	defer utils.CloseAndLogError(conn)
+
+	// 1.
+	if !l.authorizer(conn) {
+		log.Debugf("syslog listener: refusing connection from %s", conn.RemoteAddr())
+		return
+	}
+
+	idleTimeout := *l.config.GetIdleTimeout()
+
+	// 2.
+	transport := TransportTCP
+	serverName := ""
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		transport = TransportTLS
+		_ = conn.SetDeadline(time.Now().Add(idleTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Debugf("syslog listener: tls handshake failed with %s: %s", conn.RemoteAddr(), err.Error())
+			return
+		}
+		serverName = tlsConn.ConnectionState().ServerName
+	}
+	metrics.ListenerConnections.WithLabelValues(transport).Inc()
+	defer metrics.ListenerConnections.WithLabelValues(transport).Dec()
+
+	// 3.
+	p := rfc5424.NewParser(rfc5424.WithBestEffort())
+	maxSize := l.config.GetMaxMessageSize()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxSize+len(strconv.Itoa(maxSize))+1)
+	scanner.Split(syslog.ScanFrames)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		message := append([]byte{}, scanner.Bytes()...)
+
+		// 4.
+		parsed, parseErr := p.Parse(message)
+		sm, _ := parsed.(*rfc5424.SyslogMessage)
+		bindingID, rev, ok := l.resolveBinding(sm, serverName)
+		if !ok {
+			metrics.ListenerUnresolved.WithLabelValues(transport).Inc()
+			log.Debugf("syslog listener: no binding found for message received from %s", conn.RemoteAddr())
+			continue
+		}
+		if parseErr != nil {
+			sm = nil
+		}
+		if err := l.forward(bindingID, rev, message, sm); err != nil {
+			log.WithField("binding_id", bindingID).Debug(err.Error())
+		}
+	}
+
+	err := scanner.Err()
+	var netErr net.Error
+	if err == nil || l.isClosed() || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return
+	}
+	bindingID, _ := model.ParseBindingHostLabel(hostLabel(serverName))
+	metrics.LogsInvalidFrames.WithLabelValues(bindingID).Inc()
+	log.WithField("binding_id", bindingID).Warnf("syslog listener: closing connection from %s: %s", conn.RemoteAddr(), err.Error())
+}
+
+// resolveBinding -
+//  1. look for binding id and revision in structured data element, e.g.: [binding@1368 id="my-binding" rev="2"]
+//     only structured data part of message is read, content of msg part can't target a binding
+//  2. fallback on first label of sni host name in format {bindingID}-r{rev}
+func (l *Listener) resolveBinding(sm *rfc5424.SyslogMessage, serverName string) (string, int, bool) {
+	// 1.
+	if sm != nil && sm.StructuredData != nil {
+		element := (*sm.StructuredData)[l.config.GetBindingSDID()]
+		if bindingID := element["id"]; bindingID != "" {
+			rev, _ := strconv.Atoi(element["rev"])
+			return bindingID, rev, true
+		}
+	}
+
+	// 2.
+	bindingID, rev := model.ParseBindingHostLabel(hostLabel(serverName))
+	return bindingID, rev, bindingID != ""
+}
+
+func hostLabel(serverName string) string {
+	return strings.Split(serverName, ".")[0]
+}
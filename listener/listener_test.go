@@ -0,0 +1,165 @@
+package listener_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/listener"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+type forwarded struct {
+	bindingID string
+	rev       int
+	message   string
+}
+
+var _ = Describe("Listener", func() {
+	var l *listener.Listener
+	var mu sync.Mutex
+	var received []forwarded
+	var parsedApps []string
+
+	getReceived := func() []forwarded {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]forwarded{}, received...)
+	}
+
+	BeforeEach(func() {
+		received = make([]forwarded, 0)
+		parsedApps = make([]string, 0)
+		l = listener.NewListener(&model.ListenerConfig{}, func(bindingID string, rev int, message []byte, parsed *rfc5424.SyslogMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, forwarded{bindingID, rev, string(message)})
+			if parsed != nil && parsed.Appname != nil {
+				parsedApps = append(parsedApps, *parsed.Appname)
+			}
+			return nil
+		})
+	})
+
+	AfterEach(func() {
+		Expect(l.Shutdown(context.Background())).To(Succeed())
+	})
+
+	Context("When messages are received over tcp", func() {
+		var conn net.Conn
+
+		BeforeEach(func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			l.Serve(ln)
+			conn, err = net.Dial("tcp", ln.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			utils.CloseAndLogError(conn)
+		})
+
+		It("resolves binding from structured data element", func() {
+			msg := `<14>1 2006-01-02T15:04:05Z host app - - [binding@1368 id="my-binding" rev="2"] my log`
+			_, err := fmt.Fprintf(conn, "%d %s", len(msg), msg)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(Equal([]forwarded{{"my-binding", 2, msg}}))
+		})
+
+		It("gives parsed form of valid messages", func() {
+			msg := `<14>1 2006-01-02T15:04:05Z host my-app - - [binding@1368 id="my-binding"] my log`
+			_, err := fmt.Fprintf(conn, "%s\n%s\n", msg, `<14>1 2006-01-02T15:04:05Z host my-app - - [binding@1368 id="my-binding"]my log`)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(HaveLen(2))
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(parsedApps).To(Equal([]string{"my-app"}))
+		})
+
+		It("accepts non-transparent framing", func() {
+			msg := `<14>1 2006-01-02T15:04:05Z host app - - [binding@1368 id="my-binding"] my log`
+			_, err := fmt.Fprintf(conn, "%s\n%s\n", msg, msg)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(HaveLen(2))
+			Expect(getReceived()[1]).To(Equal(forwarded{"my-binding", 0, msg}))
+		})
+
+		It("drops messages without binding", func() {
+			_, err := fmt.Fprint(conn, "<14>1 2006-01-02T15:04:05Z host app - - - no binding\n")
+			Expect(err).ToNot(HaveOccurred())
+			msg := `<14>1 2006-01-02T15:04:05Z host app - - [binding@1368 id="my-binding"] my log`
+			_, err = fmt.Fprintf(conn, "%s\n", msg)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(Equal([]forwarded{{"my-binding", 0, msg}}))
+		})
+
+		It("does not resolve binding from content of message", func() {
+			_, err := fmt.Fprint(conn, "<14>1 2006-01-02T15:04:05Z host app - - - [binding@1368 id=\"other-binding\"] injected\n")
+			Expect(err).ToNot(HaveOccurred())
+			msg := `<14>1 2006-01-02T15:04:05Z host app - - [binding@1368 id="my-binding"] my log`
+			_, err = fmt.Fprintf(conn, "%s\n", msg)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(Equal([]forwarded{{"my-binding", 0, msg}}))
+		})
+	})
+
+	Context("When connection does not come from allowed networks", func() {
+		It("refuses connection", func() {
+			forward := func(bindingID string, rev int, message []byte, parsed *rfc5424.SyslogMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, forwarded{bindingID, rev, string(message)})
+				return nil
+			}
+			restricted := listener.NewListener(&model.ListenerConfig{AllowedNetworks: []string{"10.0.0.0/8"}}, forward)
+			defer func() {
+				Expect(restricted.Shutdown(context.Background())).To(Succeed())
+			}()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			restricted.Serve(ln)
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(conn)
+
+			_, _ = fmt.Fprint(conn, "<14>1 2006-01-02T15:04:05Z host app - - [binding@1368 id=\"my-binding\"] my log\n")
+			Consistently(getReceived, "200ms").Should(BeEmpty())
+		})
+	})
+
+	Context("When messages are received over tls", func() {
+		It("resolves binding from sni host name", func() {
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				Certificates: []tls.Certificate{selfSignedCert()},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			l.Serve(ln)
+
+			conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+				ServerName:         "125ce4a5-7845-14ae-r4.logservice.private.domain",
+				InsecureSkipVerify: true, // nolint:gosec
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(conn)
+
+			msg := `<14>1 2006-01-02T15:04:05Z host app - - - my log`
+			_, err = fmt.Fprintf(conn, "%d %s", len(msg), msg)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(getReceived).Should(Equal([]forwarded{{"125ce4a5-7845-14ae", 4, msg}}))
+		})
+	})
+})
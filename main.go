@@ -19,6 +19,7 @@ import (
 
 	"github.com/orange-cloudfoundry/logs-service-broker/api"
 	"github.com/orange-cloudfoundry/logs-service-broker/dbservices"
+	"github.com/orange-cloudfoundry/logs-service-broker/listener"
 	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
 
 	"code.cloudfoundry.org/lager"
@@ -99,12 +100,54 @@ func (a *app) run() {
 	a.registerMetrics(router)
 	a.registerProfiler(router)
 	// the catchall part
-	a.registerForwarder(router, writers, cacher)
+	forwarder := a.registerForwarder(router, writers, cacher)
+	syslogListener := a.startListener(forwarder)
 
 	a.listen(router)
+	a.stopListener(syslogListener)
+	a.drainForwarder(forwarder)
 	a.finish(db, writers)
 }
 
+// startListener - serve native syslog ingress when configured, nil is returned otherwise
+func (a *app) startListener(f *api.Forwarder) *listener.Listener {
+	if !a.config.Listener.Enabled() {
+		return nil
+	}
+	l := listener.NewListener(&a.config.Listener, f.Enqueue)
+	if err := l.Start(); err != nil {
+		log.Fatalf("unable to start syslog listener: %s", err.Error())
+	}
+	return l
+}
+
+// stopListener - stop receiving syslog messages before forwarder get drained
+func (a *app) stopListener(l *listener.Listener) {
+	if l == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.Shutdown(ctx); err != nil {
+		log.Errorf("syslog listener shutdown incomplete: %s", err)
+		return
+	}
+	log.Infof("syslog listener shutdown complete")
+}
+
+// drainForwarder - wait for queued logs to be forwarded before writers get closed
+func (a *app) drainForwarder(f *api.Forwarder) {
+	timeout := *a.config.Forwarder.GetShutdownTimeout()
+	log.Infof("waiting at most %s for queued logs to be forwarded", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := f.Shutdown(ctx); err != nil {
+		log.Errorf("forwarder shutdown incomplete: %s", err)
+		return
+	}
+	log.Infof("forwarder shutdown complete")
+}
+
 func (a *app) finish(db *gorm.DB, writers writerMap) {
 	if db != nil {
 		err := db.Close()
@@ -367,7 +410,7 @@ func (a *app) initializeMetaCache(db *gorm.DB) (*dbservices.MetaCacher, error) {
 func (a *app) initializeWriters() (writerMap, error) {
 	writers := make(writerMap)
 	for _, sysAddr := range a.config.SyslogAddresses {
-		writer, err := syslog.NewWriter(sysAddr.URLs...)
+		writer, err := syslog.NewStrategyWriter(&sysAddr)
 		if err != nil {
 			return nil, err
 		}
@@ -415,12 +458,13 @@ func (a *app) registerDoc(router *mux.Router, db *gorm.DB) {
 // registerForwarder
 // 1. wrap forward handler with auto-close cnx decorator
 // 2. handle request like '{bindingID}.{drainHost}'
-func (a *app) registerForwarder(router *mux.Router, writers writerMap, cacher *dbservices.MetaCacher) {
+func (a *app) registerForwarder(router *mux.Router, writers writerMap, cacher *dbservices.MetaCacher) *api.Forwarder {
 	f := api.NewForwarder(cacher, writers, a.config)
 
 	decorated := a.maxKeepAliveDecorator(f)
 
 	router.Handle("/{bindingId}", decorated)
+	return f
 }
 
 func (a *app) registerMetrics(router *mux.Router) {
@@ -34,6 +34,136 @@ var (
 		},
 		[]string{"instance_id", "binding_id", "plan_name", "org", "space", "app"},
 	)
+	ForwardQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "logs_forward_queue_depth",
+			Help: "Number of logs waiting in queue to be forwarded.",
+		},
+	)
+	ForwardQueueCapacity = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "logs_forward_queue_capacity",
+			Help: "Maximum number of logs which can wait in queue to be forwarded, each worker gets an equal part of it for logs of app instances it forwards.",
+		},
+	)
+	ForwardQueueDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_forward_queue_dropped_total",
+			Help: "Number of logs dropped because forward queue was full.",
+		},
+		[]string{"policy"},
+	)
+	ForwardShutdownLost = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logs_forward_shutdown_lost_total",
+			Help: "Number of logs in forward queue not forwarded before shutdown deadline.",
+		},
+	)
+	LogsInvalidFrames = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_invalid_frames_total",
+			Help: "Number of received batches with invalid octet counting framing.",
+		},
+		[]string{"binding_id"},
+	)
+	LogsRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_rate_limited_total",
+			Help: "Number of logs dropped because instance or binding rate limit is reached.",
+		},
+		[]string{"instance_id", "binding_id", "plan_name", "scope"},
+	)
+	LogsDroppedByRules = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_rules_dropped_total",
+			Help: "Number of logs dropped by filtering rules of instance.",
+		},
+		[]string{"instance_id", "binding_id", "plan_name"},
+	)
+	ListenerConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "logs_listener_connections",
+			Help: "Number of open connections on native syslog listener.",
+		},
+		[]string{"transport"},
+	)
+	ListenerUnresolved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_listener_unresolved_total",
+			Help: "Number of logs dropped by native syslog listener because binding id could not be found.",
+		},
+		[]string{"transport"},
+	)
+	EndpointUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "logs_endpoint_up",
+			Help: "Health of syslog endpoint according to last write, 0 is unhealthy.",
+		},
+		[]string{"plan_name", "endpoint"},
+	)
+	EndpointErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_endpoint_errors_total",
+			Help: "Number of failed writes on syslog endpoint.",
+		},
+		[]string{"plan_name", "endpoint"},
+	)
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "logs_circuit_breaker_state",
+			Help: "State of circuit breaker on syslog endpoint connection, 0 is closed, 1 is half-open and 2 is open.",
+		},
+		[]string{"endpoint"},
+	)
+	HttpOutputRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_http_output_retries_total",
+			Help: "Number of requests retried by http writer after a network error, 429 or 5xx response.",
+		},
+		[]string{"endpoint"},
+	)
+	AsyncDeliveryFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_async_delivery_failures_total",
+			Help: "Number of logs accepted by an asynchronous writer which could not be delivered.",
+		},
+		[]string{"endpoint"},
+	)
+	ElasticsearchRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_elasticsearch_rejected_total",
+			Help: "Number of logs rejected by elasticsearch in bulk response with a non retryable error.",
+		},
+		[]string{"endpoint"},
+	)
+	SpoolSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "logs_spool_size_bytes",
+			Help: "Size on disk of logs waiting in spool to be replayed.",
+		},
+		[]string{"plan_name"},
+	)
+	LogsSpooled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_spooled_total",
+			Help: "Number of logs written in spool after a failure on syslog endpoint(s).",
+		},
+		[]string{"plan_name"},
+	)
+	LogsSpoolReplayed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_spool_replayed_total",
+			Help: "Number of logs from spool successfully replayed to syslog endpoint(s).",
+		},
+		[]string{"plan_name"},
+	)
+	LogsSpoolDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logs_spool_dropped_total",
+			Help: "Number of logs removed from spool without being replayed.",
+		},
+		[]string{"plan_name", "reason"},
+	)
 	DbStatsCnxMaxOpen = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "logs_db_cnx_max_open",
@@ -71,6 +201,25 @@ func init() {
 	prometheus.MustRegister(LogsSent)
 	prometheus.MustRegister(LogsSentDuration)
 	prometheus.MustRegister(LogsSentWithoutCache)
+	prometheus.MustRegister(ForwardQueueDepth)
+	prometheus.MustRegister(ForwardQueueCapacity)
+	prometheus.MustRegister(ForwardQueueDropped)
+	prometheus.MustRegister(ForwardShutdownLost)
+	prometheus.MustRegister(LogsInvalidFrames)
+	prometheus.MustRegister(LogsRateLimited)
+	prometheus.MustRegister(LogsDroppedByRules)
+	prometheus.MustRegister(ListenerConnections)
+	prometheus.MustRegister(EndpointUp)
+	prometheus.MustRegister(EndpointErrors)
+	prometheus.MustRegister(CircuitBreakerState)
+	prometheus.MustRegister(HttpOutputRetries)
+	prometheus.MustRegister(AsyncDeliveryFailures)
+	prometheus.MustRegister(ElasticsearchRejected)
+	prometheus.MustRegister(ListenerUnresolved)
+	prometheus.MustRegister(SpoolSize)
+	prometheus.MustRegister(LogsSpooled)
+	prometheus.MustRegister(LogsSpoolReplayed)
+	prometheus.MustRegister(LogsSpoolDropped)
 	prometheus.MustRegister(DbStatsCnxMaxOpen)
 	prometheus.MustRegister(DbStatsCnxUsed)
 	prometheus.MustRegister(DbStatsCnxIdle)
@@ -3,6 +3,8 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,8 +26,49 @@ const (
 	DrainTypeKey            = "drain-type"
 )
 
+const (
+	OverflowDropNewest = "drop-newest"
+	OverflowDropOldest = "drop-oldest"
+	OverflowBlock      = "block"
+	OverflowReject     = "reject"
+)
+
+const (
+	StrategyFanout      = "fanout"
+	StrategyFailover    = "failover"
+	StrategyLoadBalance = "loadbalance"
+	BalanceRoundRobin   = "round-robin"
+	BalanceHash         = "hash"
+)
+
+const (
+	DrainSchemeHTTP   = "http"
+	DrainSchemeSyslog = "syslog"
+)
+
 type ContextKey string
 
+// BindingHostLabel -
+// first host label of syslog drain urls, revision is embedded because
+// syslog protocol, unlike http, has no way to carry it in query
+// out: {bindingID}-r{rev}
+func BindingHostLabel(bindingID string, rev int) string {
+	return fmt.Sprintf("%s-r%d", bindingID, rev)
+}
+
+// ParseBindingHostLabel - reverse of BindingHostLabel, revision is 0 when not found
+func ParseBindingHostLabel(label string) (string, int) {
+	i := strings.LastIndex(label, "-r")
+	if i < 0 {
+		return label, 0
+	}
+	rev, err := strconv.Atoi(label[i+2:])
+	if err != nil {
+		return label, 0
+	}
+	return label[:i], rev
+}
+
 type LogConfig struct {
 	Level          string `cloud:"level"`
 	JSON           *bool  `cloud:"json"`
@@ -90,6 +133,75 @@ type ForwarderConfig struct {
 	AllowedHosts             []string     `cloud:"allowed_hosts"`
 	ParsingKeys              []ParsingKey `cloud:"parsing_keys"`
 	IgnoreTagsStructuredData bool         `cloud:"ignore_tags_structured_data"`
+	Queue                    QueueConfig  `cloud:"queue"`
+	ShutdownTimeout          string       `cloud:"shutdown_timeout" cloud-default:"30s"`
+	shutdownTimeout          *time.Duration
+}
+
+// GetShutdownTimeout - Maximum time to wait for queued logs to be forwarded on shutdown, fallback to 30s
+func (f *ForwarderConfig) GetShutdownTimeout() *time.Duration {
+	if f.shutdownTimeout == nil {
+		dur, err := time.ParseDuration(f.ShutdownTimeout)
+		if err != nil {
+			dur, _ = time.ParseDuration("30s")
+		}
+		f.shutdownTimeout = &dur
+	}
+	return f.shutdownTimeout
+}
+
+type QueueConfig struct {
+	Size             int    `cloud:"size" cloud-default:"10000"`
+	Workers          int    `cloud:"workers" cloud-default:"100"`
+	OverflowPolicy   string `cloud:"overflow_policy" cloud-default:"drop-newest"`
+	BlockTimeout     string `cloud:"block_timeout" cloud-default:"1s"`
+	RejectStatusCode int    `cloud:"reject_status_code" cloud-default:"429"`
+	blockTimeout     *time.Duration
+}
+
+// GetSize - Size of the queue, fallback to 10000 when not set
+func (q *QueueConfig) GetSize() int {
+	if q.Size <= 0 {
+		return 10000
+	}
+	return q.Size
+}
+
+// GetWorkers - Number of workers consuming the queue, fallback to 100 when not set
+func (q *QueueConfig) GetWorkers() int {
+	if q.Workers <= 0 {
+		return 100
+	}
+	return q.Workers
+}
+
+// GetOverflowPolicy - Policy applied when queue is full, fallback to drop-newest when not set or unknown
+func (q *QueueConfig) GetOverflowPolicy() string {
+	policy := strings.ToLower(strings.TrimSpace(q.OverflowPolicy))
+	switch policy {
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock, OverflowReject:
+		return policy
+	}
+	return OverflowDropNewest
+}
+
+func (q *QueueConfig) GetBlockTimeout() *time.Duration {
+	if q.blockTimeout == nil {
+		dur, err := time.ParseDuration(q.BlockTimeout)
+		if err != nil {
+			dur, _ = time.ParseDuration("1s")
+		}
+		q.blockTimeout = &dur
+	}
+	return q.blockTimeout
+}
+
+// GetRejectStatusCode - Status code answered to drain when policy is reject, only 429 and 503 are allowed
+func (q *QueueConfig) GetRejectStatusCode() int {
+	if q.RejectStatusCode == http.StatusServiceUnavailable {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusTooManyRequests
 }
 
 func (f *KeepAliveConfig) GetDuration() *time.Duration {
@@ -122,12 +234,58 @@ type Config struct {
 	DB              DBConfig           `cloud:"db"`
 	Forwarder       ForwarderConfig    `cloud:"forwarder"`
 	BindingCache    BindingCacheConfig `cloud:"binding_cache"`
+	Listener        ListenerConfig     `cloud:"listener"`
 }
 
 func (c Config) HasTLS() bool {
 	return c.Web.TLS.CertFile != "" && c.Web.TLS.KeyFile != "" && c.Web.TLS.Port > 0
 }
 
+// ListenerConfig - native syslog ingress over tcp and tls
+type ListenerConfig struct {
+	Port            int          `cloud:"port"`
+	TLS             WebTLSConfig `cloud:"tls"`
+	BindingSDID     string       `cloud:"binding_sd_id" cloud-default:"binding@1368"`
+	MaxMessageSize  int          `cloud:"max_message_size" cloud-default:"65536"`
+	IdleTimeout     string       `cloud:"idle_timeout" cloud-default:"5m"`
+	AllowedNetworks []string     `cloud:"allowed_networks"`
+	idleTimeout     *time.Duration
+}
+
+func (c ListenerConfig) HasTLS() bool {
+	return c.TLS.CertFile != "" && c.TLS.KeyFile != "" && c.TLS.Port > 0
+}
+
+// Enabled - listener is enabled when at least one of tcp or tls port is set
+func (c ListenerConfig) Enabled() bool {
+	return c.Port > 0 || c.HasTLS()
+}
+
+func (c *ListenerConfig) GetBindingSDID() string {
+	if c.BindingSDID == "" {
+		return "binding@1368"
+	}
+	return c.BindingSDID
+}
+
+func (c *ListenerConfig) GetMaxMessageSize() int {
+	if c.MaxMessageSize <= 0 {
+		return 65536
+	}
+	return c.MaxMessageSize
+}
+
+func (c *ListenerConfig) GetIdleTimeout() *time.Duration {
+	if c.idleTimeout == nil {
+		dur, err := time.ParseDuration(c.IdleTimeout)
+		if err != nil || dur <= 0 {
+			dur, _ = time.ParseDuration("5m")
+		}
+		c.idleTimeout = &dur
+	}
+	return c.idleTimeout
+}
+
 type ParsingKey struct {
 	Name string `cloud:"name"`
 	Hide bool   `cloud:"hide"`
@@ -153,16 +311,428 @@ func (a SyslogAddresses) FoundSyslogWriter(planIDOrName string) (SyslogAddress,
 }
 
 type SyslogAddress struct {
-	ID               string            `cloud:"id"`
-	Name             string            `cloud:"name"`
-	CompanyID        string            `cloud:"company_id"`
-	Description      string            `cloud:"description"`
-	DefaultDrainType DrainType         `cloud:"default_drain_type"`
-	URLs             []string          `cloud:"urls"`
-	Bullets          []string          `cloud:"bullets"`
-	Patterns         []string          `cloud:"patterns"`
-	Tags             map[string]string `cloud:"tags"`
-	SourceLabels     map[string]string `cloud:"source_labels"`
+	ID               string              `cloud:"id"`
+	Name             string              `cloud:"name"`
+	CompanyID        string              `cloud:"company_id"`
+	Description      string              `cloud:"description"`
+	DefaultDrainType DrainType           `cloud:"default_drain_type"`
+	URLs             []string            `cloud:"urls"`
+	Bullets          []string            `cloud:"bullets"`
+	Patterns         []string            `cloud:"patterns"`
+	Tags             map[string]string   `cloud:"tags"`
+	SourceLabels     map[string]string   `cloud:"source_labels"`
+	Spool            SpoolConfig         `cloud:"spool"`
+	DrainScheme      string              `cloud:"drain_scheme" cloud-default:"http"`
+	OutputFormat     string              `cloud:"output_format" cloud-default:"rfc5424-json"`
+	Schema           string              `cloud:"schema" cloud-default:"native"`
+	RateLimit        RateLimits          `cloud:"rate_limit"`
+	Redaction        Redaction           `cloud:"redaction"`
+	Mutate           []Mutation          `cloud:"mutate"`
+	Timestamp        TimestampExtraction `cloud:"timestamp"`
+	Strategy         StrategyConfig      `cloud:"strategy"`
+	HTTP             HTTPOutputConfig    `cloud:"http"`
+	Loki             LokiConfig          `cloud:"loki"`
+	Elasticsearch    ElasticConfig       `cloud:"elasticsearch"`
+	OTLP             OTLPConfig          `cloud:"otlp"`
+	Splunk           SplunkConfig        `cloud:"splunk"`
+	GELF             GELFConfig          `cloud:"gelf"`
+	Kafka            KafkaConfig         `cloud:"kafka"`
+	File             FileConfig          `cloud:"file"`
+}
+
+// HTTPOutputConfig - options for http(s) urls, each of them can be overridden by url params
+type HTTPOutputConfig struct {
+	Headers        map[string]string `cloud:"headers"`
+	Username       string            `cloud:"username"`
+	Password       string            `cloud:"password"`
+	BearerToken    string            `cloud:"bearer_token"`
+	ClientCertFile string            `cloud:"client_cert_file"`
+	ClientKeyFile  string            `cloud:"client_key_file"`
+	Format         string            `cloud:"format" cloud-default:"lines"`
+	ContentType    string            `cloud:"content_type"`
+	BatchSize      int               `cloud:"batch_size" cloud-default:"1"`
+	BatchBytes     int               `cloud:"batch_bytes" cloud-default:"1048576"`
+	Linger         string            `cloud:"linger" cloud-default:"200ms"`
+	Timeout        string            `cloud:"timeout" cloud-default:"10s"`
+	MaxRetries     int               `cloud:"max_retries" cloud-default:"3"`
+	Async          bool              `cloud:"async"`
+	BufferSize     int               `cloud:"buffer_size" cloud-default:"10000"`
+}
+
+// GetLinger - max time a message waits for its batch to be filled, fallback to 200ms
+func (c HTTPOutputConfig) GetLinger() time.Duration {
+	dur, err := time.ParseDuration(c.Linger)
+	if err != nil || dur < 0 {
+		return 200 * time.Millisecond
+	}
+	return dur
+}
+
+// GetTimeout - timeout of a single request, fallback to 10s
+func (c HTTPOutputConfig) GetTimeout() time.Duration {
+	dur, err := time.ParseDuration(c.Timeout)
+	if err != nil || dur <= 0 {
+		return 10 * time.Second
+	}
+	return dur
+}
+
+// LokiConfig - options for loki urls, http options also apply on them
+type LokiConfig struct {
+	Format string            `cloud:"format" cloud-default:"protobuf"`
+	Labels map[string]string `cloud:"labels"`
+}
+
+// ElasticConfig - options for elasticsearch urls, http options also apply on them
+type ElasticConfig struct {
+	Index  string `cloud:"index" cloud-default:"logs-YYYY.MM.DD"`
+	APIKey string `cloud:"api_key"`
+	OpType string `cloud:"op_type" cloud-default:"index"`
+}
+
+// OTLPConfig - options for otlp urls, http options also apply on them
+type OTLPConfig struct {
+	Format             string            `cloud:"format" cloud-default:"protobuf"`
+	ResourceAttributes map[string]string `cloud:"resource_attributes"`
+}
+
+// SplunkConfig - options for splunk urls, http options also apply on them
+type SplunkConfig struct {
+	Token       string `cloud:"token"`
+	Index       string `cloud:"index"`
+	SourceType  string `cloud:"sourcetype" cloud-default:"cloudfoundry"`
+	Source      string `cloud:"source"`
+	Host        string `cloud:"host"`
+	Ack         bool   `cloud:"ack"`
+	Channel     string `cloud:"channel"`
+	AckTimeout  string `cloud:"ack_timeout" cloud-default:"30s"`
+	AckInterval string `cloud:"ack_interval" cloud-default:"1s"`
+}
+
+// GetAckTimeout - max time to wait for events to be acknowledged before sending them again, fallback to 30s
+func (c SplunkConfig) GetAckTimeout() time.Duration {
+	dur, err := time.ParseDuration(c.AckTimeout)
+	if err != nil || dur <= 0 {
+		return 30 * time.Second
+	}
+	return dur
+}
+
+// GetAckInterval - time between two acknowledgement queries, fallback to 1s
+func (c SplunkConfig) GetAckInterval() time.Duration {
+	dur, err := time.ParseDuration(c.AckInterval)
+	if err != nil || dur <= 0 {
+		return time.Second
+	}
+	return dur
+}
+
+// GELFConfig - options for gelf urls, http options also apply on gelf+http urls
+type GELFConfig struct {
+	Compression string `cloud:"compression" cloud-default:"gzip"`
+	ChunkSize   int    `cloud:"chunk_size" cloud-default:"1420"`
+}
+
+// KafkaConfig - options for kafka urls, each of them can be overridden by url params
+type KafkaConfig struct {
+	PartitionKey  string `cloud:"partition_key" cloud-default:"binding"`
+	Compression   string `cloud:"compression" cloud-default:"none"`
+	Acks          string `cloud:"acks" cloud-default:"all"`
+	ClientID      string `cloud:"client_id" cloud-default:"logs-service-broker"`
+	SASLMechanism string `cloud:"sasl_mechanism"`
+	Username      string `cloud:"username"`
+	Password      string `cloud:"password"`
+	TLS           bool   `cloud:"tls"`
+	BatchBytes    int    `cloud:"batch_bytes" cloud-default:"1048576"`
+	Linger        string `cloud:"linger" cloud-default:"50ms"`
+	Timeout       string `cloud:"timeout" cloud-default:"10s"`
+	MaxRetries    int    `cloud:"max_retries" cloud-default:"3"`
+	Async         bool   `cloud:"async"`
+	BufferSize    int    `cloud:"buffer_size" cloud-default:"10000"`
+}
+
+// GetLinger - max time a message waits for its batch to be filled, fallback to 50ms
+func (c KafkaConfig) GetLinger() time.Duration {
+	dur, err := time.ParseDuration(c.Linger)
+	if err != nil || dur < 0 {
+		return 50 * time.Millisecond
+	}
+	return dur
+}
+
+// GetTimeout - timeout of a single request to a broker, fallback to 10s
+func (c KafkaConfig) GetTimeout() time.Duration {
+	dur, err := time.ParseDuration(c.Timeout)
+	if err != nil || dur <= 0 {
+		return 10 * time.Second
+	}
+	return dur
+}
+
+// FileConfig - rotation and retention of files written by file urls, each of them can be overridden by url params
+type FileConfig struct {
+	MaxSizeMB    int    `cloud:"max_size_mb" cloud-default:"100"`
+	RotateEvery  string `cloud:"rotate_every"`
+	Compress     bool   `cloud:"compress"`
+	MaxBackups   int    `cloud:"max_backups"`
+	MaxAge       string `cloud:"max_age"`
+	MaxOpenFiles int    `cloud:"max_open_files" cloud-default:"256"`
+}
+
+// GetRotateEvery - period of time based rotation, 0 when disabled
+func (c FileConfig) GetRotateEvery() time.Duration {
+	dur, err := time.ParseDuration(c.RotateEvery)
+	if err != nil || dur < 0 {
+		return 0
+	}
+	return dur
+}
+
+// GetMaxAge - max age of rotated files before being removed, 0 when they are kept forever
+func (c FileConfig) GetMaxAge() time.Duration {
+	dur, err := time.ParseDuration(c.MaxAge)
+	if err != nil || dur < 0 {
+		return 0
+	}
+	return dur
+}
+
+// StrategyConfig - how messages are delivered when multiple urls are given
+type StrategyConfig struct {
+	Mode          string `cloud:"mode" cloud-default:"fanout"`
+	Balance       string `cloud:"balance" cloud-default:"round-robin"`
+	RetryInterval string `cloud:"retry_interval" cloud-default:"30s"`
+	retryInterval *time.Duration
+}
+
+func (c *StrategyConfig) GetMode() string {
+	switch strings.ToLower(c.Mode) {
+	case StrategyFailover:
+		return StrategyFailover
+	case StrategyLoadBalance:
+		return StrategyLoadBalance
+	}
+	return StrategyFanout
+}
+
+func (c *StrategyConfig) GetBalance() string {
+	if strings.ToLower(c.Balance) == BalanceHash {
+		return BalanceHash
+	}
+	return BalanceRoundRobin
+}
+
+// GetRetryInterval - time before an unhealthy endpoint is given a new try, fallback to 30s
+func (c *StrategyConfig) GetRetryInterval() *time.Duration {
+	if c.retryInterval == nil {
+		dur, err := time.ParseDuration(c.RetryInterval)
+		if err != nil || dur < 0 {
+			dur, _ = time.ParseDuration("30s")
+		}
+		c.retryInterval = &dur
+	}
+	return c.retryInterval
+}
+
+// EffectiveRateLimits - plan limits lowered by the ones requested by user on instance
+func (a SyslogAddress) EffectiveRateLimits(param *InstanceParam) RateLimits {
+	return a.RateLimit.Lower(param.RateLimits())
+}
+
+// RateLimit - maximum throughput allowed, 0 means unlimited
+type RateLimit struct {
+	MessagesPerSecond int `cloud:"messages_per_second" json:"messages_per_second"`
+	BytesPerSecond    int `cloud:"bytes_per_second" json:"bytes_per_second"`
+}
+
+func (r RateLimit) IsZero() bool {
+	return r.MessagesPerSecond <= 0 && r.BytesPerSecond <= 0
+}
+
+func (r RateLimit) isNegative() bool {
+	return r.MessagesPerSecond < 0 || r.BytesPerSecond < 0
+}
+
+// Lower - most restrictive limits between both
+func (r RateLimit) Lower(o RateLimit) RateLimit {
+	return RateLimit{
+		MessagesPerSecond: lowerLimit(r.MessagesPerSecond, o.MessagesPerSecond),
+		BytesPerSecond:    lowerLimit(r.BytesPerSecond, o.BytesPerSecond),
+	}
+}
+
+// RateLimits - limits applied on all logs of an instance and on logs of each of its bindings
+type RateLimits struct {
+	Instance RateLimit `cloud:"instance" json:"instance"`
+	Binding  RateLimit `cloud:"binding" json:"binding"`
+}
+
+func (r RateLimits) IsZero() bool {
+	return r.Instance.IsZero() && r.Binding.IsZero()
+}
+
+// Validate - refuse negative limits, 0 is the only way to ask for no limit
+func (r RateLimits) Validate() error {
+	if r.Instance.isNegative() {
+		return fmt.Errorf("rate limit of instance must not be negative")
+	}
+	if r.Binding.isNegative() {
+		return fmt.Errorf("rate limit of binding must not be negative")
+	}
+	return nil
+}
+
+// Lower - most restrictive limits between both, this ensure users can't raise limits set by operator
+func (r RateLimits) Lower(o RateLimits) RateLimits {
+	return RateLimits{
+		Instance: r.Instance.Lower(o.Instance),
+		Binding:  r.Binding.Lower(o.Binding),
+	}
+}
+
+func lowerLimit(a, b int) int {
+	if a <= 0 {
+		return max(b, 0)
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// Redaction -
+// masking of personal or secret data found by detectors and patterns in `@message`, query string of `rtr.path`
+// and in values of given keys. Action is `mask`, `hash` (hmac with hash key of plan) or `drop` of the field.
+type Redaction struct {
+	Detectors []string `cloud:"detectors" json:"detectors,omitempty"`
+	Patterns  []string `cloud:"patterns" json:"patterns,omitempty"`
+	Keys      []string `cloud:"keys" json:"keys,omitempty"`
+	Action    string   `cloud:"action" json:"action,omitempty"`
+	HashKey   string   `cloud:"hash_key" json:"-"`
+}
+
+// IsZero - no detector or pattern is set, nothing is redacted
+func (r Redaction) IsZero() bool {
+	return len(r.Detectors) == 0 && len(r.Patterns) == 0
+}
+
+// GetAction - action applied on data found, fallback to mask when not set
+func (r Redaction) GetAction() string {
+	if r.Action == "" {
+		return "mask"
+	}
+	return strings.ToLower(r.Action)
+}
+
+// Mutation -
+// transformation of fields of parsed logs given by their dotted keys, operations set are done in this order:
+// rename, copy, convert (to `int`, `float`, `bool` or `timestamp`), add_field (templated values), lowercase,
+// split (by separator), flatten (nested fields moved to parent as dotted keys) and remove
+type Mutation struct {
+	Rename    map[string]string `cloud:"rename" json:"rename,omitempty"`
+	Copy      map[string]string `cloud:"copy" json:"copy,omitempty"`
+	Convert   map[string]string `cloud:"convert" json:"convert,omitempty"`
+	AddField  map[string]string `cloud:"add_field" json:"add_field,omitempty"`
+	Lowercase []string          `cloud:"lowercase" json:"lowercase,omitempty"`
+	Split     map[string]string `cloud:"split" json:"split,omitempty"`
+	Flatten   []string          `cloud:"flatten" json:"flatten,omitempty"`
+	Remove    []string          `cloud:"remove" json:"remove,omitempty"`
+}
+
+// IsZero - mutation has no operation
+func (m Mutation) IsZero() bool {
+	return len(m.Rename) == 0 && len(m.Copy) == 0 && len(m.Convert) == 0 && len(m.AddField) == 0 &&
+		len(m.Lowercase) == 0 && len(m.Split) == 0 && len(m.Flatten) == 0 && len(m.Remove) == 0
+}
+
+// TimestampExtraction -
+// `@timestamp` taken from first parsed field found in keys, time given by syslog header is kept in `@received_at`.
+// Format is `auto`, `rfc3339`, `epoch_s`, `epoch_ms`, `epoch_ns` or a layout prefixed by `go:`, `java:` or `strftime:`.
+type TimestampExtraction struct {
+	Keys   []string `cloud:"keys" json:"keys,omitempty"`
+	Format string   `cloud:"format" json:"format,omitempty"`
+}
+
+// IsZero - extraction is disabled
+func (t TimestampExtraction) IsZero() bool {
+	return len(t.Keys) == 0 && t.Format == ""
+}
+
+// GetFormat - format of timestamps, fallback to auto when not set
+func (t TimestampExtraction) GetFormat() string {
+	if t.Format == "" {
+		return "auto"
+	}
+	return t.Format
+}
+
+// GetSchema - schema of fields in logs sent to plan, fallback to native
+func (a SyslogAddress) GetSchema() string {
+	if a.Schema == "" {
+		return "native"
+	}
+	return strings.ToLower(a.Schema)
+}
+
+// GetDrainScheme - scheme family of drain urls given to users, fallback to http
+func (a SyslogAddress) GetDrainScheme() string {
+	if strings.ToLower(a.DrainScheme) == DrainSchemeSyslog {
+		return DrainSchemeSyslog
+	}
+	return DrainSchemeHTTP
+}
+
+type SpoolConfig struct {
+	Dir            string `cloud:"dir"`
+	MaxSizeMB      int    `cloud:"max_size_mb" cloud-default:"1024"`
+	SegmentSizeMB  int    `cloud:"segment_size_mb" cloud-default:"16"`
+	MaxAge         string `cloud:"max_age" cloud-default:"24h"`
+	ReplayInterval string `cloud:"replay_interval" cloud-default:"5s"`
+	maxAge         *time.Duration
+	replayInterval *time.Duration
+}
+
+// Enabled - Spool is only enabled when a directory is given
+func (s *SpoolConfig) Enabled() bool {
+	return s.Dir != ""
+}
+
+// GetMaxSize - Maximum size in bytes of spool on disk, fallback to 1024MB when not set
+func (s *SpoolConfig) GetMaxSize() int64 {
+	if s.MaxSizeMB <= 0 {
+		return 1024 * 1024 * 1024
+	}
+	return int64(s.MaxSizeMB) * 1024 * 1024
+}
+
+// GetSegmentSize - Size in bytes before rolling to a new segment file, fallback to 16MB when not set
+func (s *SpoolConfig) GetSegmentSize() int64 {
+	if s.SegmentSizeMB <= 0 {
+		return 16 * 1024 * 1024
+	}
+	return int64(s.SegmentSizeMB) * 1024 * 1024
+}
+
+func (s *SpoolConfig) GetMaxAge() *time.Duration {
+	if s.maxAge == nil {
+		dur, err := time.ParseDuration(s.MaxAge)
+		if err != nil {
+			dur, _ = time.ParseDuration("24h")
+		}
+		s.maxAge = &dur
+	}
+	return s.maxAge
+}
+
+func (s *SpoolConfig) GetReplayInterval() *time.Duration {
+	if s.replayInterval == nil {
+		dur, err := time.ParseDuration(s.ReplayInterval)
+		if err != nil || dur <= 0 {
+			dur, _ = time.ParseDuration("5s")
+		}
+		s.replayInterval = &dur
+	}
+	return s.replayInterval
 }
 
 func (a SyslogAddress) ToServicePlan() domain.ServicePlan {
@@ -178,18 +748,171 @@ func (a SyslogAddress) ToServicePlan() domain.ServicePlan {
 }
 
 type InstanceParam struct {
-	InstanceID   string `gorm:"primary_key"`
-	Revision     int    `gorm:"primary_key;auto_increment:false"`
-	SpaceID      string
-	OrgID        string
-	Namespace    string
-	SyslogName   string
-	CompanyID    string
-	UseTls       bool
-	DrainType    DrainType
-	Patterns     []Pattern     `gorm:"foreignkey:InstanceID"`
-	Tags         []Label       `gorm:"foreignkey:InstanceID"`
-	SourceLabels []SourceLabel `gorm:"foreignkey:InstanceID"`
+	InstanceID string `gorm:"primary_key"`
+	Revision   int    `gorm:"primary_key;auto_increment:false"`
+	SpaceID    string
+	OrgID      string
+	Namespace  string
+	SyslogName string
+	CompanyID  string
+	UseTls     bool
+	DrainType  DrainType
+	// rate limits requested by user, effective ones are lowered by plan limits
+	InstanceMessagesRate int
+	InstanceBytesRate    int
+	BindingMessagesRate  int
+	BindingBytesRate     int
+	// multi-line aggregation requested by user, disabled when preset and patterns are empty
+	MultilinePreset       string
+	MultilineStart        string `gorm:"size:600"`
+	MultilineContinuation string `gorm:"size:600"`
+	MultilineMaxLines     int
+	MultilineTimeout      string
+	// filtering rules requested by user as a json list, kept per revision as they are evaluated in order
+	Rules string `gorm:"type:text"`
+	// redaction requested by user as json, applied after the one of plan
+	Redaction string `gorm:"type:text"`
+	// mutations requested by user as a json list, they replace the ones of plan
+	Mutate string `gorm:"type:text"`
+	// timestamp extraction requested by user as json, it replaces the one of plan
+	TimestampExtraction string        `gorm:"type:text"`
+	Patterns            []Pattern     `gorm:"foreignkey:InstanceID"`
+	Tags                []Label       `gorm:"foreignkey:InstanceID"`
+	SourceLabels        []SourceLabel `gorm:"foreignkey:InstanceID"`
+}
+
+// RateLimits - limits requested by user
+func (d *InstanceParam) RateLimits() RateLimits {
+	return RateLimits{
+		Instance: RateLimit{
+			MessagesPerSecond: d.InstanceMessagesRate,
+			BytesPerSecond:    d.InstanceBytesRate,
+		},
+		Binding: RateLimit{
+			MessagesPerSecond: d.BindingMessagesRate,
+			BytesPerSecond:    d.BindingBytesRate,
+		},
+	}
+}
+
+// SetRateLimits - store limits requested by user
+func (d *InstanceParam) SetRateLimits(r RateLimits) {
+	d.InstanceMessagesRate = r.Instance.MessagesPerSecond
+	d.InstanceBytesRate = r.Instance.BytesPerSecond
+	d.BindingMessagesRate = r.Binding.MessagesPerSecond
+	d.BindingBytesRate = r.Binding.BytesPerSecond
+}
+
+// Multiline - multi-line aggregation requested by user
+func (d *InstanceParam) Multiline() MultilineParams {
+	return MultilineParams{
+		Preset:       d.MultilinePreset,
+		Start:        d.MultilineStart,
+		Continuation: d.MultilineContinuation,
+		MaxLines:     d.MultilineMaxLines,
+		Timeout:      d.MultilineTimeout,
+	}
+}
+
+// SetMultiline - store multi-line aggregation requested by user
+func (d *InstanceParam) SetMultiline(m MultilineParams) {
+	d.MultilinePreset = m.Preset
+	d.MultilineStart = m.Start
+	d.MultilineContinuation = m.Continuation
+	d.MultilineMaxLines = m.MaxLines
+	d.MultilineTimeout = m.Timeout
+}
+
+// FilterRules - filtering rules requested by user, an error is given when stored json can't be decoded
+func (d *InstanceParam) FilterRules() ([]FilterRule, error) {
+	rules := make([]FilterRule, 0)
+	if d.Rules == "" {
+		return rules, nil
+	}
+	err := json.Unmarshal([]byte(d.Rules), &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filtering rules stored for instance '%s': %s", d.InstanceID, err.Error())
+	}
+	return rules, nil
+}
+
+// SetFilterRules - store filtering rules requested by user
+func (d *InstanceParam) SetFilterRules(rules []FilterRule) {
+	d.Rules = ""
+	if len(rules) == 0 {
+		return
+	}
+	b, _ := json.Marshal(rules)
+	d.Rules = string(b)
+}
+
+// GetRedaction - redaction requested by user, an error is given when stored json can't be decoded
+func (d *InstanceParam) GetRedaction() (Redaction, error) {
+	var r Redaction
+	if d.Redaction == "" {
+		return r, nil
+	}
+	err := json.Unmarshal([]byte(d.Redaction), &r)
+	if err != nil {
+		return Redaction{}, fmt.Errorf("invalid redaction stored for instance '%s': %s", d.InstanceID, err.Error())
+	}
+	return r, nil
+}
+
+// SetRedaction - store redaction requested by user
+func (d *InstanceParam) SetRedaction(r Redaction) {
+	d.Redaction = ""
+	if r.IsZero() {
+		return
+	}
+	b, _ := json.Marshal(r)
+	d.Redaction = string(b)
+}
+
+// GetMutations - mutations requested by user, an error is given when stored json can't be decoded
+func (d *InstanceParam) GetMutations() ([]Mutation, error) {
+	mutations := make([]Mutation, 0)
+	if d.Mutate == "" {
+		return mutations, nil
+	}
+	err := json.Unmarshal([]byte(d.Mutate), &mutations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mutations stored for instance '%s': %s", d.InstanceID, err.Error())
+	}
+	return mutations, nil
+}
+
+// SetMutations - store mutations requested by user
+func (d *InstanceParam) SetMutations(mutations []Mutation) {
+	d.Mutate = ""
+	if len(mutations) == 0 {
+		return
+	}
+	b, _ := json.Marshal(mutations)
+	d.Mutate = string(b)
+}
+
+// GetTimestampExtraction - timestamp extraction requested by user, an error is given when stored json can't be decoded
+func (d *InstanceParam) GetTimestampExtraction() (TimestampExtraction, error) {
+	var t TimestampExtraction
+	if d.TimestampExtraction == "" {
+		return t, nil
+	}
+	err := json.Unmarshal([]byte(d.TimestampExtraction), &t)
+	if err != nil {
+		return TimestampExtraction{}, fmt.Errorf("invalid timestamp extraction stored for instance '%s': %s", d.InstanceID, err.Error())
+	}
+	return t, nil
+}
+
+// SetTimestampExtraction - store timestamp extraction requested by user
+func (d *InstanceParam) SetTimestampExtraction(t TimestampExtraction) {
+	d.TimestampExtraction = ""
+	if t.IsZero() {
+		return
+	}
+	b, _ := json.Marshal(t)
+	d.TimestampExtraction = string(b)
 }
 
 func (d *InstanceParam) TagsToMap() map[string]string {
@@ -284,10 +1007,41 @@ type CfResponse struct {
 }
 
 type ProvisionParams struct {
-	Patterns  []string          `json:"patterns"`
-	Tags      map[string]string `json:"tags"`
-	UseTLS    bool              `json:"use_tls"`
-	DrainType *DrainType        `json:"drain_type"`
+	Patterns  []string             `json:"patterns"`
+	Tags      map[string]string    `json:"tags"`
+	UseTLS    bool                 `json:"use_tls"`
+	DrainType *DrainType           `json:"drain_type"`
+	RateLimit *RateLimits          `json:"rate_limit"`
+	Multiline *MultilineParams     `json:"multiline"`
+	Rules     []FilterRule         `json:"rules"`
+	Redaction *Redaction           `json:"redaction"`
+	Mutate    []Mutation           `json:"mutate"`
+	Timestamp *TimestampExtraction `json:"timestamp"`
+}
+
+// FilterRule -
+// action applied on logs whose parsed fields match expression, e.g.: `rtr.path == "/health"`.
+// Action is `drop`, `keep` or `sample(<rate>)`, rules are evaluated in order and first matching one is applied.
+type FilterRule struct {
+	Expression string `json:"expression"`
+	Action     string `json:"action"`
+}
+
+// MultilineParams -
+// aggregation of events written on several lines, e.g.: stack traces.
+// A line continues current event when it matches continuation pattern or, when only start pattern is given,
+// when it doesn't match start pattern. Patterns given override the ones of preset.
+type MultilineParams struct {
+	Preset       string `json:"preset,omitempty"`
+	Start        string `json:"start,omitempty"`
+	Continuation string `json:"continuation,omitempty"`
+	MaxLines     int    `json:"max_lines,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+}
+
+// IsZero - multi-line aggregation is disabled
+func (m MultilineParams) IsZero() bool {
+	return m.Preset == "" && m.Start == "" && m.Continuation == ""
 }
 
 type DrainType string
@@ -126,6 +126,5 @@ func (f *AppFilter) filterJson(message string) map[string]interface{} {
 }
 
 func (f *AppFilter) Match(pMes *rfc5424.SyslogMessage) bool {
-	r := regexp.MustCompile(`^\[APP/[A-Za-z]+/.+]`)
-	return r.MatchString(*pMes.ProcID)
+	return regexAppProcID.MatchString(*pMes.ProcID)
 }
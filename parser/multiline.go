@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	MultilinePresetJava      = "java"
+	MultilinePresetPython    = "python"
+	MultilinePresetGo        = "go"
+	MultilinePresetRuby      = "ruby"
+	multilineDefaultMaxLines = 200
+	multilineMaxLines        = 1000
+	multilineDefaultTimeout  = time.Second
+	multilineMaxTimeout      = 10 * time.Second
+)
+
+// multilinePresets - continuation patterns of stack traces by language
+var multilinePresets = map[string]model.MultilineParams{
+	MultilinePresetJava: {
+		Continuation: `^(\s+at\s|\s+\.\.\.\s+\d+\s+(more|common frames omitted)|\s*(Caused by|Suppressed):\s)`,
+	},
+	MultilinePresetPython: {
+		Continuation: `^(\s+|Traceback \(most recent call last\):|During handling of the above exception|The above exception was the direct cause|[A-Za-z_][\w.]*(Error|Exception|Warning|Exit|Interrupt)(:|$))`,
+	},
+	MultilinePresetGo: {
+		Continuation: `^(\s+|$|goroutine \d+ \[|created by |exit status \d+|\[signal |[\w./*()\[\]-]+\(.*\)$)`,
+	},
+	MultilinePresetRuby: {
+		Continuation: `^(\s+from\s|\s*[^\s:]+:\d+:in\s)`,
+	},
+}
+
+// regexAppProcID - process id of app logs, only them are aggregated
+var regexAppProcID = regexp.MustCompile(`^\[APP/[A-Za-z]+/.+]`)
+
+// MultilineRule - compiled multi-line parameters of an instance
+type MultilineRule struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	maxLines     int
+	timeout      time.Duration
+}
+
+// NewMultilineRule -
+// 1. load patterns of preset, patterns given override them
+// 2. compile patterns, max lines and timeout are bounded for limiting memory used by pending events
+func NewMultilineRule(params model.MultilineParams) (*MultilineRule, error) {
+	// 1.
+	start, continuation := params.Start, params.Continuation
+	if params.Preset != "" {
+		preset, ok := multilinePresets[strings.ToLower(params.Preset)]
+		if !ok {
+			return nil, fmt.Errorf("unknown multiline preset '%s', only `%s`, `%s`, `%s` or `%s` are allowed",
+				params.Preset, MultilinePresetJava, MultilinePresetPython, MultilinePresetGo, MultilinePresetRuby)
+		}
+		if start == "" && continuation == "" {
+			start, continuation = preset.Start, preset.Continuation
+		}
+	}
+	if start == "" && continuation == "" {
+		return nil, fmt.Errorf("multiline requires a preset, a start or a continuation pattern")
+	}
+
+	// 2.
+	rule := &MultilineRule{
+		maxLines: params.MaxLines,
+		timeout:  multilineDefaultTimeout,
+	}
+	var err error
+	if start != "" {
+		rule.start, err = regexp.Compile(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern: %s", err.Error())
+		}
+	}
+	if continuation != "" {
+		rule.continuation, err = regexp.Compile(continuation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline continuation pattern: %s", err.Error())
+		}
+	}
+	if params.Timeout != "" {
+		rule.timeout, err = time.ParseDuration(params.Timeout)
+		if err != nil || rule.timeout <= 0 {
+			return nil, fmt.Errorf("invalid multiline timeout '%s'", params.Timeout)
+		}
+	}
+	if rule.maxLines <= 0 {
+		rule.maxLines = multilineDefaultMaxLines
+	}
+	rule.maxLines = min(rule.maxLines, multilineMaxLines)
+	rule.timeout = min(rule.timeout, multilineMaxTimeout)
+	return rule, nil
+}
+
+// continues - line continues event started by previous lines
+func (r *MultilineRule) continues(line string) bool {
+	if r.continuation != nil {
+		return r.continuation.MatchString(line)
+	}
+	return !r.start.MatchString(line)
+}
+
+type multilineEvent struct {
+	bindingID string
+	rev       int
+	first     *rfc5424.SyslogMessage
+	lines     []string
+	timer     *time.Timer
+}
+
+// message - first message of event with lines joined as message
+func (e *multilineEvent) message() *rfc5424.SyslogMessage {
+	e.first.SetMessage(strings.Join(e.lines, "\n"))
+	return e.first
+}
+
+// Multiline -
+// aggregate app logs written on several lines per binding and app instance before parsing.
+// Events are given back when a new event starts or when they reach max lines,
+// they are given to flush function when no line is received until timeout.
+// Lines of a binding and app instance must be added in order, e.g. by a single goroutine.
+type Multiline struct {
+	flush func(bindingID string, rev int, message *rfc5424.SyslogMessage)
+
+	mu     sync.Mutex // guards fields below
+	rules  map[model.MultilineParams]*MultilineRule
+	events map[string]*multilineEvent
+}
+
+func NewMultiline(flush func(bindingID string, rev int, message *rfc5424.SyslogMessage)) *Multiline {
+	return &Multiline{
+		flush:  flush,
+		rules:  make(map[model.MultilineParams]*MultilineRule),
+		events: make(map[string]*multilineEvent),
+	}
+}
+
+// Rule - compiled rule of parameters, rules are cached as they are shared by all logs of an instance
+func (m *Multiline) Rule(params model.MultilineParams) (*MultilineRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rule, ok := m.rules[params]; ok {
+		return rule, nil
+	}
+	rule, err := NewMultilineRule(params)
+	if err != nil {
+		return nil, err
+	}
+	m.rules[params] = rule
+	return rule, nil
+}
+
+// Add -
+// 1. give back message as is when it is not an app log
+// 2. append line to pending event of its binding and app instance when it continues it,
+// event is given back when it reaches max lines
+// 3. otherwise line starts a new event and pending one is given back
+// Nil is given back when message is kept in a pending event, messages kept are owned by multiline.
+func (m *Multiline) Add(bindingID string, rev int, rule *MultilineRule, parsed *rfc5424.SyslogMessage) *rfc5424.SyslogMessage {
+	// 1.
+	if parsed.ProcID == nil || parsed.Message == nil || !regexAppProcID.MatchString(*parsed.ProcID) {
+		return parsed
+	}
+	key := bindingID + "~" + *parsed.ProcID
+	line := strings.TrimRight(*parsed.Message, "\r\n")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 2.
+	event, pending := m.events[key]
+	if pending && rule.continues(line) {
+		event.lines = append(event.lines, line)
+		if len(event.lines) < rule.maxLines {
+			event.timer.Reset(rule.timeout)
+			return nil
+		}
+		event.timer.Stop()
+		delete(m.events, key)
+		return event.message()
+	}
+
+	// 3.
+	next := &multilineEvent{
+		bindingID: bindingID,
+		rev:       rev,
+		first:     parsed,
+		lines:     []string{line},
+	}
+	next.timer = time.AfterFunc(rule.timeout, func() {
+		m.expire(key, next)
+	})
+	m.events[key] = next
+	if !pending {
+		return nil
+	}
+	event.timer.Stop()
+	return event.message()
+}
+
+// expire - give event to flush function if it is still pending
+func (m *Multiline) expire(key string, event *multilineEvent) {
+	m.mu.Lock()
+	if m.events[key] != event {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.events, key)
+	message := event.message()
+	m.mu.Unlock()
+	m.flush(event.bindingID, event.rev, message)
+}
+
+// Close - give all pending events to flush function
+func (m *Multiline) Close() {
+	m.mu.Lock()
+	events := m.events
+	m.events = make(map[string]*multilineEvent)
+	m.mu.Unlock()
+	for _, event := range events {
+		event.timer.Stop()
+		m.flush(event.bindingID, event.rev, event.message())
+	}
+}
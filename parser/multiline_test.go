@@ -0,0 +1,106 @@
+package parser_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+)
+
+var _ = Describe("Multiline", func() {
+	var multiline *parser.Multiline
+	var mu sync.Mutex
+	var flushed []string
+
+	line := func(procID, msg string) *rfc5424.SyslogMessage {
+		parsed, err := rfc5424.NewParser().Parse([]byte(fmt.Sprintf("<14>1 2006-01-02T15:04:05Z org.space.app id-1 %s - - %s", procID, msg)))
+		Expect(err).ToNot(HaveOccurred())
+		return parsed.(*rfc5424.SyslogMessage)
+	}
+	message := func(msg *rfc5424.SyslogMessage) string {
+		Expect(msg).ToNot(BeNil())
+		return *msg.Message
+	}
+	rule := func(params model.MultilineParams) *parser.MultilineRule {
+		r, err := multiline.Rule(params)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	BeforeEach(func() {
+		flushed = nil
+		multiline = parser.NewMultiline(func(bindingID string, rev int, msg *rfc5424.SyslogMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			flushed = append(flushed, bindingID+":"+message(msg))
+		})
+	})
+
+	It("should join java stack trace when next event starts", func() {
+		r := rule(model.MultilineParams{Preset: "java"})
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "java.lang.IllegalStateException: boom"))).To(BeNil())
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "\tat com.example.Foo.bar(Foo.java:12)"))).To(BeNil())
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "Caused by: java.io.IOException: disk"))).To(BeNil())
+
+		event := multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "next event"))
+		Expect(message(event)).To(Equal("java.lang.IllegalStateException: boom\n" +
+			"\tat com.example.Foo.bar(Foo.java:12)\n" +
+			"Caused by: java.io.IOException: disk"))
+	})
+
+	It("should group lines by app instance", func() {
+		r := rule(model.MultilineParams{Start: `^\d{4}-`})
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "2006-01-02 first"))).To(BeNil())
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/1]", "2006-01-02 other"))).To(BeNil())
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "  detail"))).To(BeNil())
+
+		event := multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "2006-01-02 second"))
+		Expect(message(event)).To(Equal("2006-01-02 first\n  detail"))
+	})
+
+	It("should give back event reaching max lines", func() {
+		r := rule(model.MultilineParams{Preset: "ruby", MaxLines: 2})
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "RuntimeError"))).To(BeNil())
+		event := multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "	from app.rb:3:in `run'"))
+		Expect(message(event)).To(Equal("RuntimeError\n	from app.rb:3:in `run'"))
+	})
+
+	It("should give back logs which are not app logs as is", func() {
+		r := rule(model.MultilineParams{Preset: "go"})
+		msg := line("[RTR/0]", "  indented")
+		Expect(multiline.Add("b1", 1, r, msg)).To(BeIdenticalTo(msg))
+	})
+
+	It("should flush pending event on timeout and on close", func() {
+		r := rule(model.MultilineParams{Preset: "python", Timeout: "50ms"})
+		Expect(multiline.Add("b1", 1, r, line("[APP/PROC/WEB/0]", "Traceback (most recent call last):"))).To(BeNil())
+		Expect(multiline.Add("b2", 1, r, line("[APP/PROC/WEB/0]", "started"))).To(BeNil())
+		Expect(multiline.Add("b2", 1, r, line("[APP/PROC/WEB/0]", "ValueError: bad"))).To(BeNil())
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return flushed
+		}, time.Second).Should(ConsistOf("b1:Traceback (most recent call last):", "b2:started\nValueError: bad"))
+
+		flushed = nil
+		r = rule(model.MultilineParams{Preset: "python", Timeout: "10s"})
+		Expect(multiline.Add("b3", 1, r, line("[APP/PROC/WEB/0]", "pending"))).To(BeNil())
+		multiline.Close()
+		Expect(flushed).To(ConsistOf("b3:pending"))
+	})
+
+	It("should refuse invalid parameters", func() {
+		_, err := parser.NewMultilineRule(model.MultilineParams{Preset: "cobol"})
+		Expect(err).To(MatchError(ContainSubstring("unknown multiline preset 'cobol'")))
+		_, err = parser.NewMultilineRule(model.MultilineParams{Start: "("})
+		Expect(err).To(MatchError(ContainSubstring("invalid multiline start pattern")))
+		_, err = parser.NewMultilineRule(model.MultilineParams{Preset: "java", Timeout: "soon"})
+		Expect(err).To(MatchError(ContainSubstring("invalid multiline timeout 'soon'")))
+	})
+})
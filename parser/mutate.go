@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/tpl"
+)
+
+const (
+	ConvertInt       = "int"
+	ConvertFloat     = "float"
+	ConvertBool      = "bool"
+	ConvertTimestamp = "timestamp"
+	maxMutations     = 50
+	// mutateExceptionKey - field describing operations which failed
+	mutateExceptionKey = "@exception_mutate"
+)
+
+// Mutations - checked mutations, applied in order
+type Mutations []model.Mutation
+
+// NewMutations - check that each mutation has an operation with valid conversion types, separators and templates
+func NewMutations(mutations []model.Mutation) (Mutations, error) {
+	if len(mutations) > maxMutations {
+		return nil, fmt.Errorf("too many mutations, only %d are allowed", maxMutations)
+	}
+	for i, m := range mutations {
+		if m.IsZero() {
+			return nil, fmt.Errorf("mutation %d: no operation is set", i+1)
+		}
+		for key, to := range m.Convert {
+			switch strings.ToLower(to) {
+			case ConvertInt, ConvertFloat, ConvertBool, ConvertTimestamp:
+			default:
+				return nil, fmt.Errorf("mutation %d: cannot convert '%s' to unknown type '%s', only `%s`, `%s`, `%s` or `%s` are allowed",
+					i+1, key, to, ConvertInt, ConvertFloat, ConvertBool, ConvertTimestamp)
+			}
+		}
+		for key, sep := range m.Split {
+			if sep == "" {
+				return nil, fmt.Errorf("mutation %d: separator for splitting '%s' is empty", i+1, key)
+			}
+		}
+		if err := tpl.Check(m.AddField); err != nil {
+			return nil, fmt.Errorf("mutation %d: invalid template in add_field: %s", i+1, err.Error())
+		}
+	}
+	return mutations, nil
+}
+
+// Apply -
+// apply each mutation on data, operations which can't be done are skipped and described in `@exception_mutate`.
+// Templates of added fields are executed with given data.
+func (ms Mutations) Apply(data map[string]interface{}, tplData TemplateData) {
+	errs := make([]string, 0)
+	for _, m := range ms {
+		for _, from := range sortedKeys(m.Rename) {
+			if v, ok := removeField(data, from); ok {
+				putField(data, m.Rename[from], v)
+			}
+		}
+		for _, from := range sortedKeys(m.Copy) {
+			if v, ok := getField(data, from); ok {
+				putField(data, m.Copy[from], v)
+			}
+		}
+		for _, key := range sortedKeys(m.Convert) {
+			v, ok := getField(data, key)
+			if !ok {
+				continue
+			}
+			converted, err := convert(v, strings.ToLower(m.Convert[key]))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("cannot convert '%s' to %s: %s", key, m.Convert[key], err.Error()))
+				continue
+			}
+			putField(data, key, converted)
+		}
+		if len(m.AddField) > 0 {
+			tplData.Logdata = data
+			fields, err := tpl.NewTemplater(tplData).Execute(m.AddField)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("cannot add fields: %s", err.Error()))
+			}
+			for _, key := range sortedKeys(fields) {
+				putField(data, key, fields[key])
+			}
+		}
+		for _, key := range m.Lowercase {
+			if v, ok := getField(data, key); ok {
+				putField(data, key, lowercase(v))
+			}
+		}
+		for _, key := range sortedKeys(m.Split) {
+			v, _ := getField(data, key)
+			if v, ok := v.(string); ok {
+				parts := make([]interface{}, 0)
+				for _, part := range strings.Split(v, m.Split[key]) {
+					parts = append(parts, part)
+				}
+				putField(data, key, parts)
+			}
+		}
+		for _, key := range m.Flatten {
+			parent, last := fieldParent(data, key, false)
+			if sub, ok := parent[last].(map[string]interface{}); ok {
+				delete(parent, last)
+				flatten(parent, last, sub)
+			}
+		}
+		for _, key := range m.Remove {
+			removeField(data, key)
+		}
+	}
+	if len(errs) > 0 {
+		data[mutateExceptionKey] = strings.Join(errs, "; ")
+	}
+}
+
+// convert -
+// value converted to given type, timestamps are given as rfc 3339 in utc,
+// they are parsed as in auto format of timestamp extraction
+func convert(v interface{}, to string) (interface{}, error) {
+	switch to {
+	case ConvertInt:
+		if s, ok := v.(string); ok {
+			f, err := cast.ToFloat64E(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			return int64(f), nil
+		}
+		return cast.ToInt64E(v)
+	case ConvertFloat:
+		return cast.ToFloat64E(v)
+	case ConvertBool:
+		if s, ok := v.(string); ok {
+			switch strings.ToLower(strings.TrimSpace(s)) {
+			case "yes", "y", "on":
+				return true, nil
+			case "no", "n", "off", "":
+				return false, nil
+			}
+		}
+		return cast.ToBoolE(v)
+	}
+	t, err := parseTimestamp(v)
+	if err != nil {
+		return nil, err
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+// sortedKeys - keys of operation sorted for applying it in same order on each log
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func lowercase(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		return strings.ToLower(value)
+	case []interface{}:
+		lowered := make([]interface{}, len(value))
+		for i, sub := range value {
+			lowered[i] = lowercase(sub)
+		}
+		return lowered
+	}
+	return v
+}
+
+// flatten - set nested fields of map in parent with their keys joined by dots
+func flatten(parent map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			flatten(parent, prefix+"."+k, sub)
+			continue
+		}
+		parent[prefix+"."+k] = v
+	}
+}
+
+// fieldParent -
+// map holding field at dotted path with its key in this map, keys containing dots set by flatten or otel schema
+// are found before nested ones. Missing intermediate maps are created when requested.
+func fieldParent(data map[string]interface{}, path string, create bool) (map[string]interface{}, string) {
+	current := data
+	for {
+		if _, ok := current[path]; ok {
+			return current, path
+		}
+		head, rest, found := strings.Cut(path, ".")
+		if !found {
+			return current, path
+		}
+		sub, ok := current[head].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			sub = make(map[string]interface{})
+			current[head] = sub
+		}
+		current, path = sub, rest
+	}
+}
+
+func getField(data map[string]interface{}, path string) (interface{}, bool) {
+	parent, key := fieldParent(data, path, false)
+	v, ok := parent[key]
+	return v, ok
+}
+
+func putField(data map[string]interface{}, path string, value interface{}) {
+	parent, key := fieldParent(data, path, true)
+	parent[key] = value
+}
+
+func removeField(data map[string]interface{}, path string) (interface{}, bool) {
+	parent, key := fieldParent(data, path, false)
+	v, ok := parent[key]
+	if ok {
+		delete(parent, key)
+	}
+	return v, ok
+}
+
+// SetPlanMutations - mutate fields of logs sent to given plan
+func (p *Parser) SetPlanMutations(planName string, mutations []model.Mutation) error {
+	ms, err := NewMutations(mutations)
+	if err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		delete(p.mutations, planName)
+		return nil
+	}
+	p.mutations[planName] = ms
+	return nil
+}
+
+// mutate - apply mutations of user or, when user has none, the ones of plan
+func (p Parser) mutate(logData *model.LogMetadata, data map[string]interface{}, tplData TemplateData) error {
+	ms := p.mutations[logData.InstanceParam.SyslogName]
+	if logData.InstanceParam.Mutate != "" {
+		var err error
+		ms, err = p.userMutations.get(&logData.InstanceParam)
+		if err != nil {
+			return err
+		}
+	}
+	ms.Apply(data, tplData)
+	return nil
+}
+
+// mutationsCache - checked mutations of users by their stored json
+type mutationsCache struct {
+	mu        sync.RWMutex
+	mutations map[string]Mutations
+}
+
+func (c *mutationsCache) get(param *model.InstanceParam) (Mutations, error) {
+	c.mu.RLock()
+	ms, ok := c.mutations[param.Mutate]
+	c.mu.RUnlock()
+	if ok {
+		return ms, nil
+	}
+	mutations, err := param.GetMutations()
+	if err != nil {
+		return nil, err
+	}
+	ms, err = NewMutations(mutations)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.mutations[param.Mutate] = ms
+	c.mu.Unlock()
+	return ms, nil
+}
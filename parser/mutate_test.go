@@ -0,0 +1,120 @@
+package parser_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+)
+
+var _ = Describe("Mutations", func() {
+	var data map[string]interface{}
+
+	apply := func(mutations ...model.Mutation) {
+		ms, err := parser.NewMutations(mutations)
+		Expect(err).ToNot(HaveOccurred())
+		ms.Apply(data, parser.TemplateData{App: "my-app"})
+	}
+
+	BeforeEach(func() {
+		data = map[string]interface{}{
+			"@level": "INFO",
+			"app": map[string]interface{}{
+				"user":    map[string]interface{}{"id": "42", "admin": "yes", "name": "John"},
+				"tags":    "a,b,C",
+				"elapsed": "12.7",
+				"at":      "1136214245000",
+			},
+			"http.request.method": "GET",
+		}
+	})
+
+	It("should rename, copy and remove fields", func() {
+		apply(model.Mutation{
+			Rename: map[string]string{"app.user.name": "user.name", "http.request.method": "method"},
+			Copy:   map[string]string{"@level": "log.level"},
+			Remove: []string{"@level", "app.missing"},
+		})
+		Expect(data).ToNot(HaveKey("@level"))
+		Expect(data).ToNot(HaveKey("http.request.method"))
+		Expect(data).To(HaveKeyWithValue("method", "GET"))
+		Expect(data).To(HaveKeyWithValue("user", HaveKeyWithValue("name", "John")))
+		Expect(data).To(HaveKeyWithValue("log", HaveKeyWithValue("level", "INFO")))
+		Expect(data["app"]).To(HaveKeyWithValue("user", Not(HaveKey("name"))))
+	})
+
+	It("should convert fields and report failed conversions", func() {
+		apply(model.Mutation{
+			Convert: map[string]string{
+				"app.user.id":    "int",
+				"app.user.admin": "bool",
+				"app.elapsed":    "float",
+				"app.at":         "timestamp",
+				"@level":         "int",
+			},
+		})
+		app := data["app"].(map[string]interface{})
+		Expect(app["user"]).To(HaveKeyWithValue("id", int64(42)))
+		Expect(app["user"]).To(HaveKeyWithValue("admin", true))
+		Expect(app).To(HaveKeyWithValue("elapsed", 12.7))
+		Expect(app).To(HaveKeyWithValue("at", "2006-01-02T15:04:05Z"))
+		Expect(data).To(HaveKeyWithValue("@level", "INFO"))
+		Expect(data).To(HaveKeyWithValue("@exception_mutate", ContainSubstring("cannot convert '@level' to int")))
+	})
+
+	It("should add templated fields, lowercase, split and flatten in order", func() {
+		apply(
+			model.Mutation{
+				AddField:  map[string]string{"service": `{{ .App }}-{{ ret .Logdata "app.user.id" }}`},
+				Lowercase: []string{"@level"},
+				Split:     map[string]string{"app.tags": ","},
+			},
+			model.Mutation{
+				Lowercase: []string{"app.tags"},
+				Flatten:   []string{"app"},
+			},
+		)
+		Expect(data).To(HaveKeyWithValue("service", "my-app-42"))
+		Expect(data).To(HaveKeyWithValue("@level", "info"))
+		Expect(data).ToNot(HaveKey("app"))
+		Expect(data).To(HaveKeyWithValue("app.tags", []interface{}{"a", "b", "c"}))
+		Expect(data).To(HaveKeyWithValue("app.user.id", "42"))
+	})
+
+	It("should refuse invalid mutations", func() {
+		_, err := parser.NewMutations([]model.Mutation{{}})
+		Expect(err).To(MatchError(ContainSubstring("mutation 1: no operation is set")))
+		_, err = parser.NewMutations([]model.Mutation{{Convert: map[string]string{"a": "date"}}})
+		Expect(err).To(MatchError(ContainSubstring("cannot convert 'a' to unknown type 'date'")))
+		_, err = parser.NewMutations([]model.Mutation{{Split: map[string]string{"a": ""}}})
+		Expect(err).To(MatchError(ContainSubstring("separator for splitting 'a' is empty")))
+		_, err = parser.NewMutations([]model.Mutation{{AddField: map[string]string{"a": "{{ .App "}}})
+		Expect(err).To(MatchError(ContainSubstring("invalid template in add_field")))
+	})
+
+	It("should replace mutations of plan by the ones of user when parsing", func() {
+		gParser := parser.NewParser(nil, true)
+		Expect(gParser.SetPlanMutations("loghost", []model.Mutation{{Rename: map[string]string{"@message": "message"}}})).To(Succeed())
+		metadata := getMetadata("org-id", "space-id", "app-id")
+		appMsg := fmt.Sprintf(`<14>1 %s org.space.app - [APP/PROC/WEB/0] - - my message`, time.Now().Format(time.RFC3339))
+		parse := func() map[string]interface{} {
+			parsed, err := gParser.Parse(metadata, []byte(appMsg), nil)
+			Expect(err).ToNot(HaveOccurred())
+			jsonLog := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(*parsed.Message), &jsonLog)).To(Succeed())
+			return jsonLog
+		}
+
+		Expect(parse()).To(HaveKeyWithValue("message", "my message"))
+
+		metadata.InstanceParam.SetMutations([]model.Mutation{{Remove: []string{"@cf"}}})
+		jsonLog := parse()
+		Expect(jsonLog).To(HaveKeyWithValue("@message", "my message"))
+		Expect(jsonLog).ToNot(HaveKey("@cf"))
+	})
+})
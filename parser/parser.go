@@ -34,6 +34,24 @@ type Parser struct {
 	filters                  []Filter
 	p5424                    syslog.Machine
 	ignoreTagsStructuredData bool
+	// schemas - schema of plans whose logs are not sent with native fields
+	schemas map[string]Schema
+	rules   *rulesCache
+	// redactions - redaction of plans, applied before the one requested by users
+	redactions map[string]planRedaction
+	redactors  *redactorsCache
+	// mutations - mutations of plans, replaced by the ones requested by users
+	mutations     map[string]Mutations
+	userMutations *mutationsCache
+	// timestamps - timestamp extractions of plans, replaced by the ones requested by users
+	timestamps     map[string]*TimestampExtractor
+	userTimestamps *timestampsCache
+}
+
+type planRedaction struct {
+	redactor *Redactor
+	hashKey  string
+	err      error
 }
 
 type TemplateData struct {
@@ -89,6 +107,14 @@ func NewParser(parsingKeys []model.ParsingKey, ignoreTagsStructuredData bool) *P
 	return &Parser{
 		ignoreTagsStructuredData: ignoreTagsStructuredData,
 		p5424:                    rfc5424.NewParser(),
+		schemas:                  make(map[string]Schema),
+		rules:                    &rulesCache{rules: make(map[string]Rules)},
+		redactions:               make(map[string]planRedaction),
+		redactors:                &redactorsCache{redactors: make(map[string]*Redactor)},
+		mutations:                make(map[string]Mutations),
+		userMutations:            &mutationsCache{mutations: make(map[string]Mutations)},
+		timestamps:               make(map[string]*TimestampExtractor),
+		userTimestamps:           &timestampsCache{extractors: make(map[string]*TimestampExtractor)},
 		filters: []Filter{
 			&DefaultFilter{grokParser},
 			&MetricsFilter{},
@@ -103,14 +129,43 @@ func (p Parser) Parse(
 	message []byte,
 	patterns []string,
 ) (*rfc5424.SyslogMessage, error) {
+	parsed, _, err := p.ParseWithData(logData, message, patterns)
+	return parsed, err
+}
+
+// ParseWithData -
+// same as Parse, data set in json message of parsed message is also given back
+// with values a json decoder would give, for writers not decoding message again
+func (p Parser) ParseWithData(
+	logData *model.LogMetadata,
+	message []byte,
+	patterns []string,
+) (*rfc5424.SyslogMessage, map[string]interface{}, error) {
+	parsed, err := p.ParseSyslog(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.ParseMessageWithData(logData, parsed, patterns)
+}
+
+// ParseSyslog - rfc 5424 message as received, it can be given to ParseMessageWithData for not parsing it again
+func (p Parser) ParseSyslog(message []byte) (*rfc5424.SyslogMessage, error) {
 	parsedRaw, err := p.p5424.Parse(message)
 	if err != nil {
 		return nil, err
 	}
-	parsed := parsedRaw.(*rfc5424.SyslogMessage)
+	return parsedRaw.(*rfc5424.SyslogMessage), nil
+}
+
+// ParseMessageWithData - same as ParseWithData on a message already parsed as rfc 5424, given message is modified
+func (p Parser) ParseMessageWithData(
+	logData *model.LogMetadata,
+	parsed *rfc5424.SyslogMessage,
+	patterns []string,
+) (*rfc5424.SyslogMessage, map[string]interface{}, error) {
 	if parsed.Message == nil || strings.TrimSpace(*parsed.Message) == "" {
 		if !isMetrics(parsed) {
-			return nil, nil
+			return nil, nil, nil
 		}
 	}
 
@@ -157,6 +212,31 @@ func (p Parser) Parse(
 		}
 	}
 
+	// timestamp of app replaces the one of syslog header, also for writers using header
+	t, extracted, err := p.extractTimestamp(logData, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if extracted {
+		parsed.Timestamp = &t
+	}
+
+	// filtering rules of user are evaluated on fields built by filters
+	if logData.InstanceParam.Rules != "" {
+		rules, err := p.rules.get(&logData.InstanceParam)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !rules.Keep(data) {
+			return nil, nil, ErrDropped
+		}
+	}
+
+	// redaction is done before tags templating for not leaking redacted data in tags
+	if err := p.redact(logData, data); err != nil {
+		return nil, nil, err
+	}
+
 	if len(logData.InstanceParam.SourceLabels) > 0 {
 		currentSource := make(map[string]interface{})
 		if _, ok := data["@source"]; ok {
@@ -170,7 +250,7 @@ func (p Parser) Parse(
 		}
 		data["@source"] = utils.MergeMap(sourceLabelMap, currentSource)
 	}
-	tags, err = tpl.NewTemplater(TemplateData{
+	tplData := TemplateData{
 		Org:       org,
 		OrgID:     logData.InstanceParam.OrgID,
 		Space:     space,
@@ -179,18 +259,28 @@ func (p Parser) Parse(
 		AppID:     logData.AppID,
 		App:       app,
 		Logdata:   data,
-	}).Execute(tags)
+	}
+	tags, err = tpl.NewTemplater(tplData).Execute(tags)
 	if err != nil {
 		data["@exception_tag"] = err.Error()
 	}
 	for k, v := range tags {
 		msgParam.SetParameter(compID, k, v)
 	}
+	// schema mapping is done last for keeping native fields available in tags templating
+	if schema, ok := p.schemas[logData.InstanceParam.SyslogName]; ok {
+		data = schema.Map(data)
+	}
+	// mutations are done on fields as they are sent
+	if err := p.mutate(logData, data, tplData); err != nil {
+		return nil, nil, err
+	}
 	b, _ := json.Marshal(data)
 	structDataPtr := parsed.StructuredData
 	*structDataPtr = msgParam
 	parsed.SetMessage(string(b) + "\n")
-	return parsed, nil
+	jsonData, _ := utils.JSONValue(data).(map[string]interface{})
+	return parsed, jsonData, nil
 }
 
 func (p Parser) ParseHost(parsed *rfc5424.SyslogMessage) (org, space, app string) {
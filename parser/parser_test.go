@@ -124,6 +124,10 @@ var _ = Describe("Parser", func() {
 
 			Expect(jsonLog["@message"]).To(Equal(fmt.Sprintln(msg)))
 			Expect(jsonLog["@source"].(map[string]interface{})["type"]).To(Equal("APP"))
+
+			_, data, err := gParser.ParseWithData(metadata, message[0], programPatterns)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(jsonLog))
 		}
 
 		It("returns expected @cf fields", func() {
@@ -178,6 +182,111 @@ var _ = Describe("Parser", func() {
 		})
 	})
 
+	Context("Map on ECS schema", func() {
+
+		BeforeEach(func() {
+			Expect(gParser.SetPlanSchema("loghost", parser.SchemaECS)).To(Succeed())
+		})
+
+		It("returns ecs fields for router logs", func() {
+			timestamp := time.Now().Format("2006-01-02T15:04:05.999999Z")
+			rtrTpl := `<14>1 %s %s.%s.%s - [RTR/3] - - %s.example.com:443 - [%s] "GET /v0/contracts?context=care HTTP/1.1" 404 0 2 "-" "curl/8.0" "10.77.106.3:35587" "10.77.106.122:61104" x_forwarded_for:"10.117.28.10, 10.77.106.3" x_forwarded_proto:"https" vcap_request_id:"f7314b39-3a9c-45e5-78fc-ae4b1737d4fd" response_time:0.055384 app_id:"%s" app_index:"10" traceparent:"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"`
+			rtrMsg := fmt.Sprintf(rtrTpl, timestamp, org, space, app, app, timestamp, app_id)
+
+			parsed, err := gParser.Parse(getMetadata(org_id, space_id, app_id), []byte(rtrMsg), programPatterns)
+			Expect(err).ToNot(HaveOccurred())
+			jsonLog := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(*parsed.Message), &jsonLog)).To(Succeed())
+
+			Expect(jsonLog).ToNot(HaveKey("@cf"))
+			Expect(jsonLog).ToNot(HaveKey("rtr"))
+			Expect(jsonLog).To(HaveKeyWithValue("message", "404 GET /v0/contracts?context=care (55 ms)"))
+			Expect(jsonLog).To(HaveKeyWithValue("log", HaveKeyWithValue("level", "ERROR")))
+			Expect(jsonLog).To(HaveKeyWithValue("cloud", HaveKeyWithValue("account", HaveKeyWithValue("id", org_id))))
+			Expect(jsonLog).To(HaveKeyWithValue("service", HaveKeyWithValue("name", app)))
+			Expect(jsonLog).To(HaveKeyWithValue("http", And(
+				HaveKeyWithValue("request", HaveKeyWithValue("method", "GET")),
+				HaveKeyWithValue("response", HaveKeyWithValue("status_code", float64(404))),
+				HaveKeyWithValue("version", "1.1"),
+			)))
+			Expect(jsonLog).To(HaveKeyWithValue("url", And(
+				HaveKeyWithValue("path", "/v0/contracts"),
+				HaveKeyWithValue("query", "context=care"),
+				HaveKeyWithValue("scheme", "https"),
+			)))
+			Expect(jsonLog).To(HaveKeyWithValue("user_agent", HaveKeyWithValue("original", "curl/8.0")))
+			Expect(jsonLog).To(HaveKeyWithValue("source", HaveKeyWithValue("ip", "10.117.28.10")))
+			Expect(jsonLog).To(HaveKeyWithValue("trace", HaveKeyWithValue("id", "4bf92f3577b34da6a3ce929d0e0e4736")))
+			Expect(jsonLog).To(HaveKeyWithValue("span", HaveKeyWithValue("id", "00f067aa0ba902b7")))
+			Expect(jsonLog).To(HaveKeyWithValue("cloudfoundry", HaveKeyWithValue("rtr", HaveKeyWithValue("app_index", float64(10)))))
+		})
+
+		It("keeps native fields for other plans", func() {
+			metadata := getMetadata(org_id, space_id, app_id)
+			metadata.InstanceParam.SyslogName = "other"
+			appMsg := fmt.Sprintf(`<14>1 %s %s.%s.%s - [APP/PROC/WEB/0] - - my message`, time.Now().Format(time.RFC3339), org, space, app)
+
+			parsed, err := gParser.Parse(metadata, []byte(appMsg), programPatterns)
+			Expect(err).ToNot(HaveOccurred())
+			jsonLog := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(*parsed.Message), &jsonLog)).To(Succeed())
+			Expect(jsonLog).To(HaveKey("@cf"))
+			Expect(jsonLog).To(HaveKeyWithValue("@message", "my message"))
+		})
+
+		It("refuses unknown schemas", func() {
+			Expect(gParser.SetPlanSchema("loghost", "xml")).To(MatchError(ContainSubstring("unknown schema 'xml'")))
+		})
+	})
+
+	Context("Map on OTel schema", func() {
+
+		It("returns http semantic conventions attributes and trace context for router logs", func() {
+			Expect(gParser.SetPlanSchema("loghost", parser.SchemaOTel)).To(Succeed())
+			timestamp := time.Now().Format("2006-01-02T15:04:05.999999Z")
+			rtrTpl := `<14>1 %s %s.%s.%s - [RTR/3] - - %s.example.com:443 - [%s] "POST /v0/contracts?context=care HTTP/1.1" 201 10 2 "-" "curl/8.0" "10.77.106.3:35587" "10.77.106.122:61104" x_forwarded_for:"10.117.28.10, 10.77.106.3" x_forwarded_proto:"https" vcap_request_id:"f7314b39-3a9c-45e5-78fc-ae4b1737d4fd" response_time:0.055384 app_id:"%s" app_index:"10" x_b3_traceid:"9a1882fe065a9d9f" x_b3_spanid:"9a1882fe065a9d9f" x_b3_parentspanid:"-" b3:"9a1882fe065a9d9f-1b1882fe065a9d9f"`
+			rtrMsg := fmt.Sprintf(rtrTpl, timestamp, org, space, app, app, timestamp, app_id)
+
+			parsed, err := gParser.Parse(getMetadata(org_id, space_id, app_id), []byte(rtrMsg), programPatterns)
+			Expect(err).ToNot(HaveOccurred())
+			jsonLog := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(*parsed.Message), &jsonLog)).To(Succeed())
+
+			Expect(jsonLog).To(HaveKey("@cf"))
+			Expect(jsonLog).To(HaveKeyWithValue("http.request.method", "POST"))
+			Expect(jsonLog).To(HaveKeyWithValue("http.response.status_code", float64(201)))
+			Expect(jsonLog).To(HaveKeyWithValue("url.path", "/v0/contracts"))
+			Expect(jsonLog).To(HaveKeyWithValue("url.query", "context=care"))
+			Expect(jsonLog).To(HaveKeyWithValue("url.scheme", "https"))
+			Expect(jsonLog).To(HaveKeyWithValue("client.address", "10.117.28.10"))
+			Expect(jsonLog).To(HaveKeyWithValue("server.address", app+".example.com"))
+			Expect(jsonLog).To(HaveKeyWithValue("network.protocol.version", "1.1"))
+			Expect(jsonLog).To(HaveKeyWithValue("trace_id", "00000000000000009a1882fe065a9d9f"))
+			Expect(jsonLog).To(HaveKeyWithValue("span_id", "1b1882fe065a9d9f"))
+			Expect(jsonLog["rtr"]).ToNot(HaveKey("verb"))
+			Expect(jsonLog["rtr"]).To(HaveKeyWithValue("response_time_ms", float64(55)))
+		})
+	})
+
+	Context("Filter with rules", func() {
+
+		It("drops logs matching rules of instance and keeps others", func() {
+			metadata := getMetadata(org_id, space_id, app_id)
+			metadata.InstanceParam.SetFilterRules([]model.FilterRule{
+				{Expression: `@source.type == "APP" && @message =~ "^health"`, Action: "drop"},
+			})
+			appTpl := `<14>1 %s %s.%s.%s - [APP/PROC/WEB/0] - - %s`
+			timestamp := time.Now().Format(time.RFC3339)
+
+			_, err := gParser.Parse(metadata, []byte(fmt.Sprintf(appTpl, timestamp, org, space, app, "health check ok")), programPatterns)
+			Expect(err).To(MatchError(parser.ErrDropped))
+
+			parsed, err := gParser.Parse(metadata, []byte(fmt.Sprintf(appTpl, timestamp, org, space, app, msg)), programPatterns)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*parsed.Message).To(ContainSubstring(msg))
+		})
+	})
+
 	Context("Parse Metric Logs", func() {
 
 		It("returns expected gauge fields", func() {
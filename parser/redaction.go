@@ -0,0 +1,361 @@
+package parser
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	DetectorEmail         = "email"
+	DetectorPAN           = "pan"
+	DetectorJWT           = "jwt"
+	DetectorAuthorization = "authorization"
+	DetectorCredentials   = "credentials"
+	DetectorIPv4          = "ipv4"
+	DetectorIPv6          = "ipv6"
+
+	RedactionMask = "mask"
+	RedactionHash = "hash"
+	RedactionDrop = "drop"
+
+	redactedMask  = "[REDACTED]"
+	redactionPath = "rtr.path"
+)
+
+// detector - patterns finding data to redact, only `value` group is redacted when pattern has one.
+// Matches refused by valid function are kept.
+type detector struct {
+	regexes []*regexp.Regexp
+	valid   func(match string) bool
+}
+
+var detectors = map[string]detector{
+	DetectorEmail: {
+		regexes: []*regexp.Regexp{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	},
+	DetectorPAN: {
+		regexes: []*regexp.Regexp{regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)},
+		valid:   luhn,
+	},
+	DetectorJWT: {
+		regexes: []*regexp.Regexp{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	},
+	DetectorAuthorization: {
+		regexes: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:proxy-)?authorization"?\s*[:=]\s*"?(?:(?:bearer|basic|digest|negotiate|token)\s+)?(?P<value>[^\s",;]+)`),
+			regexp.MustCompile(`(?i)\bbearer\s+(?P<value>[A-Za-z0-9._~+/=-]{8,})`),
+		},
+	},
+	DetectorCredentials: {
+		regexes: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|client_secret|api_?key|access_token|refresh_token|token)=(?P<value>[^&\s"]+)`),
+		},
+	},
+	DetectorIPv4: {
+		regexes: []*regexp.Regexp{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)},
+		valid: func(match string) bool {
+			return net.ParseIP(match) != nil
+		},
+	},
+	DetectorIPv6: {
+		regexes: []*regexp.Regexp{regexp.MustCompile(`(?i)[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}(?:(?::|\b)(?:\d{1,3}\.){3}\d{1,3})?`)},
+		valid: func(match string) bool {
+			return strings.Trim(match, ":") != "" && net.ParseIP(match) != nil
+		},
+	},
+}
+
+// Redactor - compiled redaction, hash action requires a key
+type Redactor struct {
+	detectors []detector
+	keys      []string
+	action    string
+	hashKey   []byte
+}
+
+// NewRedactor -
+// 1. load detectors by name and compile custom patterns as detectors
+// 2. check action, hash action requires a key for not giving back data which could be found by brute force
+func NewRedactor(redaction model.Redaction, hashKey string) (*Redactor, error) {
+	// 1.
+	r := &Redactor{
+		keys:    redaction.Keys,
+		action:  redaction.GetAction(),
+		hashKey: []byte(hashKey),
+	}
+	for _, name := range redaction.Detectors {
+		d, ok := detectors[strings.ToLower(name)]
+		if !ok {
+			names := make([]string, 0, len(detectors))
+			for n := range detectors {
+				names = append(names, "`"+n+"`")
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown redaction detector '%s', only %s are allowed", name, strings.Join(names, ", "))
+		}
+		r.detectors = append(r.detectors, d)
+	}
+	for _, pattern := range redaction.Patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern '%s': %s", pattern, err.Error())
+		}
+		r.detectors = append(r.detectors, detector{regexes: []*regexp.Regexp{regex}})
+	}
+
+	// 2.
+	switch r.action {
+	case RedactionMask, RedactionDrop:
+	case RedactionHash:
+		if hashKey == "" {
+			return nil, fmt.Errorf("redaction action `%s` is not available as no hash key is set on plan", RedactionHash)
+		}
+	default:
+		return nil, fmt.Errorf("unknown redaction action '%s', only `%s`, `%s` or `%s` are allowed",
+			redaction.Action, RedactionMask, RedactionHash, RedactionDrop)
+	}
+	return r, nil
+}
+
+// Redact -
+// 1. redact `@message`
+// 2. redact query string of router logs path, dropping it only removes query string
+// 3. redact values of keys, maps and lists found are redacted recursively
+func (r *Redactor) Redact(data map[string]interface{}) {
+	// 1.
+	r.redactKey(data, MessageKey)
+
+	// 2.
+	if urlPath, ok := utils.FoundVarDelim(data, redactionPath).(string); ok {
+		if base, query, found := strings.Cut(urlPath, "?"); found {
+			if redacted, changed := r.redactString(query); changed {
+				rtr := data["rtr"].(map[string]interface{})
+				rtr["path"] = base
+				if r.action != RedactionDrop {
+					rtr["path"] = base + "?" + redacted
+				}
+			}
+		}
+	}
+
+	// 3.
+	for _, key := range r.keys {
+		r.redactKey(data, key)
+	}
+}
+
+// redactKey - redact value at dotted key, key is removed from its parent map when dropped
+func (r *Redactor) redactKey(data map[string]interface{}, key string) {
+	var parent interface{} = data
+	last := key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		parent = utils.FoundVarDelim(data, key[:i])
+		last = key[i+1:]
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		v, ok := p[last]
+		if !ok {
+			return
+		}
+		if redacted, changed := r.redactValue(v); changed {
+			if r.action == RedactionDrop {
+				delete(p, last)
+				return
+			}
+			p[last] = redacted
+		}
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(p) {
+			return
+		}
+		if redacted, changed := r.redactValue(p[i]); changed {
+			if r.action == RedactionDrop {
+				redacted = nil
+			}
+			p[i] = redacted
+		}
+	}
+}
+
+// redactValue - redacted value, maps and lists are modified in place
+func (r *Redactor) redactValue(v interface{}) (interface{}, bool) {
+	switch value := v.(type) {
+	case string:
+		return r.redactString(value)
+	case map[string]interface{}:
+		changed := false
+		for k, sub := range value {
+			if redacted, ok := r.redactValue(sub); ok {
+				changed = true
+				value[k] = redacted
+			}
+		}
+		return value, changed
+	case []interface{}:
+		changed := false
+		for i, sub := range value {
+			if redacted, ok := r.redactValue(sub); ok {
+				changed = true
+				value[i] = redacted
+			}
+		}
+		return value, changed
+	}
+	return v, false
+}
+
+// redactString - replace data found by detectors
+func (r *Redactor) redactString(s string) (string, bool) {
+	changed := false
+	for _, d := range r.detectors {
+		for _, regex := range d.regexes {
+			group := regex.SubexpIndex("value")
+			s = replaceAllSubmatch(regex, s, func(match []int) (int, int, bool) {
+				start, end := match[0], match[1]
+				if group > 0 && match[2*group] >= 0 {
+					start, end = match[2*group], match[2*group+1]
+				}
+				if start == end || (d.valid != nil && !d.valid(s[start:end])) {
+					return 0, 0, false
+				}
+				changed = true
+				return start, end, true
+			}, r.replacement)
+		}
+	}
+	return s, changed
+}
+
+// replaceAllSubmatch - replace part of each match selected by given function
+func replaceAllSubmatch(
+	regex *regexp.Regexp,
+	s string,
+	selectPart func(match []int) (start, end int, ok bool),
+	replacement func(string) string,
+) string {
+	var sb strings.Builder
+	last := 0
+	replaced := false
+	for _, match := range regex.FindAllStringSubmatchIndex(s, -1) {
+		start, end, ok := selectPart(match)
+		if !ok || start == end {
+			continue
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString(replacement(s[start:end]))
+		last = end
+		replaced = true
+	}
+	if !replaced {
+		return s
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// replacement - mask or hmac of data found, data is masked when field is dropped afterwards
+func (r *Redactor) replacement(found string) string {
+	if r.action != RedactionHash {
+		return redactedMask
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(found))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// luhn - digits of card number pass luhn checksum
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		n := int(c - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// SetPlanRedaction -
+// redact data of logs sent to given plan, hash key of plan is also used by redactions of users.
+// Logs of plan are refused when redaction is invalid for not leaking data operator wants to redact.
+func (p *Parser) SetPlanRedaction(planName string, redaction model.Redaction) error {
+	plan := planRedaction{hashKey: redaction.HashKey}
+	if !redaction.IsZero() {
+		plan.redactor, plan.err = NewRedactor(redaction, redaction.HashKey)
+	}
+	p.redactions[planName] = plan
+	return plan.err
+}
+
+// redact - apply redaction of plan then the one of user
+func (p Parser) redact(logData *model.LogMetadata, data map[string]interface{}) error {
+	plan := p.redactions[logData.InstanceParam.SyslogName]
+	if plan.err != nil {
+		return fmt.Errorf("redaction of plan: %s", plan.err.Error())
+	}
+	if plan.redactor != nil {
+		plan.redactor.Redact(data)
+	}
+	if logData.InstanceParam.Redaction == "" {
+		return nil
+	}
+	r, err := p.redactors.get(&logData.InstanceParam, plan.hashKey)
+	if err != nil {
+		return err
+	}
+	r.Redact(data)
+	return nil
+}
+
+// redactorsCache - compiled redactions of users by plan and stored json
+type redactorsCache struct {
+	mu        sync.RWMutex
+	redactors map[string]*Redactor
+}
+
+func (c *redactorsCache) get(param *model.InstanceParam, hashKey string) (*Redactor, error) {
+	key := param.SyslogName + "~" + param.Redaction
+	c.mu.RLock()
+	r, ok := c.redactors[key]
+	c.mu.RUnlock()
+	if ok {
+		return r, nil
+	}
+	redaction, err := param.GetRedaction()
+	if err != nil {
+		return nil, err
+	}
+	r, err = NewRedactor(redaction, hashKey)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.redactors[key] = r
+	c.mu.Unlock()
+	return r, nil
+}
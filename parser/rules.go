@@ -0,0 +1,541 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	RuleActionDrop   = "drop"
+	RuleActionKeep   = "keep"
+	RuleActionSample = "sample"
+	maxRules         = 50
+)
+
+// ErrDropped - log is dropped by filtering rules of instance
+var ErrDropped = errors.New("log dropped by filtering rules")
+
+// Rules - compiled filtering rules of an instance, evaluated in order
+type Rules []*Rule
+
+// Rule - compiled filtering rule, rate is the part of matching logs kept
+type Rule struct {
+	expr   ruleExpr
+	action string
+	rate   float64
+}
+
+// NewRules -
+// 1. parse action of each rule, sample rate must be in ]0, 1]
+// 2. compile expression of each rule
+func NewRules(rules []model.FilterRule) (Rules, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("too many rules, only %d are allowed", maxRules)
+	}
+	compiled := make(Rules, len(rules))
+	for i, r := range rules {
+		// 1.
+		rule := &Rule{rate: 1}
+		action := strings.ToLower(strings.ReplaceAll(r.Action, " ", ""))
+		switch {
+		case action == RuleActionDrop, action == RuleActionKeep:
+			rule.action = action
+		case strings.HasPrefix(action, RuleActionSample+"(") && strings.HasSuffix(action, ")"):
+			rate, err := strconv.ParseFloat(action[len(RuleActionSample)+1:len(action)-1], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("rule %d: sample rate must be a number between 0 and 1, got '%s'", i+1, r.Action)
+			}
+			rule.action = RuleActionSample
+			rule.rate = rate
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action '%s', only `%s`, `%s` or `%s(<rate>)` are allowed",
+				i+1, r.Action, RuleActionDrop, RuleActionKeep, RuleActionSample)
+		}
+
+		// 2.
+		expr, err := parseRuleExpr(r.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid expression '%s': %s", i+1, r.Expression, err.Error())
+		}
+		rule.expr = expr
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+// Keep - log is kept when first matching rule keeps it or when no rule matches
+func (rs Rules) Keep(data map[string]interface{}) bool {
+	for _, r := range rs {
+		if !truthy(r.expr.eval(data)) {
+			continue
+		}
+		switch r.action {
+		case RuleActionDrop:
+			return false
+		case RuleActionSample:
+			return rand.Float64() < r.rate
+		}
+		return true
+	}
+	return true
+}
+
+// rulesCache - compiled rules by their stored json, rules are shared by all logs of an instance revision
+type rulesCache struct {
+	mu    sync.RWMutex
+	rules map[string]Rules
+}
+
+func (c *rulesCache) get(param *model.InstanceParam) (Rules, error) {
+	c.mu.RLock()
+	compiled, ok := c.rules[param.Rules]
+	c.mu.RUnlock()
+	if ok {
+		return compiled, nil
+	}
+	rules, err := param.FilterRules()
+	if err != nil {
+		return nil, err
+	}
+	compiled, err = NewRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.rules[param.Rules] = compiled
+	c.mu.Unlock()
+	return compiled, nil
+}
+
+// ruleExpr - node of an expression evaluated on parsed fields
+type ruleExpr interface {
+	eval(data map[string]interface{}) interface{}
+}
+
+type fieldExpr string
+
+func (e fieldExpr) eval(data map[string]interface{}) interface{} {
+	return utils.FoundVarDelim(data, string(e))
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e literalExpr) eval(map[string]interface{}) interface{} {
+	return e.value
+}
+
+type listExpr []interface{}
+
+func (e listExpr) eval(map[string]interface{}) interface{} {
+	return []interface{}(e)
+}
+
+type notExpr struct {
+	expr ruleExpr
+}
+
+func (e notExpr) eval(data map[string]interface{}) interface{} {
+	return !truthy(e.expr.eval(data))
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right ruleExpr
+}
+
+func (e logicalExpr) eval(data map[string]interface{}) interface{} {
+	if truthy(e.left.eval(data)) != e.and {
+		return !e.and
+	}
+	return truthy(e.right.eval(data))
+}
+
+type compareExpr struct {
+	op          string
+	left, right ruleExpr
+	regex       *regexp.Regexp
+}
+
+func (e compareExpr) eval(data map[string]interface{}) interface{} {
+	left := e.left.eval(data)
+	switch e.op {
+	case "=~", "!~":
+		matched := left != nil && e.regex.MatchString(fmt.Sprint(left))
+		return matched == (e.op == "=~")
+	case "in", "not in":
+		found := false
+		for _, v := range e.right.eval(data).([]interface{}) {
+			if equals(left, v) {
+				found = true
+				break
+			}
+		}
+		return found == (e.op == "in")
+	case "==":
+		return equals(left, e.right.eval(data))
+	case "!=":
+		return !equals(left, e.right.eval(data))
+	}
+	l, lok := toFloat(left)
+	r, rok := toFloat(e.right.eval(data))
+	if !lok || !rok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	}
+	return l >= r
+}
+
+// equals - numbers are compared by value, other values by their string form, null only equals missing fields
+func equals(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// truthy - missing fields, false, zero and empty values don't match
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	case string:
+		return b != ""
+	case []interface{}:
+		return len(b) > 0
+	case map[string]interface{}:
+		return len(b) > 0
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// ruleParser -
+// recursive descent parser of expressions, grammar is:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" or ")" | comparison
+//	comparison = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand
+//	             | ( "=~" | "!~" ) string | [ "not" ] "in" list ]
+//	operand    = field | string | number | "true" | "false" | "null"
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+type ruleToken struct {
+	kind  rune // 'f' field, 's' string, 'n' number, 'o' operator or punctuation
+	value string
+}
+
+func parseRuleExpr(expression string) (ruleExpr, error) {
+	tokens, err := tokenizeRule(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	p := &ruleParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos].value)
+	}
+	return expr, nil
+}
+
+func (p *ruleParser) peek() ruleToken {
+	if p.pos >= len(p.tokens) {
+		return ruleToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) accept(kind rune, values ...string) (ruleToken, bool) {
+	t := p.peek()
+	if t.kind != kind {
+		return t, false
+	}
+	for _, v := range values {
+		if t.value == v {
+			p.pos++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *ruleParser) parseOr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept('o', "||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{left: left, right: right}
+	}
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept('o', "&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{and: true, left: left, right: right}
+	}
+}
+
+func (p *ruleParser) parseUnary() (ruleExpr, error) {
+	if _, ok := p.accept('o', "!"); ok {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	}
+	if _, ok := p.accept('o', "("); ok {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept('o', ")"); !ok {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (ruleExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.accept('o', "==", "!=", "<", "<=", ">", ">="); ok {
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr{op: t.value, left: left, right: right}, nil
+	}
+	if t, ok := p.accept('o', "=~", "!~"); ok {
+		pattern := p.peek()
+		if pattern.kind != 's' {
+			return nil, fmt.Errorf("'%s' requires a string pattern", t.value)
+		}
+		p.pos++
+		regex, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr{op: t.value, left: left, regex: regex}, nil
+	}
+	op := "in"
+	if _, ok := p.accept('f', "not"); ok {
+		op = "not in"
+		if _, ok := p.accept('f', "in"); !ok {
+			return nil, fmt.Errorf("'not' must be followed by 'in'")
+		}
+	} else if _, ok := p.accept('f', "in"); !ok {
+		return left, nil
+	}
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	return compareExpr{op: op, left: left, right: list}, nil
+}
+
+func (p *ruleParser) parseList() (ruleExpr, error) {
+	if _, ok := p.accept('o', "["); !ok {
+		return nil, fmt.Errorf("'in' requires a list")
+	}
+	list := make(listExpr, 0)
+	if _, ok := p.accept('o', "]"); ok {
+		return list, nil
+	}
+	for {
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := operand.(literalExpr)
+		if !ok {
+			return nil, fmt.Errorf("list only accepts strings, numbers, booleans or null")
+		}
+		list = append(list, literal.value)
+		if _, ok := p.accept('o', "]"); ok {
+			return list, nil
+		}
+		if _, ok := p.accept('o', ","); !ok {
+			return nil, fmt.Errorf("missing ']'")
+		}
+	}
+}
+
+func (p *ruleParser) parseOperand() (ruleExpr, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case 's':
+		return literalExpr{t.value}, nil
+	case 'n':
+		f, _ := strconv.ParseFloat(t.value, 64)
+		return literalExpr{f}, nil
+	case 'f':
+		switch t.value {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		case "null":
+			return literalExpr{nil}, nil
+		case "in", "not":
+			return nil, fmt.Errorf("unexpected '%s'", t.value)
+		}
+		return fieldExpr(t.value), nil
+	case 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected '%s'", t.value)
+}
+
+// tokenizeRule - split expression in fields, double quoted strings, numbers and operators
+func tokenizeRule(expression string) ([]ruleToken, error) {
+	tokens := make([]ruleToken, 0)
+	runes := []rune(expression)
+	isField := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_@.-", r)
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			s, end, err := unquoteRule(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, ruleToken{'s', s})
+			i = end
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			if _, err := strconv.ParseFloat(string(runes[i:j]), 64); err != nil {
+				return nil, fmt.Errorf("invalid number '%s'", string(runes[i:j]))
+			}
+			tokens = append(tokens, ruleToken{'n', string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '@':
+			j := i + 1
+			for j < len(runes) && isField(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, ruleToken{'f', string(runes[i:j])})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c'", r)
+			}
+			tokens = append(tokens, ruleToken{'o', op})
+			i += len([]rune(op))
+		}
+	}
+	return tokens, nil
+}
+
+// unquoteRule - string starting at given quote, other backslashes are kept as is for regex patterns
+func unquoteRule(runes []rune, start int) (string, int, error) {
+	var sb strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+			}
+			sb.WriteRune(runes[i])
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
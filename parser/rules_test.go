@@ -0,0 +1,89 @@
+package parser_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+)
+
+var _ = Describe("Rules", func() {
+	var data map[string]interface{}
+
+	keep := func(rules ...model.FilterRule) bool {
+		compiled, err := parser.NewRules(rules)
+		Expect(err).ToNot(HaveOccurred())
+		return compiled.Keep(data)
+	}
+
+	BeforeEach(func() {
+		data = map[string]interface{}{
+			"@level": "DEBUG",
+			"rtr": map[string]interface{}{
+				"path":   "/health?full=true",
+				"verb":   "GET",
+				"status": 200,
+			},
+		}
+	})
+
+	It("should drop logs matching comparisons", func() {
+		Expect(keep(model.FilterRule{Expression: `rtr.verb == "GET"`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `rtr.status >= 500`, Action: "drop"})).To(BeTrue())
+		Expect(keep(model.FilterRule{Expression: `rtr.status == "200"`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `@level in ["DEBUG", "TRACE"]`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `@level not in ["DEBUG"]`, Action: "drop"})).To(BeTrue())
+		Expect(keep(model.FilterRule{Expression: `rtr.path =~ "^/health(\\?|$)"`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `app.missing == null && !app.missing`, Action: "drop"})).To(BeFalse())
+	})
+
+	It("should evaluate fields at a missing list index as null", func() {
+		data["app"] = map[string]interface{}{"items": []interface{}{"x"}}
+		Expect(keep(model.FilterRule{Expression: `app.items.0 == "x"`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `app.items.3 == "x"`, Action: "drop"})).To(BeTrue())
+		Expect(keep(model.FilterRule{Expression: `app.items.-1 == "x"`, Action: "drop"})).To(BeTrue())
+		Expect(keep(model.FilterRule{Expression: `app.items.3 == null`, Action: "drop"})).To(BeFalse())
+	})
+
+	It("should combine expressions", func() {
+		Expect(keep(model.FilterRule{Expression: `rtr.verb == "POST" || (rtr && rtr.status < 300)`, Action: "drop"})).To(BeFalse())
+		Expect(keep(model.FilterRule{Expression: `rtr.verb == "POST" && rtr.status < 300`, Action: "drop"})).To(BeTrue())
+	})
+
+	It("should apply first matching rule", func() {
+		Expect(keep(
+			model.FilterRule{Expression: `rtr.path =~ "^/health"`, Action: "keep"},
+			model.FilterRule{Expression: `rtr`, Action: "drop"},
+		)).To(BeTrue())
+		Expect(keep(
+			model.FilterRule{Expression: `@level == "ERROR"`, Action: "keep"},
+			model.FilterRule{Expression: `rtr`, Action: "drop"},
+		)).To(BeFalse())
+	})
+
+	It("should sample matching logs", func() {
+		rules, err := parser.NewRules([]model.FilterRule{{Expression: `rtr`, Action: "sample(0.5)"}})
+		Expect(err).ToNot(HaveOccurred())
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			if rules.Keep(data) {
+				kept++
+			}
+		}
+		Expect(kept).To(BeNumerically("~", 500, 100))
+	})
+
+	It("should refuse invalid rules", func() {
+		_, err := parser.NewRules([]model.FilterRule{{Expression: `rtr`, Action: "delete"}})
+		Expect(err).To(MatchError(ContainSubstring("rule 1: unknown action 'delete'")))
+		_, err = parser.NewRules([]model.FilterRule{{Expression: `rtr`, Action: "sample(2)"}})
+		Expect(err).To(MatchError(ContainSubstring("sample rate must be a number between 0 and 1")))
+		_, err = parser.NewRules([]model.FilterRule{{Expression: `rtr.path == `, Action: "drop"}})
+		Expect(err).To(MatchError(ContainSubstring("unexpected end of expression")))
+		_, err = parser.NewRules([]model.FilterRule{{Expression: `@level in "DEBUG"`, Action: "drop"}})
+		Expect(err).To(MatchError(ContainSubstring("'in' requires a list")))
+		_, err = parser.NewRules([]model.FilterRule{{Expression: `(rtr`, Action: "drop"}})
+		Expect(err).To(MatchError(ContainSubstring("missing ')'")))
+	})
+})
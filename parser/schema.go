@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	SchemaNative = "native"
+	SchemaECS    = "ecs"
+	SchemaOTel   = "otel"
+)
+
+// Schema - rename fields built by filters for consumers expecting a standard schema
+type Schema interface {
+	Map(data map[string]interface{}) map[string]interface{}
+}
+
+var schemas = map[string]Schema{
+	SchemaECS:  ECSSchema{},
+	SchemaOTel: OTelSchema{},
+}
+
+// GetSchema - schema of given name, native schema is given as nil as fields are kept as built by filters
+func GetSchema(name string) (Schema, error) {
+	if name == "" || name == SchemaNative {
+		return nil, nil
+	}
+	schema, ok := schemas[name]
+	if !ok {
+		names := []string{"`" + SchemaNative + "`"}
+		for n := range schemas {
+			names = append(names, "`"+n+"`")
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown schema '%s', only %s are allowed", name, strings.Join(names, ", "))
+	}
+	return schema, nil
+}
+
+// SetPlanSchema - map fields of logs sent to given plan on schema, native schema removes mapping
+func (p *Parser) SetPlanSchema(planName, name string) error {
+	schema, err := GetSchema(name)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		delete(p.schemas, planName)
+		return nil
+	}
+	p.schemas[planName] = schema
+	return nil
+}
+
+// setField - set value at dotted path, intermediate values which are not maps are replaced
+func setField(data map[string]interface{}, path string, value interface{}) {
+	if value == nil || value == "" {
+		return
+	}
+	keys := strings.Split(path, ".")
+	current := data
+	for _, key := range keys[:len(keys)-1] {
+		sub, ok := current[key].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			current[key] = sub
+		}
+		current = sub
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+// fieldString - string value found at dotted path, empty when missing
+func fieldString(data map[string]interface{}, path string) string {
+	v := utils.FoundVarDelim(data, path)
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// traceContext -
+// trace and span ids of request from w3c `traceparent` or zipkin `b3` inline params set by gorouter,
+// `x_b3_traceid` and `x_b3_spanid` params are used when others are not present
+func traceContext(data map[string]interface{}) (traceID, spanID string) {
+	if parts := strings.Split(fieldString(data, "traceparent"), "-"); len(parts) == 4 && isTraceID(parts[1]) {
+		return parts[1], parts[2]
+	}
+	if parts := strings.Split(fieldString(data, "b3"), "-"); len(parts) >= 2 && isTraceID(parts[0]) {
+		return parts[0], parts[1]
+	}
+	traceID = fieldString(data, "x_b3_traceid")
+	if !isTraceID(traceID) {
+		return "", ""
+	}
+	spanID = fieldString(data, "x_b3_spanid")
+	if spanID == "-" {
+		spanID = ""
+	}
+	return traceID, spanID
+}
+
+// isTraceID - 64 or 128 bits hex id which is not only made of zeros
+func isTraceID(id string) bool {
+	if len(id) != 16 && len(id) != 32 {
+		return false
+	}
+	if strings.Trim(id, "0") == "" {
+		return false
+	}
+	return strings.Trim(strings.ToLower(id), "0123456789abcdef") == ""
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"strings"
+)
+
+// ECSVersion - version of elastic common schema followed by mapping
+const ECSVersion = "8.11.0"
+
+// ecsCFFields - `@cf` fields with their ecs fields
+var ecsCFFields = map[string][]string{
+	"org":          {"cloud.account.name", "cloudfoundry.org.name"},
+	"org_id":       {"cloud.account.id", "cloudfoundry.org.id"},
+	"space":        {"cloud.project.name", "cloudfoundry.space.name"},
+	"space_id":     {"cloud.project.id", "cloudfoundry.space.id"},
+	"app":          {"service.name", "cloudfoundry.app.name"},
+	"app_id":       {"service.id", "cloudfoundry.app.id"},
+	"app_instance": {"cloudfoundry.log.source.instance"},
+	"task_id":      {"cloudfoundry.task.id"},
+	"task_name":    {"cloudfoundry.task.name"},
+}
+
+// ecsRtrFields - `rtr` fields directly moved to an ecs field, others are kept in `cloudfoundry.rtr`
+var ecsRtrFields = map[string]string{
+	"hostname":               "url.domain",
+	"verb":                   "http.request.method",
+	"status":                 "http.response.status_code",
+	"request_bytes_received": "http.request.body.bytes",
+	"body_bytes_sent":        "http.response.body.bytes",
+	"http_user_agent":        "user_agent.original",
+	"x_forwarded_proto":      "url.scheme",
+	"remote_addr":            "source.ip",
+}
+
+// ECSSchema -
+// map fields on elastic common schema, cloud foundry metadata are set in `cloud`, `service`
+// and `cloudfoundry` fields as done by elastic cloud foundry integration.
+// Fields unknown to mapping are kept as is.
+type ECSSchema struct{}
+
+// Map -
+// 1. move fields set by default filter
+// 2. move `@cf` metadata
+// 3. move router access log fields, path is split in url path and query
+// 4. set trace context from gorouter inline params
+// 5. keep other fields
+func (ECSSchema) Map(data map[string]interface{}) map[string]interface{} {
+	mapped := make(map[string]interface{})
+	moved := make(map[string]bool)
+	move := func(from, to string) {
+		moved[from] = true
+		setField(mapped, to, data[from])
+	}
+
+	// 1.
+	setField(mapped, "ecs.version", ECSVersion)
+	move("@timestamp", "@timestamp")
+	move("@received_at", "event.created")
+	move(MessageKey, "message")
+	move("@level", "log.level")
+	move("@request_id", "http.request.id")
+	move("@input", "input.type")
+	move("@type", "cloudfoundry.type")
+	move("@metric", "cloudfoundry.metric")
+	move("@exception", "error.message")
+	if _, ok := mapped["error"]; !ok {
+		move("@exception_tag", "error.message")
+	}
+	moved["@exception_tag"] = true
+	if shipper, ok := data["@shipper"].(map[string]interface{}); ok {
+		moved["@shipper"] = true
+		setField(mapped, "agent.name", shipper["name"])
+		setField(mapped, "log.syslog.priority", shipper["priority"])
+	}
+	if source, ok := data["@source"].(map[string]interface{}); ok {
+		moved["@source"] = true
+		for k, v := range source {
+			switch k {
+			case "type", "details":
+				setField(mapped, "cloudfoundry.log.source."+k, v)
+			default:
+				setField(mapped, "labels."+k, v)
+			}
+		}
+	}
+
+	// 2.
+	if cf, ok := data["@cf"].(map[string]interface{}); ok {
+		moved["@cf"] = true
+		setField(mapped, "cloud.provider", "cloudfoundry")
+		for k, v := range cf {
+			for _, path := range ecsCFFields[k] {
+				setField(mapped, path, v)
+			}
+		}
+	}
+
+	// 3.
+	if rtr, ok := data["rtr"].(map[string]interface{}); ok {
+		moved["rtr"] = true
+		rest := make(map[string]interface{})
+		for k, v := range rtr {
+			if path, ok := ecsRtrFields[k]; ok {
+				setField(mapped, path, v)
+				continue
+			}
+			switch k {
+			case "path":
+				original, _ := v.(string)
+				urlPath, query, _ := strings.Cut(original, "?")
+				setField(mapped, "url.original", original)
+				setField(mapped, "url.path", urlPath)
+				setField(mapped, "url.query", query)
+			case "http_spec":
+				spec, _ := v.(string)
+				setField(mapped, "http.version", strings.TrimPrefix(spec, "HTTP/"))
+			case "referer":
+				if referer, _ := v.(string); referer != "-" {
+					setField(mapped, "http.request.referrer", referer)
+				}
+			case "src":
+				src, _ := v.(map[string]interface{})
+				setField(mapped, "client.ip", src["host"])
+				setField(mapped, "client.port", src["port"])
+			case "dst":
+				dst, _ := v.(map[string]interface{})
+				setField(mapped, "destination.ip", dst["host"])
+				setField(mapped, "destination.port", dst["port"])
+			case "response_time_sec":
+				if sec, ok := v.(float64); ok {
+					setField(mapped, "event.duration", int64(sec*1e9))
+				}
+				rest[k] = v
+			default:
+				rest[k] = v
+			}
+		}
+		setField(mapped, "source.address", fieldString(mapped, "source.ip"))
+		if len(rest) > 0 {
+			setField(mapped, "cloudfoundry.rtr", rest)
+		}
+	}
+
+	// 4.
+	traceID, spanID := traceContext(data)
+	setField(mapped, "trace.id", traceID)
+	setField(mapped, "span.id", spanID)
+
+	// 5.
+	for k, v := range data {
+		if moved[k] {
+			continue
+		}
+		if _, ok := mapped[k]; ok {
+			continue
+		}
+		mapped[k] = v
+	}
+	return mapped
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"strings"
+)
+
+// otelRtrAttributes - `rtr` fields directly moved to an otel http semantic conventions attribute
+var otelRtrAttributes = map[string]string{
+	"hostname":               "server.address",
+	"verb":                   "http.request.method",
+	"status":                 "http.response.status_code",
+	"request_bytes_received": "http.request.body.size",
+	"body_bytes_sent":        "http.response.body.size",
+	"http_user_agent":        "user_agent.original",
+	"x_forwarded_proto":      "url.scheme",
+	"remote_addr":            "client.address",
+}
+
+// OTelSchema -
+// map router access log fields on opentelemetry http semantic conventions, attributes are given
+// as flat dotted keys as done in otel. Trace context of request is set in `trace_id` and `span_id`.
+// Other fields are kept as is.
+type OTelSchema struct{}
+
+// Map -
+// 1. move router fields to attributes, path is split in url path and query
+// 2. set trace context from gorouter inline params, 64 bits trace ids are padded to 128 bits
+func (OTelSchema) Map(data map[string]interface{}) map[string]interface{} {
+	// 1.
+	if rtr, ok := data["rtr"].(map[string]interface{}); ok {
+		for k, v := range rtr {
+			if attr, ok := otelRtrAttributes[k]; ok {
+				setAttribute(data, attr, v)
+				delete(rtr, k)
+				continue
+			}
+			switch k {
+			case "path":
+				original, _ := v.(string)
+				urlPath, query, _ := strings.Cut(original, "?")
+				setAttribute(data, "url.path", urlPath)
+				setAttribute(data, "url.query", query)
+			case "http_spec":
+				spec, _ := v.(string)
+				name, version, _ := strings.Cut(spec, "/")
+				setAttribute(data, "network.protocol.name", strings.ToLower(name))
+				setAttribute(data, "network.protocol.version", version)
+			case "src":
+				src, _ := v.(map[string]interface{})
+				setAttribute(data, "network.peer.address", src["host"])
+				setAttribute(data, "network.peer.port", src["port"])
+			default:
+				continue
+			}
+			delete(rtr, k)
+		}
+		if len(rtr) == 0 {
+			delete(data, "rtr")
+		}
+	}
+
+	// 2.
+	traceID, spanID := traceContext(data)
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	setAttribute(data, "trace_id", strings.ToLower(traceID))
+	setAttribute(data, "span_id", strings.ToLower(spanID))
+	return data
+}
+
+// setAttribute - set value at flat key, empty values are ignored
+func setAttribute(data map[string]interface{}, key string, value interface{}) {
+	if value == nil || value == "" {
+		return
+	}
+	data[key] = value
+}
@@ -0,0 +1,363 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	TimestampAuto    = "auto"
+	TimestampRFC3339 = "rfc3339"
+	TimestampEpochS  = "epoch_s"
+	TimestampEpochMs = "epoch_ms"
+	TimestampEpochNs = "epoch_ns"
+
+	TimestampLayoutGo       = "go:"
+	TimestampLayoutJava     = "java:"
+	TimestampLayoutStrftime = "strftime:"
+
+	timestampKey = "@timestamp"
+	// receivedAtKey - field keeping time given by syslog header when timestamp is extracted
+	receivedAtKey = "@received_at"
+	// timestampExceptionKey - field describing why timestamp could not be extracted
+	timestampExceptionKey = "@exception_timestamp"
+)
+
+// DefaultTimestampKeys - keys where timestamp is searched when none are given, json logs of apps are under `app`
+var DefaultTimestampKeys = []string{"app.timestamp", "app.time", "app.ts", "time", "ts"}
+
+// autoLayouts - layouts tried on strings in auto format, layouts without zone are parsed as utc
+var autoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RubyDate,
+	time.UnixDate,
+	time.ANSIC,
+	"02/Jan/2006:15:04:05 -0700",
+	time.DateOnly,
+}
+
+// TimestampExtractor - checked timestamp extraction
+type TimestampExtractor struct {
+	keys   []string
+	format string
+	layout string
+}
+
+// NewTimestampExtractor -
+// 1. use default keys when none are given
+// 2. check format, java and strftime patterns are converted to go layouts
+func NewTimestampExtractor(extraction model.TimestampExtraction) (*TimestampExtractor, error) {
+	// 1.
+	e := &TimestampExtractor{
+		keys:   extraction.Keys,
+		format: strings.ToLower(extraction.GetFormat()),
+	}
+	if len(e.keys) == 0 {
+		e.keys = DefaultTimestampKeys
+	}
+
+	// 2.
+	var err error
+	format := extraction.GetFormat()
+	switch {
+	case strings.HasPrefix(format, TimestampLayoutGo):
+		e.format, e.layout = TimestampLayoutGo, strings.TrimPrefix(format, TimestampLayoutGo)
+		if e.layout == "" {
+			err = fmt.Errorf("layout is empty")
+		}
+	case strings.HasPrefix(format, TimestampLayoutJava):
+		e.format = TimestampLayoutJava
+		e.layout, err = javaLayout(strings.TrimPrefix(format, TimestampLayoutJava))
+	case strings.HasPrefix(format, TimestampLayoutStrftime):
+		e.format = TimestampLayoutStrftime
+		e.layout, err = strftimeLayout(strings.TrimPrefix(format, TimestampLayoutStrftime))
+	default:
+		switch e.format {
+		case TimestampAuto, TimestampRFC3339, TimestampEpochS, TimestampEpochMs, TimestampEpochNs:
+		default:
+			err = fmt.Errorf("only `%s`, `%s`, `%s`, `%s`, `%s` or a layout prefixed by `%s`, `%s` or `%s` are allowed",
+				TimestampAuto, TimestampRFC3339, TimestampEpochS, TimestampEpochMs, TimestampEpochNs,
+				TimestampLayoutGo, TimestampLayoutJava, TimestampLayoutStrftime)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp format '%s': %s", format, err.Error())
+	}
+	return e, nil
+}
+
+// Extract -
+// 1. find first key set in data
+// 2. parse its value, failure is described in `@exception_timestamp` and time of syslog header is kept
+// 3. time of syslog header is kept in `@received_at` and replaced by parsed time
+func (e *TimestampExtractor) Extract(data map[string]interface{}) (time.Time, bool) {
+	// 1.
+	for _, key := range e.keys {
+		v, ok := getField(data, key)
+		if !ok || v == nil || v == "" {
+			continue
+		}
+
+		// 2.
+		t, err := e.parse(v)
+		if err != nil {
+			data[timestampExceptionKey] = fmt.Sprintf("cannot parse '%v' of '%s' as %s timestamp: %s", v, key, e.format, err.Error())
+			return time.Time{}, false
+		}
+
+		// 3.
+		if received, ok := data[timestampKey]; ok {
+			data[receivedAtKey] = received
+		}
+		data[timestampKey] = t
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func (e *TimestampExtractor) parse(v interface{}) (time.Time, error) {
+	switch e.format {
+	case TimestampEpochS:
+		return epoch(v, 1e9)
+	case TimestampEpochMs:
+		return epoch(v, 1e6)
+	case TimestampEpochNs:
+		return epoch(v, 1)
+	case TimestampRFC3339:
+		return time.Parse(time.RFC3339Nano, cast.ToString(v))
+	case TimestampLayoutGo, TimestampLayoutJava, TimestampLayoutStrftime:
+		return time.Parse(e.layout, strings.TrimSpace(cast.ToString(v)))
+	}
+	return parseTimestamp(v)
+}
+
+// parseTimestamp -
+// time of value in auto format, numbers are epoch whose unit is guessed by magnitude
+// (seconds, milliseconds, microseconds or nanoseconds), strings are tried on rfc 3339 and common layouts
+func parseTimestamp(v interface{}) (time.Time, error) {
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+	s, isString := v.(string)
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(s, 64); !isString || err == nil {
+		f, err := cast.ToFloat64E(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch f = math.Abs(f); {
+		case f < 1e11:
+			return epoch(v, 1e9)
+		case f < 1e14:
+			return epoch(v, 1e6)
+		case f < 1e17:
+			return epoch(v, 1e3)
+		}
+		return epoch(v, 1)
+	}
+	for _, layout := range autoLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown layout")
+}
+
+// epoch - time of number or numeric string given in unit of nanoseconds, integers and json whole numbers are kept exact
+func epoch(v interface{}, unit int64) (time.Time, error) {
+	if s, ok := v.(string); ok {
+		v = strings.TrimSpace(s)
+		if i, err := strconv.ParseInt(v.(string), 10, 64); err == nil {
+			v = i
+		}
+	}
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < math.MaxInt64 {
+		v = int64(f)
+	}
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		i, err := cast.ToInt64E(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch unit {
+		case 1e9:
+			return time.Unix(i, 0).UTC(), nil
+		case 1e6:
+			return time.UnixMilli(i).UTC(), nil
+		case 1e3:
+			return time.UnixMicro(i).UTC(), nil
+		}
+		return time.Unix(0, i).UTC(), nil
+	}
+	f, err := cast.ToFloat64E(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+// javaLayoutTokens - java date time pattern letters repeated n times by go layout
+var javaLayoutTokens = map[string]string{
+	"yyyy": "2006", "uuuu": "2006", "yy": "06", "uu": "06",
+	"MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+	"dd": "02", "d": "2", "DDD": "002",
+	"HH": "15", "H": "15", "hh": "03", "h": "3",
+	"mm": "04", "m": "4", "ss": "05", "s": "5",
+	"a": "PM", "EEEE": "Monday", "EEE": "Mon", "EE": "Mon", "E": "Mon",
+	"Z": "-0700", "ZZ": "-0700", "ZZZ": "-0700", "ZZZZZ": "Z07:00",
+	"X": "Z07", "XX": "Z0700", "XXX": "Z07:00",
+	"x": "-07", "xx": "-0700", "xxx": "-07:00",
+	"z": "MST", "zz": "MST", "zzz": "MST",
+}
+
+// javaLayout - go layout of java date time pattern, literals are quoted and fractions of second follow a dot or a comma
+func javaLayout(pattern string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		if c == '\'' {
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote")
+			}
+			if end == 0 {
+				sb.WriteByte('\'')
+			}
+			sb.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		n := 1
+		for i+n < len(pattern) && pattern[i+n] == c {
+			n++
+		}
+		token := pattern[i : i+n]
+		i += n
+		if c == 'S' {
+			if sb.Len() == 0 || !strings.ContainsAny(sb.String()[sb.Len()-1:], ".,") {
+				return "", fmt.Errorf("fraction of second '%s' must follow a dot or a comma", token)
+			}
+			sb.WriteString(strings.Repeat("0", n))
+			continue
+		}
+		layout, ok := javaLayoutTokens[token]
+		if !ok {
+			return "", fmt.Errorf("unsupported pattern '%s'", token)
+		}
+		sb.WriteString(layout)
+	}
+	return sb.String(), nil
+}
+
+// strftimeLayoutTokens - go layout of strftime directives
+var strftimeLayoutTokens = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'L': "000", 'f': "000000",
+	'T': "15:04:05", 'F': "2006-01-02", '%': "%",
+}
+
+// strftimeLayout - go layout of strftime format, fractions of second `%L` and `%f` follow a dot or a comma
+func strftimeLayout(format string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("directive is missing after '%%'")
+		}
+		i++
+		layout, ok := strftimeLayoutTokens[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported directive '%%%c'", format[i])
+		}
+		if (format[i] == 'L' || format[i] == 'f') && (sb.Len() == 0 || !strings.ContainsAny(sb.String()[sb.Len()-1:], ".,")) {
+			return "", fmt.Errorf("fraction of second '%%%c' must follow a dot or a comma", format[i])
+		}
+		sb.WriteString(layout)
+	}
+	return sb.String(), nil
+}
+
+// SetPlanTimestamp - extract timestamp of logs sent to given plan
+func (p *Parser) SetPlanTimestamp(planName string, extraction model.TimestampExtraction) error {
+	if extraction.IsZero() {
+		delete(p.timestamps, planName)
+		return nil
+	}
+	e, err := NewTimestampExtractor(extraction)
+	if err != nil {
+		return err
+	}
+	p.timestamps[planName] = e
+	return nil
+}
+
+// extractTimestamp - extract timestamp with extraction of user or, when user has none, the one of plan
+func (p Parser) extractTimestamp(logData *model.LogMetadata, data map[string]interface{}) (time.Time, bool, error) {
+	e := p.timestamps[logData.InstanceParam.SyslogName]
+	if logData.InstanceParam.TimestampExtraction != "" {
+		var err error
+		e, err = p.userTimestamps.get(&logData.InstanceParam)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	if e == nil {
+		return time.Time{}, false, nil
+	}
+	t, ok := e.Extract(data)
+	return t, ok, nil
+}
+
+// timestampsCache - checked timestamp extractions of users by their stored json
+type timestampsCache struct {
+	mu         sync.RWMutex
+	extractors map[string]*TimestampExtractor
+}
+
+func (c *timestampsCache) get(param *model.InstanceParam) (*TimestampExtractor, error) {
+	c.mu.RLock()
+	e, ok := c.extractors[param.TimestampExtraction]
+	c.mu.RUnlock()
+	if ok {
+		return e, nil
+	}
+	extraction, err := param.GetTimestampExtraction()
+	if err != nil {
+		return nil, err
+	}
+	e, err = NewTimestampExtractor(extraction)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.extractors[param.TimestampExtraction] = e
+	c.mu.Unlock()
+	return e, nil
+}
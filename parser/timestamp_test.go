@@ -0,0 +1,111 @@
+package parser_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+)
+
+var _ = Describe("TimestampExtractor", func() {
+	received := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := time.Date(2006, 1, 2, 15, 4, 5, 123000000, time.UTC)
+
+	extract := func(extraction model.TimestampExtraction, data map[string]interface{}) map[string]interface{} {
+		e, err := parser.NewTimestampExtractor(extraction)
+		Expect(err).ToNot(HaveOccurred())
+		data["@timestamp"] = received
+		e.Extract(data)
+		return data
+	}
+
+	It("should guess format of default keys and keep time of syslog header", func() {
+		for _, v := range []interface{}{
+			"2006-01-02T15:04:05.123Z",
+			"2006-01-02T17:04:05.123+02:00",
+			"2006-01-02 15:04:05,123",
+			1136214245.123,
+			"1136214245123",
+			float64(1136214245123),
+			"1136214245123000000",
+		} {
+			data := extract(model.TimestampExtraction{}, map[string]interface{}{"app": map[string]interface{}{"time": v}})
+			Expect(data["@timestamp"]).To(BeTemporally("~", expected, time.Millisecond), fmt.Sprint(v))
+			Expect(data).To(HaveKeyWithValue("@received_at", received))
+		}
+	})
+
+	It("should parse epoch in given unit", func() {
+		data := extract(model.TimestampExtraction{Keys: []string{"ts"}, Format: "epoch_s"}, map[string]interface{}{"ts": "1136214245"})
+		Expect(data).To(HaveKeyWithValue("@timestamp", expected.Truncate(time.Second)))
+		data = extract(model.TimestampExtraction{Keys: []string{"ts"}, Format: "epoch_ms"}, map[string]interface{}{"ts": float64(1136214245123)})
+		Expect(data).To(HaveKeyWithValue("@timestamp", expected))
+		data = extract(model.TimestampExtraction{Keys: []string{"ts"}, Format: "epoch_ns"}, map[string]interface{}{"ts": "1136214245123000000"})
+		Expect(data).To(HaveKeyWithValue("@timestamp", expected))
+	})
+
+	It("should parse go, java and strftime layouts", func() {
+		for format, v := range map[string]string{
+			"go:02/01/2006 15:04:05.000":         "02/01/2006 15:04:05.123",
+			"java:dd/MM/yyyy'T'HH:mm:ss,SSS XXX": "02/01/2006T17:04:05,123 +02:00",
+			"java:EEE MMM d HH:mm:ss.SSS yyyy":   "Mon Jan 2 15:04:05.123 2006",
+			"strftime:%d/%b/%Y:%H:%M:%S.%L %z":   "02/Jan/2006:17:04:05.123 +0200",
+			"strftime:%F %T.%f":                  "2006-01-02 15:04:05.123000",
+		} {
+			data := extract(model.TimestampExtraction{Keys: []string{"app.date"}, Format: format},
+				map[string]interface{}{"app": map[string]interface{}{"date": v}})
+			Expect(data["@timestamp"]).To(BeTemporally("==", expected), format)
+		}
+	})
+
+	It("should use first key found and describe failures", func() {
+		data := extract(model.TimestampExtraction{Keys: []string{"missing", "app.date", "time"}, Format: "rfc3339"},
+			map[string]interface{}{"app": map[string]interface{}{"date": "yesterday"}, "time": "2006-01-02T15:04:05Z"})
+		Expect(data).To(HaveKeyWithValue("@timestamp", received))
+		Expect(data).ToNot(HaveKey("@received_at"))
+		Expect(data).To(HaveKeyWithValue("@exception_timestamp", ContainSubstring("cannot parse 'yesterday' of 'app.date' as rfc3339 timestamp")))
+	})
+
+	It("should refuse invalid formats", func() {
+		_, err := parser.NewTimestampExtractor(model.TimestampExtraction{Format: "iso"})
+		Expect(err).To(MatchError(ContainSubstring("invalid timestamp format 'iso'")))
+		_, err = parser.NewTimestampExtractor(model.TimestampExtraction{Format: "java:yyyy-MM-dd QQ"})
+		Expect(err).To(MatchError(ContainSubstring("unsupported pattern 'QQ'")))
+		_, err = parser.NewTimestampExtractor(model.TimestampExtraction{Format: "java:ssSSS"})
+		Expect(err).To(MatchError(ContainSubstring("must follow a dot or a comma")))
+		_, err = parser.NewTimestampExtractor(model.TimestampExtraction{Format: "strftime:%Y %Q"})
+		Expect(err).To(MatchError(ContainSubstring("unsupported directive '%Q'")))
+	})
+
+	It("should replace header timestamp with the one of app when parsing", func() {
+		gParser := parser.NewParser(nil, true)
+		Expect(gParser.SetPlanTimestamp("loghost", model.TimestampExtraction{Format: "epoch_s"})).To(Succeed())
+		metadata := getMetadata("org-id", "space-id", "app-id")
+		header := time.Now().UTC().Truncate(time.Second)
+		appMsg := fmt.Sprintf(`<14>1 %s org.space.app - [APP/PROC/WEB/0] - - {"msg": "hello", "ts": "2006-01-02T15:04:05.123Z"}`,
+			header.Format(time.RFC3339))
+		parse := func() (*time.Time, map[string]interface{}) {
+			parsed, err := gParser.Parse(metadata, []byte(appMsg), nil)
+			Expect(err).ToNot(HaveOccurred())
+			jsonLog := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(*parsed.Message), &jsonLog)).To(Succeed())
+			return parsed.Timestamp, jsonLog
+		}
+
+		ts, jsonLog := parse()
+		Expect(*ts).To(BeTemporally("==", header))
+		Expect(jsonLog).To(HaveKeyWithValue("@exception_timestamp", ContainSubstring("of 'app.ts' as epoch_s")))
+
+		metadata.InstanceParam.SetTimestampExtraction(model.TimestampExtraction{Keys: []string{"app.ts"}})
+		ts, jsonLog = parse()
+		Expect(*ts).To(BeTemporally("==", expected))
+		Expect(jsonLog).To(HaveKeyWithValue("@timestamp", "2006-01-02T15:04:05.123Z"))
+		Expect(jsonLog).To(HaveKeyWithValue("@received_at", header.Format(time.RFC3339)))
+		Expect(jsonLog).ToNot(HaveKey("@exception_timestamp"))
+	})
+})
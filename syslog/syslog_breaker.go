@@ -0,0 +1,148 @@
+package syslog
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+)
+
+const (
+	QueryBreakerThreshold  = "breaker_threshold"
+	QueryBreakerMinBackoff = "breaker_min_backoff"
+	QueryBreakerMaxBackoff = "breaker_max_backoff"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState - state of a circuit breaker, value is the one exposed in metrics
+type BreakerState int
+
+const (
+	// BreakerClosed - destination is healthy, all attempts go through
+	BreakerClosed BreakerState = 0
+	// BreakerHalfOpen - backoff has elapsed, a single attempt is let through to probe destination
+	BreakerHalfOpen BreakerState = 1
+	// BreakerOpen - destination is considered down, attempts fail fast until backoff has elapsed
+	BreakerOpen BreakerState = 2
+)
+
+// circuitBreaker -
+// opens after threshold consecutive failures, then lets a single probe pass once
+// a jittered exponential backoff has elapsed. Probe success closes it, failure
+// opens it again with a doubled backoff.
+type circuitBreaker struct {
+	label      string
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu        sync.Mutex // guards fields below
+	state     BreakerState
+	failures  int
+	openings  int
+	openUntil time.Time
+}
+
+// newCircuitBreakerFromAddr - thresholds are read from url params, defaults are 5 failures, 1s and 1m backoff
+func newCircuitBreakerFromAddr(u *url.URL) (*circuitBreaker, error) {
+	b := &circuitBreaker{
+		label:      endpointLabel(u.String()),
+		threshold:  5,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+	}
+	var err error
+	if raw := u.Query().Get(QueryBreakerThreshold); raw != "" {
+		b.threshold, err = strconv.Atoi(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw := u.Query().Get(QueryBreakerMinBackoff); raw != "" {
+		b.minBackoff, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw := u.Query().Get(QueryBreakerMaxBackoff); raw != "" {
+		b.maxBackoff, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.threshold = max(b.threshold, 1)
+	b.maxBackoff = max(b.maxBackoff, b.minBackoff)
+	metrics.CircuitBreakerState.WithLabelValues(b.label).Set(float64(BreakerClosed))
+	return b, nil
+}
+
+// allow - error when attempt must fail fast
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		return ErrCircuitOpen
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	return nil
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openings = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerClosed && b.failures < b.threshold {
+		return
+	}
+	if b.state == BreakerOpen {
+		return
+	}
+	b.openUntil = time.Now().Add(b.backoff())
+	b.openings++
+	b.setState(BreakerOpen)
+}
+
+// backoff - exponential backoff capped to max, with equal jitter to avoid reconnection storms
+func (b *circuitBreaker) backoff() time.Duration {
+	d := b.maxBackoff
+	if b.openings < 32 {
+		d = min(b.minBackoff<<b.openings, b.maxBackoff)
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	metrics.CircuitBreakerState.WithLabelValues(b.label).Set(float64(state))
+}
+
+// State - current state of circuit breaker
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,78 @@
+package syslog_test
+
+import (
+	"errors"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// listenAndDrop - accept a single connection and close it right away along with listener
+func listenAndDrop(addr string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	Expect(err).ToNot(HaveOccurred())
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		utils.CloseAndLogError(conn)
+		utils.CloseAndLogError(l)
+	}()
+	return l
+}
+
+var _ = Describe("SyslogBreaker", func() {
+	isCircuitOpen := func(err error) bool {
+		return errors.Is(err, syslog.ErrCircuitOpen)
+	}
+
+	It("should open circuit breaker when server is down and fail fast", func() {
+		l := listenAndDrop("127.0.0.1:0")
+		w, err := syslog.Dial("tcp://" + l.Addr().String() + "?breaker_threshold=2&breaker_min_backoff=1h")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		Eventually(func() error {
+			_, err := w.Write([]byte("my content"))
+			return err
+		}).Should(Satisfy(isCircuitOpen))
+		Expect(w.BreakerState()).To(Equal(syslog.BreakerOpen))
+	})
+
+	It("should close circuit breaker when server is back after backoff", func() {
+		l := listenAndDrop("127.0.0.1:0")
+		addr := l.Addr().String()
+		w, err := syslog.Dial("tcp://" + addr + "?breaker_threshold=1&breaker_min_backoff=10ms&breaker_max_backoff=20ms")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		Eventually(func() error {
+			_, err := w.Write([]byte("my content"))
+			return err
+		}).Should(Satisfy(isCircuitOpen))
+
+		server, err := net.Listen("tcp", addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(server)
+		go func() {
+			for {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				defer utils.CloseAndLogError(conn)
+			}
+		}()
+
+		Eventually(func() error {
+			_, err := w.Write([]byte("my content"))
+			return err
+		}).ShouldNot(HaveOccurred())
+		Expect(w.BreakerState()).To(Equal(syslog.BreakerClosed))
+	})
+})
@@ -0,0 +1,167 @@
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	QueryIndex          = "index"
+	QueryAPIKey         = "api_key"
+	QueryOpType         = "op_type"
+	ElasticOpIndex      = "index"
+	ElasticOpCreate     = "create"
+	elasticBulkPath     = "_bulk"
+	elasticDefaultIndex = "logs-YYYY.MM.DD"
+)
+
+// elasticEncoder - build bulk requests from parsed data of messages
+type elasticEncoder struct {
+	label  string
+	index  string
+	opType string
+	output Encoder
+}
+
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// ElasticDial -
+// 1. convert elasticsearch url to http(s) one targeting bulk api
+// 2. create http writer sending parsed data of messages as documents, http options of syslog address apply
+func ElasticDial(addr string, sysAddr *model.SyslogAddress) (*HttpWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	toHTTPScheme(u)
+	if !strings.HasSuffix(u.Path, "/"+elasticBulkPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + elasticBulkPath
+	}
+	config := sysAddr.Elasticsearch
+	query := u.Query()
+	enc := &elasticEncoder{
+		index:  valueOr(query.Get(QueryIndex), valueOr(config.Index, elasticDefaultIndex)),
+		opType: valueOr(query.Get(QueryOpType), valueOr(config.OpType, ElasticOpIndex)),
+	}
+	apiKey := valueOr(query.Get(QueryAPIKey), config.APIKey)
+	enc.output, err = outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	if enc.opType != ElasticOpIndex && enc.opType != ElasticOpCreate {
+		return nil, fmt.Errorf("unknown op type '%s', only `%s` or `%s` are allowed", enc.opType, ElasticOpIndex, ElasticOpCreate)
+	}
+	query.Del(QueryIndex)
+	query.Del(QueryOpType)
+	query.Del(QueryAPIKey)
+	u.RawQuery = query.Encode()
+
+	// 2.
+	httpConfig := sysAddr.HTTP
+	httpConfig.Format = ""
+	httpConfig.ContentType = ""
+	w, err := HttpDialWithConfig(u.String(), &httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		w.headers.Set("Authorization", "ApiKey "+apiKey)
+	}
+	enc.label = w.label
+	w.encode = enc.encode
+	w.handleResponse = enc.handleResponse
+	w.contentType = "application/x-ndjson"
+	return w, nil
+}
+
+// encode - write an action line followed by document for each message
+func (e *elasticEncoder) encode(messages []httpMessage) ([]byte, error) {
+	p := newMessageParser()
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, message := range messages {
+		msg := readLogMessage(p, message.b, message.parsed)
+		msg.format(e.output)
+		action := map[string]map[string]string{
+			e.opType: {"_index": e.indexName(msg)},
+		}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(msg.document()); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// indexName -
+// replace date tokens YYYY, MM and DD by date of message, run templating and lower case result
+// as required by elasticsearch. Default index is used when templating fails.
+func (e *elasticEncoder) indexName(msg *logMessage) string {
+	ts := msg.timestamp.UTC()
+	dates := strings.NewReplacer(
+		"YYYY", ts.Format("2006"),
+		"MM", ts.Format("01"),
+		"DD", ts.Format("02"),
+	)
+	index := dates.Replace(e.index)
+	result, err := msg.render(map[string]string{"index": index})
+	if err != nil {
+		log.Warnf("elasticsearch '%s': using default index, templating index failed: %s", e.label, err.Error())
+		return dates.Replace(elasticDefaultIndex)
+	}
+	return strings.ToLower(result["index"])
+}
+
+// handleResponse -
+// items with a 429 or 5xx status are given back for being sent again,
+// other failed items are dropped as they will never be accepted
+func (e *elasticEncoder) handleResponse(messages []httpMessage, body []byte) ([]httpMessage, error) {
+	resp := elasticBulkResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid bulk response: %s", err.Error())
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+	if len(resp.Items) != len(messages) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(resp.Items), len(messages))
+	}
+	failed := make([]httpMessage, 0)
+	rejected := 0
+	reason := ""
+	for i, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+				failed = append(failed, messages[i])
+			case result.Status >= 300:
+				rejected++
+				reason = string(result.Error)
+			}
+		}
+	}
+	if rejected > 0 {
+		metrics.ElasticsearchRejected.WithLabelValues(e.label).Add(float64(rejected))
+		log.Warnf("elasticsearch '%s': %d documents rejected, last error: %s", e.label, rejected, reason)
+	}
+	return failed, nil
+}
@@ -0,0 +1,156 @@
+package syslog_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// bulkRequest - action and document lines received by fake elasticsearch
+type bulkRequest struct {
+	path      string
+	auth      string
+	actions   []map[string]map[string]string
+	documents []map[string]interface{}
+}
+
+var _ = Describe("ElasticWriter", func() {
+	var server *httptest.Server
+	var mu sync.Mutex
+	var requests []bulkRequest
+	// statuses - status of each item for successive bulk requests, all items succeed when empty
+	var statuses [][]int
+	var sysAddr *model.SyslogAddress
+
+	var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [APP/PROC/WEB/0] - [logsbroker@1368 app="org/space/app"] {"@cf":{"app":"app","org":"My-Org","space":"space"},"@message":"hello"}` + "\n"
+
+	BeforeEach(func() {
+		requests, statuses = nil, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			req := bulkRequest{path: r.URL.Path, auth: r.Header.Get("Authorization")}
+			scanner := bufio.NewScanner(r.Body)
+			for i := 0; scanner.Scan(); i++ {
+				if i%2 == 0 {
+					action := make(map[string]map[string]string)
+					Expect(json.Unmarshal(scanner.Bytes(), &action)).To(Succeed())
+					req.actions = append(req.actions, action)
+					continue
+				}
+				doc := make(map[string]interface{})
+				Expect(json.Unmarshal(scanner.Bytes(), &doc)).To(Succeed())
+				req.documents = append(req.documents, doc)
+			}
+			requests = append(requests, req)
+
+			var itemStatuses []int
+			if len(statuses) > 0 {
+				itemStatuses, statuses = statuses[0], statuses[1:]
+			}
+			items := make([]string, len(req.documents))
+			hasErrors := false
+			for i := range items {
+				status := http.StatusCreated
+				if i < len(itemStatuses) {
+					status = itemStatuses[i]
+				}
+				hasErrors = hasErrors || status >= 300
+				items[i] = fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"error_%d"}}}`, status, status)
+			}
+			fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, hasErrors, strings.Join(items, ","))
+		}))
+		sysAddr = &model.SyslogAddress{
+			Name: "loghost",
+			HTTP: model.HTTPOutputConfig{BatchSize: 3, Linger: "1h", MaxRetries: 2},
+			Elasticsearch: model.ElasticConfig{
+				Index:  "logs-{{ .Org }}-YYYY.MM.DD",
+				APIKey: "my-key",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	getRequests := func() []bulkRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]bulkRequest{}, requests...)
+	}
+
+	writeMessages := func(w *syslog.HttpWriter, messages ...string) {
+		wg := &sync.WaitGroup{}
+		for _, msg := range messages {
+			wg.Add(1)
+			go func(msg string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := w.Write([]byte(msg))
+				Expect(err).ToNot(HaveOccurred())
+			}(msg)
+		}
+		wg.Wait()
+	}
+
+	It("should send parsed data as documents to templated index", func() {
+		w, err := syslog.ElasticDial(strings.Replace(server.URL, "http", "elasticsearch", 1), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeMessages(w, message, message, "not a syslog message")
+
+		reqs := getRequests()
+		Expect(reqs).To(HaveLen(1))
+		Expect(reqs[0].path).To(Equal("/_bulk"))
+		Expect(reqs[0].auth).To(Equal("ApiKey my-key"))
+		Expect(reqs[0].actions).To(ContainElement(map[string]map[string]string{
+			"index": {"_index": "logs-my-org-2006.01.02"},
+		}))
+		Expect(reqs[0].documents).To(ContainElement(HaveKeyWithValue("@message", "hello")))
+		Expect(reqs[0].documents).To(ContainElement(HaveKeyWithValue("@message", "not a syslog message")))
+	})
+
+	It("should only retry items failed with a retryable status", func() {
+		statuses = [][]int{{http.StatusCreated, http.StatusTooManyRequests, http.StatusBadRequest}}
+		w, err := syslog.ElasticDial(strings.Replace(server.URL, "http", "elasticsearch", 1)+"?op_type=create", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeMessages(w,
+			strings.Replace(message, "hello", "first", 1),
+			strings.Replace(message, "hello", "second", 1),
+			strings.Replace(message, "hello", "third", 1),
+		)
+
+		reqs := getRequests()
+		Expect(reqs).To(HaveLen(2))
+		Expect(reqs[1].documents).To(HaveLen(1))
+		Expect(reqs[1].documents[0]["@message"]).To(Equal(reqs[0].documents[1]["@message"]))
+		Expect(reqs[1].actions[0]).To(HaveKey("create"))
+	})
+
+	It("should fail when retryable items are still refused after max retries", func() {
+		statuses = [][]int{{http.StatusServiceUnavailable}, {http.StatusServiceUnavailable}, {http.StatusServiceUnavailable}}
+		sysAddr.HTTP.BatchSize = 1
+		w, err := syslog.ElasticDial(strings.Replace(server.URL, "http", "elasticsearch", 1), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).To(HaveOccurred())
+		Expect(getRequests()).To(HaveLen(3))
+	})
+})
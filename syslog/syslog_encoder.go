@@ -0,0 +1,390 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	FormatRFC5424JSON = "rfc5424-json"
+	FormatRFC3164     = "rfc3164"
+	FormatJSONLines   = "json-lines"
+	FormatLogfmt      = "logfmt"
+	FormatCEF         = "cef"
+	FormatECSJSON     = "ecs-json"
+	cefVendor         = "Orange"
+	cefProduct        = "logs-service-broker"
+	cefVersion        = "1.0"
+)
+
+// Encoder - render a message built by parser in an output format
+type Encoder interface {
+	Encode(msg *rfc5424.SyslogMessage) ([]byte, error)
+}
+
+// EncoderFunc - function usable as an encoder
+type EncoderFunc func(msg *rfc5424.SyslogMessage) ([]byte, error)
+
+func (f EncoderFunc) Encode(msg *rfc5424.SyslogMessage) ([]byte, error) {
+	return f(msg)
+}
+
+// messageEncoder - built-in encoder using message as read by writers, json message is not decoded again
+type messageEncoder func(m *logMessage) ([]byte, error)
+
+func (f messageEncoder) Encode(msg *rfc5424.SyslogMessage) ([]byte, error) {
+	return f(newLogMessage(msg))
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		FormatRFC5424JSON: EncoderFunc(encodeRFC5424),
+		FormatRFC3164:     messageEncoder(encodeRFC3164),
+		FormatJSONLines:   messageEncoder(encodeJSONLine),
+		FormatLogfmt:      messageEncoder(encodeLogfmt),
+		FormatCEF:         messageEncoder(encodeCEF),
+		FormatECSJSON:     messageEncoder(encodeECS),
+	}
+)
+
+// RegisterEncoder - make an output format available to plans, an existing format is replaced
+func RegisterEncoder(format string, enc Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[format] = enc
+}
+
+// GetEncoder - encoder of given output format, empty format gives rfc5424-json
+func GetEncoder(format string) (Encoder, error) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	enc, ok := encoders[valueOr(format, FormatRFC5424JSON)]
+	if !ok {
+		names := make([]string, 0, len(encoders))
+		for name := range encoders {
+			names = append(names, "`"+name+"`")
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown output format '%s', only %s are allowed", format, strings.Join(names, ", "))
+	}
+	return enc, nil
+}
+
+// outputEncoder - encoder of syslog address, nil is given for rfc5424-json as messages are already in this format
+func outputEncoder(format string) (Encoder, error) {
+	if format == "" || format == FormatRFC5424JSON {
+		return nil, nil
+	}
+	return GetEncoder(format)
+}
+
+// EncodingWriter - render messages received in rfc 5424 in an output format before giving them to writer
+type EncodingWriter struct {
+	io.WriteCloser
+	enc Encoder
+}
+
+func NewEncodingWriter(w io.WriteCloser, enc Encoder) *EncodingWriter {
+	return &EncodingWriter{WriteCloser: w, enc: enc}
+}
+
+func (w *EncodingWriter) Write(b []byte) (int, error) {
+	return w.WriteKey("", b, nil)
+}
+
+// WriteKey - messages which can't be parsed are given as is, parsed message is not given to writer as it differs from output
+func (w *EncodingWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	out := b
+	msg := readLogMessage(newMessageParser(), b, parsed)
+	if msg.parsed != nil && msg.parsed.Message != nil {
+		var err error
+		out, err = msg.encode(w.enc)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := WriteWithKey(w.WriteCloser, key, out, nil); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func encodeRFC5424(msg *rfc5424.SyslogMessage) ([]byte, error) {
+	s, err := msg.String()
+	return []byte(s), err
+}
+
+// encodeRFC3164 - BSD syslog header followed by json message, structured data is dropped
+func encodeRFC3164(m *logMessage) ([]byte, error) {
+	msg := m.parsed
+	priority := uint8(0)
+	if msg.Priority != nil {
+		priority = *msg.Priority
+	}
+	tag := ""
+	if msg.Appname != nil {
+		tag = *msg.Appname
+	}
+	if msg.ProcID != nil {
+		tag += "[" + strings.Trim(*msg.ProcID, "[]") + "]"
+	}
+	return []byte(fmt.Sprintf("<%d>%s %s %s: %s",
+		priority, m.timestamp.Format(time.Stamp), valueOr(m.hostname, "-"), valueOr(tag, "-"), m.body)), nil
+}
+
+// encodeJSONLine - json message alone, structured data is added in `@structured_data`
+func encodeJSONLine(m *logMessage) ([]byte, error) {
+	msg := m.parsed
+	doc := m.document()
+	if msg.StructuredData != nil && len(*msg.StructuredData) > 0 {
+		doc["@structured_data"] = *msg.StructuredData
+	}
+	return json.Marshal(doc)
+}
+
+// encodeLogfmt -
+// time, host, level and message first then other fields of json message sorted by name,
+// nested fields are flattened with dots and `@` prefix of names is removed
+func encodeLogfmt(m *logMessage) ([]byte, error) {
+	fields := make(map[string]string)
+	flattenFields(fields, "", m.data)
+	message, hasMessage := fields["message"]
+	if !hasMessage && m.data == nil {
+		message = m.body
+	}
+	level := valueOr(fields["level"], severityName(m.severity))
+	for _, key := range []string{"timestamp", "message", "level"} {
+		delete(fields, key)
+	}
+	for k, v := range m.params {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("time=" + m.timestamp.Format(time.RFC3339Nano))
+	b.WriteString(" host=" + logfmtValue(m.hostname))
+	b.WriteString(" level=" + logfmtValue(level))
+	b.WriteString(" msg=" + logfmtValue(message))
+	for _, key := range sortedKeys(fields) {
+		b.WriteString(" " + logfmtKey(key) + "=" + logfmtValue(fields[key]))
+	}
+	return []byte(b.String()), nil
+}
+
+// encodeCEF - ArcSight common event format, cloud foundry fields are given in custom string extensions
+func encodeCEF(m *logMessage) ([]byte, error) {
+	signature := "log"
+	if v, ok := m.source("type"); ok && v != "" {
+		signature = v
+	}
+	message, _ := m.data["@message"].(string)
+	if m.data == nil {
+		message = m.body
+	}
+	name := strings.SplitN(valueOr(message, signature), "\n", 2)[0]
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(m.timestamp.UnixMilli(), 10)},
+		{"dvchost", m.hostname},
+		{"msg", message},
+	}
+	custom := [][2]string{
+		{"org", m.cf("org")},
+		{"space", m.cf("space")},
+		{"app", m.cf("app")},
+		{"app_id", m.cf("app_id")},
+	}
+	for i, c := range custom {
+		n := strconv.Itoa(i + 1)
+		extensions = append(extensions, [2]string{"cs" + n + "Label", c[0]}, [2]string{"cs" + n, c[1]})
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(strings.Join([]string{
+		"CEF:0", cefHeader(cefVendor), cefHeader(cefProduct), cefHeader(cefVersion),
+		cefHeader(signature), cefHeader(name), strconv.Itoa(cefSeverity(m.severity)),
+	}, "|"))
+	b.WriteString("|")
+	first := true
+	for _, ext := range extensions {
+		if ext[1] == "" {
+			continue
+		}
+		if !first {
+			b.WriteString(" ")
+		}
+		first = false
+		b.WriteString(ext[0] + "=" + cefExtension(ext[1]))
+	}
+	return []byte(b.String()), nil
+}
+
+// encodeECS -
+// message mapped on elastic common schema by mapping of `ecs` schema, data already mapped by schema of plan are
+// not mapped twice.
+// 1. map a copy of data as message is shared between writers, message which is not json is given in `message`
+// 2. complete fields with syslog header, fields given by message are kept
+func encodeECS(m *logMessage) ([]byte, error) {
+	msg := m.parsed
+
+	// 1.
+	var doc map[string]interface{}
+	switch {
+	case m.data == nil:
+		doc = map[string]interface{}{"message": m.body}
+	case utils.FoundVarDelim(m.data, "ecs.version") != nil:
+		doc = utils.JSONValue(m.data).(map[string]interface{})
+	default:
+		doc = parser.ECSSchema{}.Map(utils.JSONValue(m.data).(map[string]interface{}))
+	}
+
+	// 2.
+	facility := 0
+	if msg.Facility != nil {
+		facility = int(*msg.Facility)
+	}
+	setMissingField(doc, "ecs.version", parser.ECSVersion)
+	setMissingField(doc, "@timestamp", m.timestamp.Format(time.RFC3339Nano))
+	setMissingField(doc, "log.level", strings.ToLower(severityName(m.severity)))
+	setMissingField(doc, "log.syslog.severity.code", m.severity)
+	setMissingField(doc, "log.syslog.severity.name", severityName(m.severity))
+	setMissingField(doc, "log.syslog.facility.code", facility)
+	if msg.Appname != nil {
+		setMissingField(doc, "log.syslog.appname", *msg.Appname)
+	}
+	if msg.ProcID != nil {
+		setMissingField(doc, "log.syslog.procid", *msg.ProcID)
+	}
+	setMissingField(doc, "host.hostname", m.hostname)
+	setMissingField(doc, "event.original", m.body)
+	for k, v := range m.params {
+		setMissingField(doc, "labels."+k, v)
+	}
+	return json.Marshal(doc)
+}
+
+// setMissingField - set value at dotted path when no value is found there, values which are not maps are kept
+func setMissingField(data map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := data
+	for _, key := range keys[:len(keys)-1] {
+		sub, ok := current[key].(map[string]interface{})
+		if !ok {
+			if _, exists := current[key]; exists {
+				return
+			}
+			sub = make(map[string]interface{})
+			current[key] = sub
+		}
+		current = sub
+	}
+	if _, ok := current[keys[len(keys)-1]]; !ok {
+		current[keys[len(keys)-1]] = value
+	}
+}
+
+// flattenFields - nested values joined by dots, `@` prefix of names is removed
+func flattenFields(fields map[string]string, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, sub := range v {
+			key := strings.TrimPrefix(k, "@")
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenFields(fields, key, sub)
+		}
+	case string:
+		fields[prefix] = v
+	case []interface{}:
+		b, _ := json.Marshal(v)
+		fields[prefix] = string(b)
+	default:
+		fields[prefix] = fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if strings.ContainsAny(value, " =\"\\\n\r\t") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// cefHeader - escape pipes and backslashes as required in header fields
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// cefExtension - escape backslashes, equal signs and new lines as required in extension values
+func cefExtension(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// cefSeverity - syslog severity on cef scale from 0 (lowest) to 10 (highest)
+func cefSeverity(severity int) int {
+	switch severity {
+	case 0:
+		return 10
+	case 1:
+		return 9
+	case 2:
+		return 8
+	case 3:
+		return 7
+	case 4:
+		return 5
+	case 5:
+		return 3
+	case 6:
+		return 1
+	}
+	return 0
+}
+
+var severityNames = []string{"emergency", "alert", "critical", "error", "warning", "notice", "informational", "debug"}
+
+func severityName(severity int) string {
+	if severity < 0 || severity >= len(severityNames) {
+		return ""
+	}
+	return severityNames[severity]
+}
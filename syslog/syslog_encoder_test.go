@@ -0,0 +1,128 @@
+package syslog_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+)
+
+var _ = Describe("Encoder", func() {
+	const message = `<11>1 2006-01-02T15:04:05Z org.space.app id-1 [APP/PROC/WEB/0] - [tags@1368 app="app" app_id="id-1" org="org"] ` +
+		`{"@cf":{"org":"org","app":"app","app_id":"id-1","space":"space"},"@source":{"type":"APP"},"@level":"ERROR","@message":"disk = full|now"}`
+
+	encode := func(format string) string {
+		enc, err := syslog.GetEncoder(format)
+		Expect(err).ToNot(HaveOccurred())
+		parsed, err := rfc5424.NewParser(rfc5424.WithBestEffort()).Parse([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+		b, err := enc.Encode(parsed.(*rfc5424.SyslogMessage))
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+
+	It("should keep rfc 5424 as default format", func() {
+		Expect(encode("")).To(Equal(message))
+		Expect(encode(syslog.FormatRFC5424JSON)).To(Equal(message))
+	})
+
+	It("should render rfc 3164", func() {
+		Expect(encode(syslog.FormatRFC3164)).To(Equal(`<11>Jan  2 15:04:05 org.space.app id-1[APP/PROC/WEB/0]: ` +
+			`{"@cf":{"org":"org","app":"app","app_id":"id-1","space":"space"},"@source":{"type":"APP"},"@level":"ERROR","@message":"disk = full|now"}`))
+	})
+
+	It("should render json lines without syslog envelope", func() {
+		doc := make(map[string]interface{})
+		Expect(json.Unmarshal([]byte(encode(syslog.FormatJSONLines)), &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("@message", "disk = full|now"))
+		Expect(doc).To(HaveKeyWithValue("@structured_data", HaveKeyWithValue("tags@1368", HaveKeyWithValue("app_id", "id-1"))))
+	})
+
+	It("should render logfmt", func() {
+		Expect(encode(syslog.FormatLogfmt)).To(Equal(`time=2006-01-02T15:04:05Z host=org.space.app level=ERROR msg="disk = full|now" ` +
+			`app=app app_id=id-1 cf.app=app cf.app_id=id-1 cf.org=org cf.space=space org=org source.type=APP`))
+	})
+
+	It("should render cef with escaped fields", func() {
+		Expect(encode(syslog.FormatCEF)).To(Equal(`CEF:0|Orange|logs-service-broker|1.0|APP|disk = full\|now|7|` +
+			`rt=1136214245000 dvchost=org.space.app msg=disk \= full|now cs1Label=org cs1=org cs2Label=space cs2=space ` +
+			`cs3Label=app cs3=app cs4Label=app_id cs4=id-1`))
+	})
+
+	It("should render ecs json with mapping of ecs schema", func() {
+		doc := make(map[string]interface{})
+		Expect(json.Unmarshal([]byte(encode(syslog.FormatECSJSON)), &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("@timestamp", "2006-01-02T15:04:05Z"))
+		Expect(doc).To(HaveKeyWithValue("message", "disk = full|now"))
+		Expect(doc).To(HaveKeyWithValue("log", And(
+			HaveKeyWithValue("level", "ERROR"),
+			HaveKeyWithValue("syslog", HaveKeyWithValue("severity", HaveKeyWithValue("name", "error"))))))
+		Expect(doc).To(HaveKeyWithValue("cloud", HaveKeyWithValue("account", HaveKeyWithValue("name", "org"))))
+		Expect(doc).To(HaveKeyWithValue("service", And(HaveKeyWithValue("name", "app"), HaveKeyWithValue("id", "id-1"))))
+		Expect(doc).To(HaveKeyWithValue("cloudfoundry", And(
+			HaveKeyWithValue("app", And(HaveKeyWithValue("name", "app"), HaveKeyWithValue("id", "id-1"))),
+			HaveKeyWithValue("log", HaveKeyWithValue("source", HaveKeyWithValue("type", "APP"))))))
+		Expect(doc).To(HaveKeyWithValue("host", HaveKeyWithValue("hostname", "org.space.app")))
+		Expect(doc).To(HaveKeyWithValue("labels", HaveKeyWithValue("org", "org")))
+		Expect(doc).ToNot(HaveKey("@cf"))
+	})
+
+	It("should not map again logs already mapped by ecs schema", func() {
+		enc, err := syslog.GetEncoder(syslog.FormatECSJSON)
+		Expect(err).ToNot(HaveOccurred())
+		mapped := `<11>1 2006-01-02T15:04:05Z org.space.app id-1 [APP/PROC/WEB/0] - - ` +
+			`{"ecs":{"version":"8.11.0"},"message":"disk full","log":{"level":"ERROR"},"service":{"name":"app"},"labels":{"type":"APP"}}`
+		parsed, err := rfc5424.NewParser(rfc5424.WithBestEffort()).Parse([]byte(mapped))
+		Expect(err).ToNot(HaveOccurred())
+		b, err := enc.Encode(parsed.(*rfc5424.SyslogMessage))
+		Expect(err).ToNot(HaveOccurred())
+
+		doc := make(map[string]interface{})
+		Expect(json.Unmarshal(b, &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("message", "disk full"))
+		Expect(doc).To(HaveKeyWithValue("service", Equal(map[string]interface{}{"name": "app"})))
+		Expect(doc).To(HaveKeyWithValue("labels", Equal(map[string]interface{}{"type": "APP"})))
+		Expect(doc).To(HaveKeyWithValue("log", HaveKeyWithValue("level", "ERROR")))
+		Expect(doc).To(HaveKeyWithValue("host", HaveKeyWithValue("hostname", "org.space.app")))
+		Expect(doc).ToNot(HaveKey("cloudfoundry"))
+	})
+
+	It("should refuse unknown formats when dialing", func() {
+		_, err := syslog.NewStrategyWriter(&model.SyslogAddress{
+			Name:         "unknown",
+			URLs:         []string{"file:///tmp/{{ .App }}.log"},
+			OutputFormat: "xml",
+		})
+		Expect(err).To(MatchError(ContainSubstring("unknown output format 'xml'")))
+	})
+
+	It("should use registered encoders in writers", func() {
+		syslog.RegisterEncoder("upper", syslog.EncoderFunc(func(msg *rfc5424.SyslogMessage) ([]byte, error) {
+			return []byte(strings.ToUpper(*msg.Message)), nil
+		}))
+		dir, err := os.MkdirTemp("", "encoder")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		w, err := syslog.NewStrategyWriter(&model.SyslogAddress{
+			Name:         "upper",
+			URLs:         []string{"file://" + dir + "/{{ .App }}.log"},
+			OutputFormat: "upper",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(dir, "app.log"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(HavePrefix(`{"@CF":{"ORG":"ORG"`))
+	})
+})
@@ -0,0 +1,382 @@
+package syslog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+	"github.com/orange-cloudfoundry/logs-service-broker/tpl"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	QueryMaxSizeMB        = "max_size_mb"
+	QueryRotateEvery      = "rotate_every"
+	QueryCompress         = "compress"
+	QueryMaxBackups       = "max_backups"
+	QueryMaxAge           = "max_age"
+	QueryMaxOpenFiles     = "max_open_files"
+	fileRotatedTimeFormat = "20060102T150405.000"
+)
+
+// fileTemplateAction - action of a path template, separators in its rendered value are not part of path layout
+var fileTemplateAction = regexp.MustCompile(`{{.*?}}`)
+
+// rotatingFile - file opened in append mode with what is needed to decide its rotation
+type rotatingFile struct {
+	path      string
+	f         *os.File
+	size      int64
+	period    time.Time
+	lastWrite time.Time
+}
+
+// FileWriter -
+// Append messages to files whose path is templated with data of message, as done for tags.
+// Files are rotated on size or time period, rotated files can be gzipped and are removed
+// according to retention limits.
+type FileWriter struct {
+	pathTpl     string
+	root        string
+	separators  int
+	maxSize     int64
+	rotateEvery time.Duration
+	compress    bool
+	maxBackups  int
+	maxAge      time.Duration
+	maxOpen     int
+	output      Encoder
+
+	mu    sync.Mutex // guards files
+	files map[string]*rotatingFile
+
+	// archiveMu serializes compression and cleanup, cleanup must not see a file being compressed
+	archiveMu sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// FileDial -
+// 1. check path template, files are only written under directory preceding first templated part
+// and templated values can't add directories
+// 2. load options from config and override them with url params
+func FileDial(addr string, sysAddr *model.SyslogAddress) (*FileWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	if !filepath.IsAbs(u.Path) {
+		return nil, fmt.Errorf("file url must give an absolute path, e.g.: file:///var/log/{{ .App }}.log")
+	}
+	static, _, _ := strings.Cut(u.Path, "{{")
+	root := filepath.Dir(static)
+	if static == u.Path {
+		root = filepath.Dir(u.Path)
+	}
+	_, err = tpl.NewTemplater(parser.TemplateData{}).Execute(map[string]string{"path": u.Path})
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %s", err.Error())
+	}
+
+	// 2.
+	config := sysAddr.File
+	w := &FileWriter{
+		pathTpl:     u.Path,
+		root:        root,
+		separators:  strings.Count(fileTemplateAction.ReplaceAllString(u.Path, ""), "/"),
+		rotateEvery: config.GetRotateEvery(),
+		compress:    config.Compress,
+		maxBackups:  config.MaxBackups,
+		maxAge:      config.GetMaxAge(),
+		maxOpen:     config.MaxOpenFiles,
+		files:       make(map[string]*rotatingFile),
+	}
+	query := u.Query()
+	maxSizeMB := config.MaxSizeMB
+	if err := parseQueryInt(query, QueryMaxSizeMB, &maxSizeMB); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryMaxBackups, &w.maxBackups); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryMaxOpenFiles, &w.maxOpen); err != nil {
+		return nil, err
+	}
+	if err := parseQueryDuration(query, QueryRotateEvery, &w.rotateEvery); err != nil {
+		return nil, err
+	}
+	if err := parseQueryDuration(query, QueryMaxAge, &w.maxAge); err != nil {
+		return nil, err
+	}
+	if raw := query.Get(QueryCompress); raw != "" {
+		w.compress, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid param '%s': %s", QueryCompress, err.Error())
+		}
+	}
+	w.maxSize = int64(max(maxSizeMB, 0)) * 1024 * 1024
+	if w.maxOpen <= 0 {
+		w.maxOpen = 256
+	}
+	w.output, err = outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *FileWriter) Write(b []byte) (int, error) {
+	return w.WriteKey("", b, nil)
+}
+
+// WriteKey -
+// 1. resolve path of file from message
+// 2. rotate file when message would make it exceed max size or when its time period is over
+// 3. append message as a line, in output format when one is set
+func (w *FileWriter) WriteKey(_ string, b []byte, parsed *Parsed) (int, error) {
+	// 1.
+	msg := readLogMessage(newMessageParser(), b, parsed)
+	path, err := w.filePath(msg)
+	if err != nil {
+		return 0, err
+	}
+	msg.format(w.output)
+	line := b
+	if msg.formatted != "" {
+		line = []byte(msg.formatted + "\n")
+	} else if len(line) == 0 || line[len(line)-1] != '\n' {
+		line = append(append([]byte{}, b...), '\n')
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rf, err := w.open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// 2.
+	now := time.Now()
+	sizeExceeded := w.maxSize > 0 && rf.size > 0 && rf.size+int64(len(line)) > w.maxSize
+	periodOver := w.rotateEvery > 0 && now.Truncate(w.rotateEvery).After(rf.period)
+	if sizeExceeded || periodOver {
+		rf, err = w.rotate(rf)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// 3.
+	n, err := rf.f.Write(line)
+	rf.size += int64(n)
+	rf.lastWrite = now
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// filePath -
+// render path template, resulting path must stay under root directory. Templated values, e.g. name
+// of app, must not contain parent references or separators for not writing in directories of others.
+func (w *FileWriter) filePath(msg *logMessage) (string, error) {
+	result, err := msg.render(map[string]string{"path": w.pathTpl})
+	if err != nil {
+		return "", fmt.Errorf("templating file path failed: %s", err.Error())
+	}
+	raw := result["path"]
+	for _, elem := range strings.Split(raw, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("file path '%s' is outside of '%s'", raw, w.root)
+		}
+	}
+	if strings.Count(raw, "/") != w.separators || strings.ContainsAny(raw, "\\\x00") {
+		return "", fmt.Errorf("templated values of file path '%s' must not contain path separators", raw)
+	}
+	path := filepath.Clean(raw)
+	if path == w.root || !strings.HasPrefix(path, strings.TrimSuffix(w.root, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("file path '%s' is outside of '%s'", path, w.root)
+	}
+	return path, nil
+}
+
+// open - file for given path, least recently written file is closed when too many are open
+func (w *FileWriter) open(path string) (*rotatingFile, error) {
+	if rf, ok := w.files[path]; ok {
+		return rf, nil
+	}
+	if len(w.files) >= w.maxOpen {
+		var oldest *rotatingFile
+		for _, rf := range w.files {
+			if oldest == nil || rf.lastWrite.Before(oldest.lastWrite) {
+				oldest = rf
+			}
+		}
+		utils.CloseAndLogError(oldest.f)
+		delete(w.files, oldest.path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		utils.CloseAndLogError(f)
+		return nil, err
+	}
+	rf := &rotatingFile{
+		path:      path,
+		f:         f,
+		size:      info.Size(),
+		period:    time.Now(),
+		lastWrite: time.Now(),
+	}
+	if info.Size() > 0 {
+		rf.period = info.ModTime()
+	}
+	if w.rotateEvery > 0 {
+		rf.period = rf.period.Truncate(w.rotateEvery)
+	}
+	w.files[path] = rf
+	return rf, nil
+}
+
+// rotate -
+// rename current file with rotation time as suffix and reopen path,
+// compression and retention of rotated files are done in background
+func (w *FileWriter) rotate(rf *rotatingFile) (*rotatingFile, error) {
+	delete(w.files, rf.path)
+	if err := rf.f.Close(); err != nil {
+		return nil, err
+	}
+	// rotation time is moved forward when a file has already been rotated at the same time
+	ts := time.Now()
+	rotated := rf.path + "." + ts.Format(fileRotatedTimeFormat)
+	for fileExists(rotated) || fileExists(rotated+".gz") {
+		ts = ts.Add(time.Millisecond)
+		rotated = rf.path + "." + ts.Format(fileRotatedTimeFormat)
+	}
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return nil, err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.archiveMu.Lock()
+		defer w.archiveMu.Unlock()
+		if w.compress {
+			if err := gzipFile(rotated); err != nil {
+				log.Warnf("file '%s': compressing rotated file failed: %s", rotated, err.Error())
+			}
+		}
+		if err := w.cleanup(rf.path); err != nil {
+			log.Warnf("file '%s': removing old rotated files failed: %s", rf.path, err.Error())
+		}
+	}()
+	return w.open(rf.path)
+}
+
+// cleanup - remove rotated files of path beyond max backups or older than max age
+func (w *FileWriter) cleanup(path string) error {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	rotated := make([]string, 0)
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		// only files named by rotation are considered, other files may be live files of other paths
+		if _, err := time.Parse(fileRotatedTimeFormat, strings.TrimSuffix(suffix, ".gz")); err != nil {
+			continue
+		}
+		rotated = append(rotated, entry.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+
+	var result error
+	for i, name := range rotated {
+		full := filepath.Join(filepath.Dir(path), name)
+		expired := false
+		if w.maxAge > 0 {
+			info, err := os.Stat(full)
+			expired = err == nil && time.Since(info.ModTime()) > w.maxAge
+		}
+		if (w.maxBackups > 0 && i >= w.maxBackups) || expired {
+			if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+	return result
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// gzipFile - replace file by its gzipped version
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseAndLogError(src)
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(dst)
+	_, err = io.Copy(gw, src)
+	if err == nil {
+		err = gw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close - close opened files and wait for background compressions
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	var result error
+	for path, rf := range w.files {
+		if err := rf.f.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		delete(w.files, path)
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+	return result
+}
@@ -0,0 +1,138 @@
+package syslog_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+var _ = Describe("FileWriter", func() {
+	var dir string
+	var sysAddr *model.SyslogAddress
+
+	message := func(org, app, text string) string {
+		return `<14>1 2006-01-02T15:04:05Z org.space.app - [APP/PROC/WEB/0] - - {"@cf":{"org":"` + org +
+			`","app":"` + app + `"},"@message":"` + text + `"}` + "\n"
+	}
+	readFile := func(path string) string {
+		b, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+	rotatedFiles := func(path string) []string {
+		files, err := filepath.Glob(path + ".2*")
+		Expect(err).ToNot(HaveOccurred())
+		return files
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "file-writer")
+		Expect(err).ToNot(HaveOccurred())
+		sysAddr = &model.SyslogAddress{Name: "archive"}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should append messages to templated paths", func() {
+		sysAddr.URLs = []string{"file://" + dir + "/{{ .Org }}/{{ .App }}.log"}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, app := range []string{"app1", "app2", "app1"} {
+			_, err = w.Write([]byte(message("org", app, "hello "+app)))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		Expect(readFile(filepath.Join(dir, "org", "app1.log"))).To(Equal(message("org", "app1", "hello app1") + message("org", "app1", "hello app1")))
+		Expect(readFile(filepath.Join(dir, "org", "app2.log"))).To(Equal(message("org", "app2", "hello app2")))
+	})
+
+	It("should refuse paths outside of base directory", func() {
+		w, err := syslog.FileDial("file://"+dir+"/logs/{{ .Org }}/{{ .App }}.log", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message("../..", "app", "hello")))
+		Expect(err).To(MatchError(ContainSubstring("outside")))
+	})
+
+	It("should refuse templated values writing in directories of others", func() {
+		w, err := syslog.FileDial("file://"+dir+"/{{ .Org }}/{{ .App }}.log", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message("evil", "../victim-org/app", "hello")))
+		Expect(err).To(MatchError(ContainSubstring("outside")))
+		_, err = w.Write([]byte(message("evil", "victim-org/app", "hello")))
+		Expect(err).To(MatchError(ContainSubstring("must not contain path separators")))
+		_, err = w.Write([]byte(message("victim-org/app", "app", "hello")))
+		Expect(err).To(MatchError(ContainSubstring("must not contain path separators")))
+		_, err = w.Write([]byte(message("evil", `..\\victim-org`, "hello")))
+		Expect(err).To(MatchError(ContainSubstring("must not contain path separators")))
+
+		_, err = os.Stat(filepath.Join(dir, "victim-org"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, err = os.Stat(filepath.Join(dir, "evil"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should rotate on size, compress and keep max backups", func() {
+		w, err := syslog.FileDial("file://"+dir+"/{{ .App }}.log?max_size_mb=1&compress=true&max_backups=2", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+
+		big := strings.Repeat("a", 600*1024)
+		for i := 0; i < 5; i++ {
+			_, err = w.Write([]byte(message("org", "app", big)))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		path := filepath.Join(dir, "app.log")
+		Expect(readFile(path)).To(Equal(message("org", "app", big)))
+		rotated := rotatedFiles(path)
+		Expect(rotated).To(HaveLen(2))
+		for _, file := range rotated {
+			Expect(file).To(HaveSuffix(".gz"))
+			f, err := os.Open(file)
+			Expect(err).ToNot(HaveOccurred())
+			gr, err := gzip.NewReader(f)
+			Expect(err).ToNot(HaveOccurred())
+			content, err := io.ReadAll(gr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(message("org", "app", big)))
+			Expect(f.Close()).To(Succeed())
+		}
+	})
+
+	It("should rotate on time period", func() {
+		w, err := syslog.FileDial("file://"+dir+"/{{ .App }}.log?rotate_every=50ms", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = w.Write([]byte(message("org", "app", "first")))
+		Expect(err).ToNot(HaveOccurred())
+		time.Sleep(60 * time.Millisecond)
+		_, err = w.Write([]byte(message("org", "app", "second")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		path := filepath.Join(dir, "app.log")
+		Expect(readFile(path)).To(Equal(message("org", "app", "second")))
+		rotated := rotatedFiles(path)
+		Expect(rotated).To(HaveLen(1))
+		Expect(readFile(rotated[0])).To(Equal(message("org", "app", "first")))
+	})
+})
@@ -0,0 +1,150 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	QueryFraming         = "framing"
+	maxFrameLengthDigits = 10
+)
+
+var (
+	ErrInvalidFrame   = errors.New("invalid octet counting frame")
+	ErrTruncatedFrame = errors.New("truncated octet counting frame")
+)
+
+// Framing - RFC 6587 method used to delimit messages on a stream transport
+type Framing string
+
+const (
+	// FramingNone - message is written as is, as done before framing was supported
+	FramingNone Framing = "none"
+	// FramingOctetCounting - message is prefixed by its length in bytes and a space, default and recommended
+	FramingOctetCounting Framing = "octet-counting"
+	// FramingNonTransparent - message is terminated by a line feed
+	FramingNonTransparent Framing = "non-transparent"
+	// framingNullByte - message is terminated by a null byte, as expected by gelf over tcp
+	framingNullByte Framing = "null-byte"
+)
+
+// ParseFraming - parse framing name, empty value gives octet counting
+func ParseFraming(s string) (Framing, error) {
+	switch Framing(strings.ToLower(strings.TrimSpace(s))) {
+	case FramingNone:
+		return FramingNone, nil
+	case "", FramingOctetCounting:
+		return FramingOctetCounting, nil
+	case FramingNonTransparent:
+		return FramingNonTransparent, nil
+	}
+	return "", fmt.Errorf("unknown framing '%s', only `%s`, `%s` or `%s` are allowed",
+		s, FramingNone, FramingOctetCounting, FramingNonTransparent)
+}
+
+// Frame - delimit message according to framing
+func (f Framing) Frame(msg string) string {
+	switch f {
+	case "", FramingNone:
+		return msg
+	case framingNullByte:
+		return msg + "\x00"
+	case FramingNonTransparent:
+		if strings.HasSuffix(msg, "\n") {
+			return msg
+		}
+		return msg + "\n"
+	}
+	return strconv.Itoa(len(msg)) + " " + msg
+}
+
+// ScanFrames -
+// bufio.SplitFunc reading RFC 6587 frames from a stream,
+// octet counting is used when frame starts with a digit and non-transparent framing otherwise
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := skipFrameSeparators(data)
+	if start == len(data) {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return start, nil, nil
+	}
+	if isDigit(data[start]) {
+		advance, token, err = scanOctetCounted(data[start:], atEOF)
+		if advance == 0 && err == nil {
+			return start, nil, nil
+		}
+		return start + advance, token, err
+	}
+	if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+		return start + i + 1, bytes.TrimSuffix(data[start:start+i], []byte("\r")), nil
+	}
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+// SplitFrames -
+// split a payload containing one or more messages.
+// Payload is considered as a batch of octet counted messages when it starts with a digit,
+// as a single message otherwise. On invalid framing, messages found before are returned along with the error.
+func SplitFrames(data []byte) ([][]byte, error) {
+	start := skipFrameSeparators(data)
+	if start == len(data) {
+		return [][]byte{}, nil
+	}
+	if !isDigit(data[start]) {
+		return [][]byte{data}, nil
+	}
+	frames := make([][]byte, 0)
+	for start < len(data) {
+		advance, token, err := scanOctetCounted(data[start:], true)
+		if err != nil {
+			return frames, fmt.Errorf("frame %d: %s", len(frames), err.Error())
+		}
+		frames = append(frames, token)
+		start += advance
+		start += skipFrameSeparators(data[start:])
+	}
+	return frames, nil
+}
+
+// scanOctetCounted - read a frame in format `<length> <message>`
+func scanOctetCounted(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	sp := bytes.IndexByte(data, ' ')
+	if sp < 0 || sp > maxFrameLengthDigits {
+		if atEOF || len(data) > maxFrameLengthDigits {
+			return 0, nil, ErrInvalidFrame
+		}
+		return 0, nil, nil
+	}
+	length, err := strconv.Atoi(string(data[:sp]))
+	if err != nil || length <= 0 {
+		return 0, nil, ErrInvalidFrame
+	}
+	end := sp + 1 + length
+	if end > len(data) {
+		if atEOF {
+			return 0, nil, ErrTruncatedFrame
+		}
+		return 0, nil, nil
+	}
+	return end, data[sp+1 : end], nil
+}
+
+func skipFrameSeparators(data []byte) int {
+	i := 0
+	for i < len(data) && (data[i] == '\n' || data[i] == '\r' || data[i] == ' ') {
+		i++
+	}
+	return i
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
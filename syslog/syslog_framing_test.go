@@ -0,0 +1,49 @@
+package syslog_test
+
+import (
+	"bufio"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+)
+
+var _ = Describe("SyslogFraming", func() {
+	Context("SplitFrames", func() {
+		It("should split octet counted messages", func() {
+			frames, err := syslog.SplitFrames([]byte("5 <1>ab 6 <1>cd\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frames).To(HaveLen(2))
+			Expect(string(frames[0])).To(Equal("<1>ab"))
+			Expect(string(frames[1])).To(Equal("<1>cd\n"))
+		})
+
+		It("should keep a non octet counted payload as a single message", func() {
+			frames, err := syslog.SplitFrames([]byte("<1>ab\n<1>cd"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frames).To(HaveLen(1))
+			Expect(string(frames[0])).To(Equal("<1>ab\n<1>cd"))
+		})
+
+		It("should return messages found before an invalid frame", func() {
+			frames, err := syslog.SplitFrames([]byte("5 <1>ab 60 <1>cd"))
+			Expect(err).To(MatchError(ContainSubstring("frame 1")))
+			Expect(frames).To(HaveLen(1))
+		})
+	})
+
+	Context("ScanFrames", func() {
+		It("should read octet counted and non-transparent frames from a stream", func() {
+			scanner := bufio.NewScanner(strings.NewReader("5 <1>ab<1>cd\r\n<1>ef\n7 <1>g\nhi"))
+			scanner.Split(syslog.ScanFrames)
+			frames := make([]string, 0)
+			for scanner.Scan() {
+				frames = append(frames, scanner.Text())
+			}
+			Expect(scanner.Err()).ToNot(HaveOccurred())
+			Expect(frames).To(Equal([]string{"<1>ab", "<1>cd", "<1>ef", "<1>g\nhi"}))
+		})
+	})
+})
@@ -0,0 +1,286 @@
+package syslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	QueryCompression     = "compression"
+	QueryChunkSize       = "chunk_size"
+	GelfCompressionGzip  = "gzip"
+	GelfCompressionZlib  = "zlib"
+	GelfCompressionNone  = "none"
+	gelfDefaultChunkSize = 1420
+	gelfMaxChunkSize     = 8192
+	gelfMaxChunks        = 128
+	gelfChunkHeaderSize  = 12
+	gelfHTTPPath         = "/gelf"
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfInvalidKeyChars - chars not allowed in additional field names
+var gelfInvalidKeyChars = regexp.MustCompile(`[^\w.\-]`)
+
+// gelfDataFields - data fields flattened into additional fields
+var gelfDataFields = []string{"@cf", "@app"}
+
+// gelfEncoder - convert parsed messages to gelf 1.1
+type gelfEncoder struct {
+	tagKeys []string
+	output  Encoder
+}
+
+// GelfWriter - send messages converted to gelf over udp or tcp
+type GelfWriter struct {
+	out         io.WriteCloser
+	enc         *gelfEncoder
+	udp         bool
+	compression string
+	chunkSize   int
+}
+
+// GelfDial -
+// 1. load options from config overridden by url params
+// 2. create http writer sending gelf messages for gelf+http(s) urls
+// 3. create syslog writer on udp, tcp or tcp with tls otherwise, tcp messages are delimited by a null byte
+func GelfDial(addr string, sysAddr *model.SyslogAddress) (io.WriteCloser, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	query := u.Query()
+	output, err := outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	enc := &gelfEncoder{tagKeys: mapKeys(sysAddr.Tags), output: output}
+	compression := valueOr(query.Get(QueryCompression), valueOr(sysAddr.GELF.Compression, GelfCompressionGzip))
+	chunkSize := sysAddr.GELF.ChunkSize
+	if err := parseQueryInt(query, QueryChunkSize, &chunkSize); err != nil {
+		return nil, err
+	}
+	if chunkSize <= gelfChunkHeaderSize || chunkSize > gelfMaxChunkSize {
+		chunkSize = gelfDefaultChunkSize
+	}
+	switch compression {
+	case GelfCompressionGzip, GelfCompressionZlib, GelfCompressionNone:
+	default:
+		return nil, fmt.Errorf("unknown compression '%s', only `%s`, `%s` or `%s` are allowed",
+			compression, GelfCompressionGzip, GelfCompressionZlib, GelfCompressionNone)
+	}
+	query.Del(QueryCompression)
+	query.Del(QueryChunkSize)
+	u.RawQuery = query.Encode()
+
+	// 2.
+	if u.Scheme == "gelf+http" || u.Scheme == "gelf+https" {
+		toHTTPScheme(u)
+		if u.Path == "" || u.Path == "/" {
+			u.Path = gelfHTTPPath
+		}
+		httpConfig := sysAddr.HTTP
+		httpConfig.Format = ""
+		httpConfig.ContentType = ""
+		w, err := HttpDialWithConfig(u.String(), &httpConfig)
+		if err != nil {
+			return nil, err
+		}
+		w.encode = enc.encodeLines
+		w.contentType = "application/json"
+		return w, nil
+	}
+
+	// 3.
+	switch u.Scheme {
+	case "gelf+udp":
+		u.Scheme = "udp"
+	case "gelf+tcp":
+		u.Scheme = "tcp"
+	case "gelf+tls":
+		u.Scheme = "tcp+tls"
+	default:
+		return nil, fmt.Errorf("unknown gelf scheme '%s'", u.Scheme)
+	}
+	out, err := Dial(u.String())
+	if err != nil {
+		return nil, err
+	}
+	out.framing = framingNullByte
+	return &GelfWriter{
+		out:         out,
+		enc:         enc,
+		udp:         u.Scheme == "udp",
+		compression: compression,
+		chunkSize:   chunkSize,
+	}, nil
+}
+
+func (w *GelfWriter) Write(b []byte) (int, error) {
+	return w.WriteKey("", b, nil)
+}
+
+// WriteKey - convert message to gelf, udp payload is compressed and chunked when it exceeds chunk size
+func (w *GelfWriter) WriteKey(_ string, b []byte, parsed *Parsed) (int, error) {
+	payload, err := w.enc.encode(readLogMessage(newMessageParser(), b, parsed))
+	if err != nil {
+		return 0, err
+	}
+	if !w.udp {
+		if _, err := w.out.Write(payload); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	payload, err = gelfCompress(w.compression, payload)
+	if err != nil {
+		return 0, err
+	}
+	chunks, err := gelfChunks(payload, w.chunkSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, chunk := range chunks {
+		if _, err := w.out.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *GelfWriter) Close() error {
+	return w.out.Close()
+}
+
+// encode -
+// message, timestamp and syslog severity become standard fields,
+// `@cf`, `@app` and tags fields become additional fields, full message is given in output format when one is set
+func (e *gelfEncoder) encode(msg *logMessage) ([]byte, error) {
+	msg.format(e.output)
+	fields := map[string]interface{}{
+		"version":       "1.1",
+		"host":          valueOr(msg.hostname, "unknown"),
+		"short_message": msg.body,
+		"timestamp":     json.Number(fmt.Sprintf("%d.%03d", msg.timestamp.Unix(), msg.timestamp.Nanosecond()/int(time.Millisecond))),
+		"level":         msg.severity,
+	}
+	if message, ok := msg.data["@message"].(string); ok && message != "" {
+		fields["short_message"] = message
+		fields["full_message"] = msg.body
+	}
+	if msg.formatted != "" {
+		fields["full_message"] = msg.formatted
+	}
+	if fields["short_message"] == "" {
+		fields["short_message"] = "-"
+	}
+	for _, key := range gelfDataFields {
+		gelfFlatten(fields, "_"+strings.TrimPrefix(key, "@"), msg.data[key])
+	}
+	for _, key := range e.tagKeys {
+		if v := msg.params[key]; v != "" {
+			fields[gelfKey("_"+key)] = v
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// encodeLines - gelf messages separated by a line feed, as expected by gelf http bulk inputs
+func (e *gelfEncoder) encodeLines(messages []httpMessage) ([]byte, error) {
+	p := newMessageParser()
+	lines := make([][]byte, len(messages))
+	for i, message := range messages {
+		line, err := e.encode(readLogMessage(p, message.b, message.parsed))
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = line
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// gelfFlatten - add nested values as additional fields, gelf only allows strings and numbers
+func gelfFlatten(fields map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, sub := range v {
+			gelfFlatten(fields, key+"_"+k, sub)
+		}
+	case string, float64:
+		fields[gelfKey(key)] = v
+	case bool:
+		fields[gelfKey(key)] = fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		fields[gelfKey(key)] = string(b)
+	}
+}
+
+func gelfKey(key string) string {
+	key = gelfInvalidKeyChars.ReplaceAllString(key, "_")
+	if key == "_id" {
+		return "_id_"
+	}
+	return key
+}
+
+func gelfCompress(compression string, payload []byte) ([]byte, error) {
+	if compression == GelfCompressionNone {
+		return payload, nil
+	}
+	buf := &bytes.Buffer{}
+	var cw io.WriteCloser = gzip.NewWriter(buf)
+	if compression == GelfCompressionZlib {
+		cw = zlib.NewWriter(buf)
+	}
+	if _, err := cw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfChunks -
+// split payload in chunks of given size, each one prefixed by magic bytes,
+// message id, sequence number and sequence count. Payload fitting in a chunk is sent as is.
+func gelfChunks(payload []byte, chunkSize int) ([][]byte, error) {
+	if len(payload) <= chunkSize {
+		return [][]byte{payload}, nil
+	}
+	dataSize := chunkSize - gelfChunkHeaderSize
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("gelf message of %d bytes needs %d chunks, only %d are allowed", len(payload), count, gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, rand.Uint64())
+	chunks := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*dataSize, len(payload))
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-i*dataSize)
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks[i] = append(chunk, payload[i*dataSize:end]...)
+	}
+	return chunks, nil
+}
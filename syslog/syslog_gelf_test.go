@@ -0,0 +1,192 @@
+package syslog_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// readGelfDatagrams - read datagrams until a whole message is received, reassembling chunks
+func readGelfDatagrams(conn net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+	chunks := make(map[byte][]byte)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		Expect(err).ToNot(HaveOccurred())
+		datagram := append([]byte{}, buf[:n]...)
+		if !bytes.HasPrefix(datagram, []byte{0x1e, 0x0f}) {
+			return datagram
+		}
+		Expect(len(datagram)).To(BeNumerically("<=", 100))
+		chunks[datagram[10]] = datagram[12:]
+		count := int(datagram[11])
+		if len(chunks) == count {
+			payload := make([]byte, 0)
+			for i := 0; i < count; i++ {
+				payload = append(payload, chunks[byte(i)]...)
+			}
+			return payload
+		}
+	}
+}
+
+func decodeGelf(b []byte) map[string]interface{} {
+	fields := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	Expect(dec.Decode(&fields)).To(Succeed())
+	return fields
+}
+
+var _ = Describe("GelfWriter", func() {
+	var sysAddr *model.SyslogAddress
+
+	var message = `<11>1 2006-01-02T15:04:05.999999Z org.space.app - [APP/PROC/WEB/0] - [tags@1368 env="prod"] {"@cf":{"app":"app","org":"org","app_instance":0},"@app":{"user":{"id":"a b"}},"@message":"hello"}` + "\n"
+
+	BeforeEach(func() {
+		sysAddr = &model.SyslogAddress{
+			Name: "graylog",
+			Tags: map[string]string{"env": "prod"},
+			HTTP: model.HTTPOutputConfig{MaxRetries: 1},
+		}
+	})
+
+	It("should convert message to gelf", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(conn)
+
+		w, err := syslog.GelfDial("gelf+udp://"+conn.LocalAddr().String()+"?compression=none", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		fields := decodeGelf(readGelfDatagrams(conn))
+		Expect(fields).To(HaveKeyWithValue("version", "1.1"))
+		Expect(fields).To(HaveKeyWithValue("host", "org.space.app"))
+		Expect(fields).To(HaveKeyWithValue("short_message", "hello"))
+		Expect(fields).To(HaveKeyWithValue("timestamp", json.Number("1136214245.999")))
+		Expect(fields).To(HaveKeyWithValue("level", json.Number("3")))
+		Expect(fields).To(HaveKeyWithValue("_cf_app", "app"))
+		Expect(fields).To(HaveKeyWithValue("_cf_app_instance", json.Number("0")))
+		Expect(fields).To(HaveKeyWithValue("_app_user_id", "a b"))
+		Expect(fields).To(HaveKeyWithValue("_env", "prod"))
+		Expect(fields["full_message"]).To(ContainSubstring(`"@message":"hello"`))
+	})
+
+	decompressors := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"zlib": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"none": func(r io.Reader) (io.Reader, error) { return r, nil },
+	}
+	for compression, decompress := range decompressors {
+		compression, decompress := compression, decompress
+		It("should chunk udp messages compressed with "+compression, func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(conn)
+
+			w, err := syslog.GelfDial("gelf+udp://"+conn.LocalAddr().String()+"?chunk_size=100&compression="+compression, sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			long := strings.Replace(message, "hello", strings.Repeat("hello ", 100), 1)
+			_, err = w.Write([]byte(long))
+			Expect(err).ToNot(HaveOccurred())
+
+			r, err := decompress(bytes.NewReader(readGelfDatagrams(conn)))
+			Expect(err).ToNot(HaveOccurred())
+			payload, err := io.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decodeGelf(payload)).To(HaveKeyWithValue("short_message", strings.Repeat("hello ", 100)))
+		})
+	}
+
+	It("should delimit tcp messages by a null byte", func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(l)
+		received := make(chan []byte, 2)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer utils.CloseAndLogError(conn)
+			r := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				b, err := r.ReadBytes(0)
+				if err != nil {
+					return
+				}
+				received <- b
+			}
+		}()
+
+		w, err := syslog.GelfDial("gelf+tcp://"+l.Addr().String(), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		for i := 0; i < 2; i++ {
+			_, err = w.Write([]byte(message))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		for i := 0; i < 2; i++ {
+			var b []byte
+			Eventually(received).Should(Receive(&b))
+			Expect(b).To(HaveSuffix("}\x00"))
+			Expect(decodeGelf(bytes.TrimSuffix(b, []byte{0}))).To(HaveKeyWithValue("short_message", "hello"))
+		}
+	})
+
+	It("should send gelf messages over http", func() {
+		var mu sync.Mutex
+		var paths []string
+		var bodies [][]byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			b, _ := io.ReadAll(r.Body)
+			paths = append(paths, r.URL.Path)
+			bodies = append(bodies, b)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		sysAddr.URLs = []string{strings.Replace(server.URL, "http", "gelf+http", 1)}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(paths).To(Equal([]string{"/gelf"}))
+		Expect(decodeGelf(bodies[0])).To(HaveKeyWithValue("_cf_org", "org"))
+	})
+
+	It("should refuse unknown compression", func() {
+		_, err := syslog.GelfDial("gelf+udp://127.0.0.1:12201?compression=lz4", sysAddr)
+		Expect(err).To(HaveOccurred())
+	})
+})
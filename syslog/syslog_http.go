@@ -3,96 +3,528 @@ package syslog
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
 	"github.com/orange-cloudfoundry/logs-service-broker/utils"
 )
 
-const QueryInGzip = "in_gzip"
+const (
+	QueryInGzip       = "in_gzip"
+	QueryHeader       = "header"
+	QueryBearerToken  = "bearer_token"
+	QueryClientCert   = "client_cert"
+	QueryClientKey    = "client_key"
+	QueryFormat       = "format"
+	QueryContentType  = "content_type"
+	QueryBatchSize    = "batch_size"
+	QueryBatchBytes   = "batch_bytes"
+	QueryLinger       = "linger"
+	QueryTimeout      = "timeout"
+	QueryMaxRetries   = "max_retries"
+	HttpFormatLines   = "lines"
+	HttpFormatJSON    = "json"
+	httpMinBackoff    = 100 * time.Millisecond
+	httpMaxBackoff    = 5 * time.Second
+	httpMaxRetryAfter = 30 * time.Second
+)
+
+// httpQueryParams - url params consumed by writer and removed from url sent to server
+var httpQueryParams = []string{
+	QueryInGzip, QueryHeader, QueryBearerToken, QueryClientCert, QueryClientKey,
+	QueryFormat, QueryContentType, QueryBatchSize, QueryBatchBytes, QueryLinger,
+	QueryTimeout, QueryMaxRetries, QueryAsync, QueryBufferSize, QueryVerify, QueryCert,
+}
+
+var ErrHttpBufferFull = errors.New("http buffer is full")
+
+// httpStatusError - non success response
+type httpStatusError struct {
+	code       int
+	body       string
+	retryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.code, e.body)
+}
+
+// retryableStatus - server may accept the same request later on 429 and 5xx
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// partialError - part of messages sent in a request have been refused with a retryable error
+type partialError struct {
+	failed int
+	total  int
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d of %d messages failed", e.failed, e.total)
+}
+
+// retryDelay - delay before sending again after given error, false is returned when error is not retryable
+func (t *HttpWriter) retryDelay(err error, attempt int) (time.Duration, bool) {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		if !t.retryStatus(statusErr.code) {
+			return 0, false
+		}
+		if statusErr.retryAfter > 0 {
+			return min(statusErr.retryAfter, httpMaxRetryAfter), true
+		}
+	}
+	return httpBackoff(attempt), true
+}
+
+// httpMessage - message waiting in a batch with its parsed form when known
+type httpMessage struct {
+	b      []byte
+	parsed *Parsed
+}
+
+// httpBatch - messages sent in a single request, writers wait on done for the request result
+type httpBatch struct {
+	messages []httpMessage
+	size     int
+	done     chan struct{}
+	err      error
+}
 
 type HttpWriter struct {
-	url    string
-	inGzip bool
+	url         string
+	label       string
+	client      *http.Client
+	inGzip      bool
+	headers     http.Header
+	username    string
+	password    string
+	bearerToken string
+	encode      func(messages []httpMessage) ([]byte, error)
+	// retryStatus tells if a request refused with given status code can be sent again
+	retryStatus func(code int) bool
+	// handleResponse gives back messages to send again from response body of a successful request
+	handleResponse func(messages []httpMessage, body []byte) ([]httpMessage, error)
+	contentType    string
+	batchSize      int
+	batchBytes     int
+	linger         time.Duration
+	maxRetries     int
+	async          bool
+	bufferSize     int
+
+	mu       sync.Mutex // guards current and buffered
+	current  *httpBatch
+	buffered int
+	inflight sync.WaitGroup
 }
 
 func HttpDial(addr string) (*HttpWriter, error) {
+	return HttpDialWithConfig(addr, &model.HTTPOutputConfig{})
+}
+
+// HttpDialWithConfig -
+// 1. load options from config
+// 2. override them with url params and remove those params from url
+// 3. create http client with timeout, tls and without following redirects
+func HttpDialWithConfig(addr string, config *model.HTTPOutputConfig) (*HttpWriter, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
 	}
-	inGzip := false
-	inGzipRaw := u.Query().Get(QueryInGzip)
 
-	if inGzipRaw != "" {
-		inGzip, err = strconv.ParseBool(inGzipRaw)
+	// 1.
+	t := &HttpWriter{
+		label:       endpointLabel(addr),
+		headers:     make(http.Header),
+		username:    config.Username,
+		password:    config.Password,
+		bearerToken: config.BearerToken,
+		contentType: config.ContentType,
+		batchSize:   config.BatchSize,
+		batchBytes:  config.BatchBytes,
+		linger:      config.GetLinger(),
+		retryStatus: retryableStatus,
+		maxRetries:  config.MaxRetries,
+		async:       config.Async,
+		bufferSize:  config.BufferSize,
+	}
+	for k, v := range config.Headers {
+		t.headers.Set(k, v)
+	}
+	timeout := config.GetTimeout()
+	certFile, keyFile := config.ClientCertFile, config.ClientKeyFile
+
+	// 2.
+	query := u.Query()
+	if u.User != nil {
+		t.username = u.User.Username()
+		t.password, _ = u.User.Password()
+		u.User = nil
+	}
+	if raw := query.Get(QueryInGzip); raw != "" {
+		t.inGzip, err = strconv.ParseBool(raw)
 		if err != nil {
-			inGzip = true
+			t.inGzip = true
+		}
+	}
+	for _, header := range query[QueryHeader] {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid header '%s', format must be `name:value`", header)
 		}
+		t.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
 	}
-	// nolint:staticcheck
-	u.Query().Del(QueryInGzip)
+	t.bearerToken = valueOr(query.Get(QueryBearerToken), t.bearerToken)
+	format := valueOr(query.Get(QueryFormat), config.Format)
+	t.contentType = valueOr(query.Get(QueryContentType), t.contentType)
+	certFile = valueOr(query.Get(QueryClientCert), certFile)
+	keyFile = valueOr(query.Get(QueryClientKey), keyFile)
+	if err := parseQueryInt(query, QueryBatchSize, &t.batchSize); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryBatchBytes, &t.batchBytes); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryMaxRetries, &t.maxRetries); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryBufferSize, &t.bufferSize); err != nil {
+		return nil, err
+	}
+	if err := parseQueryDuration(query, QueryLinger, &t.linger); err != nil {
+		return nil, err
+	}
+	if raw := query.Get(QueryAsync); raw != "" {
+		t.async, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid param '%s': %s", QueryAsync, err.Error())
+		}
+	}
+	if err := parseQueryDuration(query, QueryTimeout, &timeout); err != nil {
+		return nil, err
+	}
+	tlsConf, err := tlsConfigFromAddr(u)
+	if err != nil {
+		return nil, err
+	}
+	for _, param := range httpQueryParams {
+		query.Del(param)
+	}
+	u.RawQuery = query.Encode()
+	t.url = u.String()
+
+	switch format {
+	case "", HttpFormatLines:
+		t.encode = encodeLines
+		t.contentType = valueOr(t.contentType, "text/plain")
+	case HttpFormatJSON:
+		t.encode = encodeJSONArray
+		t.contentType = valueOr(t.contentType, "application/json")
+	default:
+		return nil, fmt.Errorf("unknown format '%s', only `%s` or `%s` are allowed", format, HttpFormatLines, HttpFormatJSON)
+	}
+	if t.batchSize <= 0 || t.linger <= 0 {
+		t.batchSize = 1
+	}
+	if t.batchBytes <= 0 {
+		t.batchBytes = 1024 * 1024
+	}
+	if t.bufferSize <= 0 {
+		t.bufferSize = 10000
+	}
+	t.maxRetries = max(t.maxRetries, 0)
 
-	return &HttpWriter{
-		url:    u.String(),
-		inGzip: inGzip,
-	}, nil
+	// 3.
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	t.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return t, nil
 }
 
+// Write -
+// add message to current batch and wait for the batch to be sent unless writer is async,
+// batch is sent when it reaches max size, max bytes or when linger time is elapsed.
+// As a sync write holds its forwarder worker until the request ends, a batch never holds
+// more messages than `forwarder.queue.workers`, async writer must be used for bigger batches.
 func (t *HttpWriter) Write(b []byte) (int, error) {
+	return t.WriteKey("", b, nil)
+}
+
+// WriteKey - same as Write, parsed message is kept in batch for encoders not parsing message back
+func (t *HttpWriter) WriteKey(_ string, b []byte, parsed *Parsed) (int, error) {
+	batch, err := t.add(httpMessage{b: append([]byte{}, b...), parsed: parsed})
+	if err != nil {
+		return 0, err
+	}
+	if t.async {
+		return len(b), nil
+	}
+	<-batch.done
+	return len(b), batch.err
+}
+
+// add -
+// 1. refuse message when async writer has too many messages waiting for their request
+// 2. add message to current batch, a new batch is sent after linger time
+// 3. send full batch, in background when writer is async
+func (t *HttpWriter) add(message httpMessage) (*httpBatch, error) {
+	// 1.
+	t.mu.Lock()
+	if t.async && t.buffered >= t.bufferSize {
+		t.mu.Unlock()
+		return nil, ErrHttpBufferFull
+	}
+
+	// 2.
+	if t.current == nil {
+		batch := &httpBatch{done: make(chan struct{})}
+		t.current = batch
+		t.inflight.Add(1)
+		if t.batchSize > 1 {
+			time.AfterFunc(t.linger, func() {
+				t.flush(batch)
+			})
+		}
+	}
+	batch := t.current
+	batch.messages = append(batch.messages, message)
+	batch.size += len(message.b)
+	t.buffered++
+	full := len(batch.messages) >= t.batchSize || batch.size >= t.batchBytes
+	t.mu.Unlock()
+
+	// 3.
+	if full && t.async {
+		go t.flush(batch)
+	} else if full {
+		t.flush(batch)
+	}
+	return batch, nil
+}
+
+// flush - send given batch if it has not been sent already
+func (t *HttpWriter) flush(batch *httpBatch) {
+	t.mu.Lock()
+	if t.current != batch {
+		t.mu.Unlock()
+		return
+	}
+	t.current = nil
+	t.mu.Unlock()
+
+	batch.err = t.send(batch.messages)
+	t.report(batch)
+	close(batch.done)
+	t.inflight.Done()
+}
+
+// report - release buffer, failures of async writes are logged and counted as writers don't wait for them
+func (t *HttpWriter) report(batch *httpBatch) {
+	t.mu.Lock()
+	t.buffered -= len(batch.messages)
+	t.mu.Unlock()
+	if batch.err == nil || !t.async {
+		return
+	}
+	log.Warnf("http '%s': %d messages not delivered: %s", t.label, len(batch.messages), batch.err.Error())
+	metrics.AsyncDeliveryFailures.WithLabelValues(t.label).Add(float64(len(batch.messages)))
+}
+
+// encodeLines - one message per line, line feed ending messages is removed to not send empty lines
+func encodeLines(messages []httpMessage) ([]byte, error) {
+	lines := make([][]byte, len(messages))
+	for i, message := range messages {
+		lines[i] = bytes.TrimRight(message.b, "\n")
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+func encodeJSONArray(messages []httpMessage) ([]byte, error) {
+	list := make([]string, len(messages))
+	for i, message := range messages {
+		list[i] = string(bytes.TrimRight(message.b, "\n"))
+	}
+	return json.Marshal(list)
+}
+
+// send -
+// post messages, retrying on network errors, 429 and 5xx with backoff.
+// When a response handler is set, only messages it reports as failed are sent again.
+func (t *HttpWriter) send(messages []httpMessage) error {
+	for attempt := 0; ; attempt++ {
+		body, err := t.encode(messages)
+		if err != nil {
+			return err
+		}
+		respBody, err := t.post(body)
+		if err == nil && t.handleResponse != nil {
+			// request has been accepted, sending it again on handler error would duplicate messages
+			var failed []httpMessage
+			failed, err = t.handleResponse(messages, respBody)
+			if err != nil || len(failed) == 0 {
+				return err
+			}
+			err = &partialError{failed: len(failed), total: len(messages)}
+			messages = failed
+		}
+		if err == nil || attempt >= t.maxRetries {
+			return err
+		}
+		wait, retryable := t.retryDelay(err, attempt)
+		if !retryable {
+			return err
+		}
+		metrics.HttpOutputRetries.WithLabelValues(t.label).Inc()
+		time.Sleep(wait)
+	}
+}
+
+// post - send encoded messages and give back response body when a response handler is set
+func (t *HttpWriter) post(body []byte) ([]byte, error) {
+	var err error
 	if t.inGzip {
-		return len(b), t.writeGzip(b)
+		body, err = gzipContent(body)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return len(b), t.writePlain(b)
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = t.headers.Clone()
+	req.Header.Set("Content-Type", t.contentType)
+	if t.inGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case t.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+t.bearerToken)
+	case t.username != "":
+		req.SetBasicAuth(t.username, t.password)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogError(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		statusErr := &httpStatusError{code: resp.StatusCode, body: string(b)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, statusErr
+	}
+	if t.handleResponse != nil {
+		return io.ReadAll(resp.Body)
+	}
+	// drain body for letting connection be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil, nil
 }
 
-func (t *HttpWriter) writeGzip(b []byte) error {
+// Close - send pending batch and wait for requests of async writes
+func (t *HttpWriter) Close() error {
+	t.mu.Lock()
+	batch := t.current
+	t.mu.Unlock()
+	if batch != nil {
+		t.flush(batch)
+		<-batch.done
+	}
+	t.inflight.Wait()
+	if batch == nil {
+		return nil
+	}
+	return batch.err
+}
+
+func gzipContent(b []byte) ([]byte, error) {
 	buf := &bytes.Buffer{}
 	gw := gzip.NewWriter(buf)
 	if _, err := gw.Write(b); err != nil {
-		return err
+		return nil, err
 	}
 	if err := gw.Flush(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := gw.Close(); err != nil {
-		return err
+		return nil, err
 	}
-	return t.post("gzip", buf)
+	return buf.Bytes(), nil
 }
 
-func (t *HttpWriter) writePlain(b []byte) error {
-	return t.post("", bytes.NewBuffer(b))
+// httpBackoff - exponential backoff with equal jitter
+func httpBackoff(attempt int) time.Duration {
+	d := httpMaxBackoff
+	if attempt < 16 {
+		d = min(httpMinBackoff<<attempt, httpMaxBackoff)
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
 }
 
-func (t *HttpWriter) post(contentEncoding string, r io.Reader) error {
-	req, err := http.NewRequest("POST", t.url, r)
-	if err != nil {
-		return err
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
 	}
-	req.Header.Add("Content-Type", "text/plain")
+	return value
+}
 
-	if contentEncoding != "" {
-		req.Header.Add("Content-Encoding", contentEncoding)
+func parseQueryInt(query url.Values, key string, dst *int) error {
+	raw := query.Get(key)
+	if raw == "" {
+		return nil
 	}
-
-	resp, err := http.DefaultClient.Do(req)
+	v, err := strconv.Atoi(raw)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid param '%s': %s", key, err.Error())
 	}
-	defer utils.CloseAndLogError(resp.Body)
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("%s", string(b))
-	}
-
+	*dst = v
 	return nil
 }
 
-func (t *HttpWriter) Close() error {
+func parseQueryDuration(query url.Values, key string, dst *time.Duration) error {
+	raw := query.Get(key)
+	if raw == "" {
+		return nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid param '%s': %s", key, err.Error())
+	}
+	*dst = v
 	return nil
 }
@@ -0,0 +1,181 @@
+package syslog_test
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+var _ = Describe("HttpWriter", func() {
+	var server *recordingServer
+
+	BeforeEach(func() {
+		server = newRecordingServer(nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	writeAll := func(w io.Writer, messages ...string) {
+		wg := &sync.WaitGroup{}
+		for _, msg := range messages {
+			wg.Add(1)
+			go func(msg string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := w.Write([]byte(msg))
+				Expect(err).ToNot(HaveOccurred())
+			}(msg)
+		}
+		wg.Wait()
+	}
+
+	It("should send messages in batch when batch size is reached", func() {
+		w, err := syslog.HttpDial(server.URL + "?batch_size=3&linger=1h")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, "msg", "msg", "msg")
+
+		Expect(server.Requests()).To(HaveLen(1))
+		Expect(server.Requests()[0].body).To(Equal("msg\nmsg\nmsg"))
+		Expect(server.Requests()[0].query).To(BeEmpty())
+	})
+
+	It("should not send empty lines for messages ending with a line feed", func() {
+		w, err := syslog.HttpDial(server.URL + "?batch_size=2&linger=1h")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, "msg\n", "msg\n")
+
+		Expect(server.Requests()).To(HaveLen(1))
+		Expect(server.Requests()[0].body).To(Equal("msg\nmsg"))
+	})
+
+	It("should not wait for batch to be sent when writer is async", func() {
+		w, err := syslog.HttpDial(server.URL + "?batch_size=100&linger=1h&async=true&buffer_size=2")
+		Expect(err).ToNot(HaveOccurred())
+
+		writeAll(w, "msg 1", "msg 2")
+		_, err = w.Write([]byte("msg 3"))
+		Expect(err).To(MatchError(syslog.ErrHttpBufferFull))
+		Expect(server.Requests()).To(BeEmpty())
+
+		Expect(w.Close()).To(Succeed())
+		Expect(server.Requests()).To(HaveLen(1))
+		Expect(server.Requests()[0].body).To(SatisfyAny(Equal("msg 1\nmsg 2"), Equal("msg 2\nmsg 1")))
+	})
+
+	It("should send pending messages when linger time is elapsed", func() {
+		w, err := syslog.HttpDial(server.URL + "?batch_size=100&linger=20ms")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		start := time.Now()
+		writeAll(w, "msg 1")
+
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+		Expect(server.Requests()).To(HaveLen(1))
+	})
+
+	It("should send messages as json array with given content type", func() {
+		w, err := syslog.HttpDialWithConfig(server.URL, &model.HTTPOutputConfig{
+			Format:      syslog.HttpFormatJSON,
+			ContentType: "application/vnd.logs+json",
+			BatchSize:   2,
+			Linger:      "1h",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, `msg "1"`, `msg "1"`)
+
+		Expect(server.Requests()).To(HaveLen(1))
+		Expect(server.Requests()[0].body).To(Equal(`["msg \"1\"","msg \"1\""]`))
+		Expect(server.Requests()[0].header.Get("Content-Type")).To(Equal("application/vnd.logs+json"))
+	})
+
+	It("should set auth and custom headers", func() {
+		w, err := syslog.HttpDialWithConfig(server.URL+"?header=X-Scope-OrgID:tenant&bearer_token=secret", &model.HTTPOutputConfig{
+			Headers: map[string]string{"X-Source": "logservice"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, "msg")
+
+		header := server.Requests()[0].header
+		Expect(header.Get("Authorization")).To(Equal("Bearer secret"))
+		Expect(header.Get("X-Scope-OrgID")).To(Equal("tenant"))
+		Expect(header.Get("X-Source")).To(Equal("logservice"))
+	})
+
+	It("should use basic auth from url", func() {
+		u := "http://user:pass@" + server.Listener.Addr().String()
+		w, err := syslog.HttpDial(u)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, "msg")
+
+		Expect(server.Requests()[0].header.Get("Authorization")).To(Equal("Basic dXNlcjpwYXNz"))
+	})
+
+	It("should retry on server errors", func() {
+		server.SetStatuses(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		w, err := syslog.HttpDial(server.URL + "?max_retries=2")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeAll(w, "msg")
+
+		Expect(server.Requests()).To(HaveLen(3))
+	})
+
+	It("should give up when max retries is reached", func() {
+		server.SetStatuses(http.StatusBadGateway, http.StatusBadGateway)
+		w, err := syslog.HttpDial(server.URL + "?max_retries=1")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte("msg"))
+		Expect(err).To(HaveOccurred())
+		Expect(server.Requests()).To(HaveLen(2))
+	})
+
+	It("should not retry on client errors and redirections", func() {
+		server.SetStatuses(http.StatusBadRequest, http.StatusFound)
+		w, err := syslog.HttpDial(server.URL + "?max_retries=3")
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte("msg"))
+		Expect(err).To(HaveOccurred())
+		_, err = w.Write([]byte("msg"))
+		Expect(err).To(HaveOccurred())
+		Expect(server.Requests()).To(HaveLen(2))
+	})
+
+	It("should send pending batch on close", func() {
+		w, err := syslog.HttpDial(server.URL + "?batch_size=10&linger=1h")
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			_, _ = w.Write([]byte("msg"))
+		}()
+		Eventually(func() []recordedRequest {
+			Expect(w.Close()).To(Succeed())
+			return server.Requests()
+		}).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,299 @@
+package syslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	QueryPartitionKey     = "partition_key"
+	QueryAcks             = "acks"
+	QueryClientID         = "client_id"
+	QuerySASLMechanism    = "sasl_mechanism"
+	QueryAsync            = "async"
+	QueryBufferSize       = "buffer_size"
+	KafkaPartitionBinding = "binding"
+	KafkaPartitionApp     = "app"
+	KafkaPartitionOrg     = "org"
+	KafkaSASLPlain        = "PLAIN"
+	KafkaSASLScramSHA256  = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512  = "SCRAM-SHA-512"
+	kafkaDefaultPort      = "9092"
+)
+
+var ErrKafkaBufferFull = errors.New("kafka buffer is full")
+
+// KafkaWriter -
+// Produce messages on a kafka topic with franz-go client. Records are batched by partition and
+// stay ordered in a partition. Partition is chosen by hashing binding id, app id or org id of
+// message with murmur2 as done by default partitioner of kafka clients.
+type KafkaWriter struct {
+	label        string
+	partitionKey string
+	async        bool
+	bufferSize   int
+	output       Encoder
+	client       *kgo.Client
+
+	// buffered - number of async records waiting for their delivery report
+	buffered atomic.Int64
+}
+
+// KafkaDial -
+// 1. load options from config and override them with url params, brokers are given as comma separated hosts
+// 2. prepare tls and sasl authentication, credentials can be given in url user info
+// 3. create client, brokers are contacted on first message for not failing when kafka is down at startup
+func KafkaDial(addr string, sysAddr *model.SyslogAddress) (*KafkaWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	config := sysAddr.Kafka
+	query := u.Query()
+	w := &KafkaWriter{
+		label:        endpointLabel(addr),
+		partitionKey: valueOr(query.Get(QueryPartitionKey), valueOr(config.PartitionKey, KafkaPartitionBinding)),
+		async:        config.Async,
+		bufferSize:   config.BufferSize,
+	}
+	topic := strings.Trim(u.Path, "/")
+	if topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("kafka url must be in the form kafka://broker1,broker2/topic")
+	}
+	var seeds []string
+	for _, host := range strings.Split(u.Host, ",") {
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, kafkaDefaultPort)
+		}
+		seeds = append(seeds, host)
+	}
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("at least one kafka broker must be given")
+	}
+	switch w.partitionKey {
+	case KafkaPartitionBinding, KafkaPartitionApp, KafkaPartitionOrg:
+	default:
+		return nil, fmt.Errorf("unknown partition key '%s', only `%s`, `%s` or `%s` are allowed",
+			w.partitionKey, KafkaPartitionBinding, KafkaPartitionApp, KafkaPartitionOrg)
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(seeds...),
+		kgo.DefaultProduceTopic(topic),
+		kgo.ClientID(valueOr(query.Get(QueryClientID), valueOr(config.ClientID, "logs-service-broker"))),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		kgo.RetryBackoffFn(httpBackoff),
+	}
+	acks := valueOr(query.Get(QueryAcks), valueOr(config.Acks, "all"))
+	switch acks {
+	case "all", "-1":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "1":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "0":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	default:
+		return nil, fmt.Errorf("unknown acks '%s', only `0`, `1` or `all` are allowed", acks)
+	}
+	compression := valueOr(query.Get(QueryCompression), valueOr(config.Compression, "none"))
+	switch compression {
+	case "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	default:
+		return nil, fmt.Errorf("unknown compression '%s', only `none`, `gzip` or `snappy` are allowed", compression)
+	}
+	batchBytes := config.BatchBytes
+	maxRetries := config.MaxRetries
+	linger := config.GetLinger()
+	timeout := config.GetTimeout()
+	if err := parseQueryInt(query, QueryBatchBytes, &batchBytes); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryMaxRetries, &maxRetries); err != nil {
+		return nil, err
+	}
+	if err := parseQueryInt(query, QueryBufferSize, &w.bufferSize); err != nil {
+		return nil, err
+	}
+	if err := parseQueryDuration(query, QueryLinger, &linger); err != nil {
+		return nil, err
+	}
+	if err := parseQueryDuration(query, QueryTimeout, &timeout); err != nil {
+		return nil, err
+	}
+	if raw := query.Get(QueryAsync); raw != "" {
+		w.async, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid param '%s': %s", QueryAsync, err.Error())
+		}
+	}
+	if batchBytes <= 0 {
+		batchBytes = 1024 * 1024
+	}
+	if w.bufferSize <= 0 {
+		w.bufferSize = 10000
+	}
+	maxRetries = max(maxRetries, 0)
+	opts = append(opts,
+		kgo.ProducerBatchMaxBytes(int32(batchBytes)),
+		kgo.ProducerLinger(max(linger, 0)),
+		kgo.RecordRetries(maxRetries),
+		kgo.DialTimeout(timeout),
+		kgo.ProduceRequestTimeout(timeout),
+		kgo.RecordDeliveryTimeout(max(time.Duration(maxRetries+1)*timeout+linger, time.Second)),
+		kgo.MaxBufferedRecords(w.bufferSize),
+	)
+	w.output, err = outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.
+	if u.Scheme == "kafka+tls" || config.TLS {
+		tlsConf, err := tlsConfigFromAddr(u)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConf))
+	}
+	mechanism, err := kafkaSASL(u, config)
+	if err != nil {
+		return nil, err
+	}
+	if mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	// 3.
+	w.client, err = kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// kafkaSASL - sasl mechanism from config or url, PLAIN is used when only credentials are given
+func kafkaSASL(u *url.URL, config model.KafkaConfig) (sasl.Mechanism, error) {
+	mechanism := strings.ToUpper(valueOr(u.Query().Get(QuerySASLMechanism), config.SASLMechanism))
+	username, password := config.Username, config.Password
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if mechanism == "" && username != "" {
+		mechanism = KafkaSASLPlain
+	}
+	switch mechanism {
+	case "":
+		return nil, nil
+	case KafkaSASLPlain:
+		return plain.Auth{User: username, Pass: password}.AsMechanism(), nil
+	case KafkaSASLScramSHA256:
+		return scram.Auth{User: username, Pass: password}.AsSha256Mechanism(), nil
+	case KafkaSASLScramSHA512:
+		return scram.Auth{User: username, Pass: password}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("unknown sasl mechanism '%s', only `%s`, `%s` or `%s` are allowed",
+		mechanism, KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512)
+}
+
+// Write - produce message with partition chosen from message content
+func (w *KafkaWriter) Write(b []byte) (int, error) {
+	return w.WriteKey("", b, nil)
+}
+
+// WriteKey -
+// 1. compute record key from binding id given as key, app id or org id of message, value is given in output format
+// 2. async writes are refused when too many records wait for delivery, their failures are reported by report
+// with metric labels of message given by forwarder
+// 3. sync writes wait for delivery report of their record, which is sent in batch after linger
+func (w *KafkaWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	// 1.
+	msg := readLogMessage(newMessageParser(), b, parsed)
+	msg.format(w.output)
+	record := &kgo.Record{Value: append([]byte{}, b...)}
+	if msg.formatted != "" {
+		record.Value = []byte(msg.formatted)
+	}
+	if w.partitionKey != KafkaPartitionBinding || key == "" {
+		key = msg.params["app_id"]
+		if w.partitionKey == KafkaPartitionOrg {
+			key = msg.params["org_id"]
+		}
+	}
+	if key != "" {
+		record.Key = []byte(key)
+	}
+
+	// 2.
+	if w.async {
+		if w.buffered.Add(1) > int64(w.bufferSize) {
+			w.buffered.Add(-1)
+			return 0, ErrKafkaBufferFull
+		}
+		var labels map[string]string
+		if parsed != nil {
+			labels = parsed.Labels
+		}
+		w.client.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
+			w.report(labels, err)
+		})
+		return len(b), nil
+	}
+
+	// 3.
+	done := make(chan error, 1)
+	w.client.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
+		done <- err
+	})
+	if err := <-done; err != nil {
+		return 0, fmt.Errorf("kafka '%s': %w", w.label, err)
+	}
+	return len(b), nil
+}
+
+// report -
+// release buffer, failures of async writes are logged and counted as writers don't wait for them.
+// Failures are counted in sent errors of message when its labels are known, e.g. not on spool replay.
+func (w *KafkaWriter) report(labels map[string]string, err error) {
+	w.buffered.Add(-1)
+	if err == nil {
+		return
+	}
+	log.Warnf("kafka '%s': message not delivered: %s", w.label, err.Error())
+	metrics.AsyncDeliveryFailures.WithLabelValues(w.label).Inc()
+	if labels != nil {
+		metrics.LogsSentFailure.With(labels).Inc()
+	}
+}
+
+// Close - wait for delivery of buffered records and close broker connections
+func (w *KafkaWriter) Close() error {
+	err := w.client.Flush(context.Background())
+	w.client.Close()
+	return err
+}
@@ -0,0 +1,302 @@
+package syslog_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// produceRequests - record batches received by cluster, errors can be returned to next produce requests
+type produceRequests struct {
+	mu      sync.Mutex
+	batches []kmsg.RecordBatch
+}
+
+func (p *produceRequests) Batches() []kmsg.RecordBatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]kmsg.RecordBatch{}, p.batches...)
+}
+
+// observeProduce - keep record batches of produce requests, cluster handles requests as usual
+func observeProduce(cluster *kfake.Cluster) *produceRequests {
+	p := &produceRequests{}
+	cluster.ControlKey(int16(kmsg.Produce), func(kreq kmsg.Request) (kmsg.Response, error, bool) {
+		req := kreq.(*kmsg.ProduceRequest)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, topic := range req.Topics {
+			for _, partition := range topic.Partitions {
+				var batch kmsg.RecordBatch
+				Expect(batch.ReadFrom(partition.Records)).To(Succeed())
+				p.batches = append(p.batches, batch)
+			}
+		}
+		return nil, nil, false
+	})
+	return p
+}
+
+// failProduce - answer next produce request with given error code for all its partitions
+func failProduce(cluster *kfake.Cluster, code int16) {
+	cluster.ControlKey(int16(kmsg.Produce), func(kreq kmsg.Request) (kmsg.Response, error, bool) {
+		req := kreq.(*kmsg.ProduceRequest)
+		resp := req.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range req.Topics {
+			respTopic := kmsg.NewProduceResponseTopic()
+			respTopic.Topic, respTopic.TopicID = topic.Topic, topic.TopicID
+			for _, partition := range topic.Partitions {
+				respPartition := kmsg.NewProduceResponseTopicPartition()
+				respPartition.Partition = partition.Partition
+				respPartition.ErrorCode = code
+				respTopic.Partitions = append(respTopic.Partitions, respPartition)
+			}
+			resp.Topics = append(resp.Topics, respTopic)
+		}
+		return resp, nil, true
+	})
+}
+
+// consume - read given number of records of topic from start
+func consume(cluster *kfake.Cluster, n int, opts ...kgo.Opt) []*kgo.Record {
+	client, err := kgo.NewClient(append(opts,
+		kgo.SeedBrokers(cluster.ListenAddrs()...),
+		kgo.ConsumeTopics("logs"),
+	)...)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		Expect(ctx.Err()).ToNot(HaveOccurred())
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+var _ = Describe("KafkaWriter", func() {
+	var cluster *kfake.Cluster
+	var sysAddr *model.SyslogAddress
+
+	message := func(appID string) string {
+		return `<14>1 2006-01-02T15:04:05Z org.space.app - [APP/PROC/WEB/0] - [logsbroker@1368 app_id="` + appID +
+			`" org_id="my-org-id"] {"@message":"hello"}` + "\n"
+	}
+
+	BeforeEach(func() {
+		var err error
+		cluster, err = kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(8, "logs"))
+		Expect(err).ToNot(HaveOccurred())
+		sysAddr = &model.SyslogAddress{
+			Name: "kafka",
+			Kafka: model.KafkaConfig{
+				Linger:     "0s",
+				MaxRetries: 3,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	kafkaURL := func(params string) string {
+		return "kafka://" + strings.Join(cluster.ListenAddrs(), ",") + "/logs" + params
+	}
+
+	It("should produce messages on partition of binding id", func() {
+		sysAddr.URLs = []string{kafkaURL("")}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = syslog.WriteWithKey(w, "my-binding", []byte(message("my-app-id")), nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		records := consume(cluster, 1)
+		Expect(records).To(HaveLen(1))
+		Expect(string(records[0].Key)).To(Equal("my-binding"))
+		Expect(string(records[0].Value)).To(Equal(message("my-app-id")))
+	})
+
+	It("should hash keys as kafka default partitioner", func() {
+		w, err := syslog.KafkaDial(kafkaURL(""), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+		// partitions computed with murmur2 of java client
+		for _, key := range []string{"21", "foobar"} {
+			_, err = w.WriteKey(key, []byte(message("my-app-id")), nil)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		partitions := make(map[string]int32)
+		for _, record := range consume(cluster, 2) {
+			partitions[string(record.Key)] = record.Partition
+		}
+		Expect(partitions).To(Equal(map[string]int32{"21": 4, "foobar": 6}))
+	})
+
+	It("should partition by app id or org id of message", func() {
+		w, err := syslog.KafkaDial(kafkaURL("?partition_key=app"), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+		for _, appID := range []string{"app-1", "app-2", "app-3"} {
+			_, err = w.WriteKey("my-binding", []byte(message(appID)), nil)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		wOrg, err := syslog.KafkaDial(kafkaURL("?partition_key=org"), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(wOrg)
+		_, err = wOrg.Write([]byte(message("app-1")))
+		Expect(err).ToNot(HaveOccurred())
+
+		var keys []string
+		for _, record := range consume(cluster, 4) {
+			keys = append(keys, string(record.Key))
+		}
+		Expect(keys).To(ConsistOf("app-1", "app-2", "app-3", "my-org-id"))
+	})
+
+	for _, compression := range []string{"gzip", "snappy"} {
+		compression := compression
+		It("should batch messages of a partition compressed with "+compression, func() {
+			requests := observeProduce(cluster)
+			w, err := syslog.KafkaDial(kafkaURL("?linger=200ms&compression="+compression), sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := w.WriteKey("my-binding", []byte(message("my-app-id")), nil)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+			}
+			wg.Wait()
+
+			batches := requests.Batches()
+			Expect(batches).To(HaveLen(1))
+			Expect(batches[0].NumRecords).To(BeEquivalentTo(10))
+			Expect(batches[0].Attributes & 7).To(BeEquivalentTo(map[string]int{"gzip": 1, "snappy": 2}[compression]))
+			Expect(consume(cluster, 10)).To(HaveLen(10))
+		})
+	}
+
+	It("should retry when leader has moved", func() {
+		requests := observeProduce(cluster)
+		failProduce(cluster, 6)
+		w, err := syslog.KafkaDial(kafkaURL(""), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message("my-app-id")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests.Batches()).To(HaveLen(2))
+		Expect(consume(cluster, 1)).To(HaveLen(1))
+	})
+
+	It("should not retry non retriable errors", func() {
+		requests := observeProduce(cluster)
+		failProduce(cluster, 87)
+		w, err := syslog.KafkaDial(kafkaURL(""), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message("my-app-id")))
+		Expect(err).To(MatchError(ContainSubstring("INVALID_RECORD")))
+		Expect(requests.Batches()).To(HaveLen(1))
+	})
+
+	for _, mechanism := range []string{"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"} {
+		mechanism := mechanism
+		It("should authenticate with sasl "+mechanism, func() {
+			cluster.Close()
+			var err error
+			cluster, err = kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(8, "logs"),
+				kfake.EnableSASL(), kfake.Superuser("PLAIN", "admin", "admin"), kfake.Superuser(mechanism, "user", "pass"))
+			Expect(err).ToNot(HaveOccurred())
+			withUser := func(password string) string {
+				return strings.Replace(kafkaURL("?max_retries=0&sasl_mechanism="+mechanism), "kafka://", "kafka://user:"+password+"@", 1)
+			}
+
+			w, err := syslog.KafkaDial(withUser("pass"), sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+			_, err = w.Write([]byte(message("my-app-id")))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(consume(cluster, 1, kgo.SASL(plain.Auth{User: "admin", Pass: "admin"}.AsMechanism()))).To(HaveLen(1))
+
+			wBad, err := syslog.KafkaDial(withUser("wrong"), sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(wBad)
+			_, err = wBad.Write([]byte(message("my-app-id")))
+			Expect(err).To(HaveOccurred())
+		})
+	}
+
+	It("should count delivery failures of async writes once in sent errors of message", func() {
+		counterValue := func(counter prometheus.Counter) float64 {
+			m := &dto.Metric{}
+			Expect(counter.Write(m)).To(Succeed())
+			return m.GetCounter().GetValue()
+		}
+		failures := metrics.AsyncDeliveryFailures.WithLabelValues(kafkaURL(""))
+		labels := map[string]string{
+			"instance_id": "my-instance", "binding_id": "async-binding", "plan_name": "kafka",
+			"org": "org", "space": "space", "app": "app",
+		}
+		sentFailures := metrics.LogsSentFailure.With(labels)
+		before, sentBefore := counterValue(failures), counterValue(sentFailures)
+
+		var produces atomic.Int32
+		cluster.ControlKey(int16(kmsg.Produce), func(kmsg.Request) (kmsg.Response, error, bool) {
+			produces.Add(1)
+			return nil, nil, false
+		})
+		failProduce(cluster, 87)
+		w, err := syslog.KafkaDial(kafkaURL("?async=true&acks=1"), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = w.WriteKey("async-binding", []byte(message("my-app-id")), &syslog.Parsed{Labels: labels})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(produces.Load).Should(BeEquivalentTo(1))
+		_, err = w.WriteKey("async-binding", []byte(message("my-app-id")), &syslog.Parsed{Labels: labels})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		Expect(counterValue(failures) - before).To(BeEquivalentTo(1))
+		Expect(counterValue(sentFailures) - sentBefore).To(BeEquivalentTo(1))
+		Expect(consume(cluster, 1)).To(HaveLen(1))
+	})
+
+	It("should refuse async writes when buffer is full", func() {
+		w, err := syslog.KafkaDial("kafka://127.0.0.1:1/logs?async=true&buffer_size=1&timeout=100ms&max_retries=0", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = w.Write([]byte(message("my-app-id")))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = w.Write([]byte(message("my-app-id")))
+		Expect(err).To(MatchError(syslog.ErrKafkaBufferFull))
+		Expect(w.Close()).To(Succeed())
+	})
+})
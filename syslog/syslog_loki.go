@@ -0,0 +1,225 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	LokiFormatProtobuf = "protobuf"
+	LokiFormatJSON     = "json"
+	lokiPushPath       = "/loki/api/v1/push"
+	lokiDefaultJob     = "logservice"
+)
+
+// lokiCFLabels - fields from `@cf` data used as stream labels
+var lokiCFLabels = []string{"org", "space", "app"}
+
+type lokiEntry struct {
+	timestamp time.Time
+	line      string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	key     string
+	entries []lokiEntry
+}
+
+// lokiEncoder - build loki push requests from parsed rfc 5424 messages
+type lokiEncoder struct {
+	tagKeys    []string
+	sourceKeys []string
+	static     map[string]string
+	output     Encoder
+}
+
+// LokiDial -
+// 1. convert loki url to http(s) one targeting push api when no path is given
+// 2. create http writer sending messages in loki push format, http options of syslog address apply
+func LokiDial(addr string, sysAddr *model.SyslogAddress) (*HttpWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	toHTTPScheme(u)
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	query := u.Query()
+	format := valueOr(query.Get(QueryFormat), sysAddr.Loki.Format)
+	query.Del(QueryFormat)
+	u.RawQuery = query.Encode()
+
+	// 2.
+	httpConfig := sysAddr.HTTP
+	httpConfig.Format = ""
+	httpConfig.ContentType = ""
+	w, err := HttpDialWithConfig(u.String(), &httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	output, err := outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	enc := &lokiEncoder{
+		tagKeys:    mapKeys(sysAddr.Tags),
+		sourceKeys: mapKeys(sysAddr.SourceLabels),
+		static:     sysAddr.Loki.Labels,
+		output:     output,
+	}
+	switch format {
+	case "", LokiFormatProtobuf:
+		w.encode = enc.encodeProtobuf
+		w.contentType = "application/x-protobuf"
+	case LokiFormatJSON:
+		w.encode = enc.encodeJSON
+		w.contentType = "application/json"
+	default:
+		return nil, fmt.Errorf("unknown loki format '%s', only `%s` or `%s` are allowed", format, LokiFormatProtobuf, LokiFormatJSON)
+	}
+	return w, nil
+}
+
+// streams - group messages by labels, keeping order of first appearance
+func (e *lokiEncoder) streams(messages []httpMessage) []*lokiStream {
+	parser := newMessageParser()
+	index := make(map[string]*lokiStream)
+	streams := make([]*lokiStream, 0)
+	for _, message := range messages {
+		msg := readLogMessage(parser, message.b, message.parsed)
+		msg.format(e.output)
+		labels := e.labels(msg)
+		key := lokiLabelsString(labels)
+		stream, ok := index[key]
+		if !ok {
+			stream = &lokiStream{labels: labels, key: key}
+			index[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, lokiEntry{timestamp: msg.timestamp, line: msg.line()})
+	}
+	return streams
+}
+
+// labels - made of static labels, tags found in structured data,
+// `@cf` fields and source labels found in `@source` of json message
+func (e *lokiEncoder) labels(msg *logMessage) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range e.static {
+		labels[lokiLabelName(k)] = v
+	}
+	for _, key := range e.tagKeys {
+		if v := msg.params[key]; v != "" {
+			labels[lokiLabelName(key)] = v
+		}
+	}
+	for _, key := range lokiCFLabels {
+		if v := msg.cf(key); v != "" {
+			labels[key] = v
+		}
+	}
+	for _, key := range e.sourceKeys {
+		if v, ok := msg.source(key); ok {
+			labels[lokiLabelName(key)] = v
+		}
+	}
+	if len(labels) == 0 {
+		labels["job"] = lokiDefaultJob
+	}
+	return labels
+}
+
+// encodeProtobuf - snappy compressed protobuf PushRequest as defined in loki push.proto
+func (e *lokiEncoder) encodeProtobuf(messages []httpMessage) ([]byte, error) {
+	var req []byte
+	for _, stream := range e.streams(messages) {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.BytesType)
+		sb = protowire.AppendString(sb, stream.key)
+		for _, entry := range stream.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.timestamp.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.timestamp.Nanosecond()))
+
+			var eb []byte
+			eb = protowire.AppendTag(eb, 1, protowire.BytesType)
+			eb = protowire.AppendBytes(eb, ts)
+			eb = protowire.AppendTag(eb, 2, protowire.BytesType)
+			eb = protowire.AppendString(eb, entry.line)
+
+			sb = protowire.AppendTag(sb, 2, protowire.BytesType)
+			sb = protowire.AppendBytes(sb, eb)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, sb)
+	}
+	return snappy.Encode(nil, req), nil
+}
+
+func (e *lokiEncoder) encodeJSON(messages []httpMessage) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+	for _, stream := range e.streams(messages) {
+		js := jsonStream{Stream: stream.labels}
+		for _, entry := range stream.entries {
+			js.Values = append(js.Values, [2]string{
+				strconv.FormatInt(entry.timestamp.UnixNano(), 10),
+				entry.line,
+			})
+		}
+		req.Streams = append(req.Streams, js)
+	}
+	return json.Marshal(req)
+}
+
+// lokiLabelsString - labels in prometheus format sorted by name, e.g.: {app="foo", org="bar"}
+func lokiLabelsString(labels map[string]string) string {
+	names := mapKeys(labels)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// lokiLabelName - replace chars not allowed in label name by an underscore
+func lokiLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package syslog_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	"github.com/klauspost/compress/snappy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+type lokiPush struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+var _ = Describe("LokiWriter", func() {
+	var server *recordingServer
+	var sysAddr *model.SyslogAddress
+
+	var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [APP/PROC/WEB/0] - [logsbroker@1368 app="org/space/app" env="prod"] {"@cf":{"app":"app","org":"org","space":"space"},"@message":"hello","@source":{"deployment":"production"}}` + "\n"
+	var other = `<14>1 2006-01-02T15:04:06Z org.space.other - [APP/PROC/WEB/0] - [logsbroker@1368 env="prod"] {"@cf":{"app":"other","org":"org","space":"space"},"@message":"bye"}` + "\n"
+
+	BeforeEach(func() {
+		server = newRecordingServer(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		sysAddr = &model.SyslogAddress{
+			Name:         "loghost",
+			Tags:         map[string]string{"env": "{{ .Org }}"},
+			SourceLabels: map[string]string{"deployment": "production"},
+			HTTP:         model.HTTPOutputConfig{BatchSize: 2, Linger: "1h"},
+			Loki:         model.LokiConfig{Labels: map[string]string{"cluster-name": "paris"}},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	lokiURL := func(scheme string) string {
+		return strings.Replace(server.URL, "http", scheme, 1)
+	}
+
+	writeBoth := func(w io.Writer) {
+		wg := &sync.WaitGroup{}
+		for _, msg := range []string{message, other} {
+			wg.Add(1)
+			go func(msg string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := w.Write([]byte(msg))
+				Expect(err).ToNot(HaveOccurred())
+			}(msg)
+		}
+		wg.Wait()
+	}
+
+	It("should push batch in json grouped by stream labels", func() {
+		w, err := syslog.LokiDial(lokiURL("loki")+"?format=json", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeBoth(w)
+
+		Expect(server.Paths()).To(Equal([]string{"/loki/api/v1/push"}))
+		Expect(server.ContentTypes()).To(Equal([]string{"application/json"}))
+		push := lokiPush{}
+		Expect(json.Unmarshal(server.Bodies()[0], &push)).To(Succeed())
+		Expect(push.Streams).To(HaveLen(2))
+		for _, stream := range push.Streams {
+			Expect(stream.Values).To(HaveLen(1))
+			if stream.Stream["app"] != "app" {
+				continue
+			}
+			Expect(stream.Stream).To(Equal(map[string]string{
+				"app":          "app",
+				"org":          "org",
+				"space":        "space",
+				"env":          "prod",
+				"deployment":   "production",
+				"cluster_name": "paris",
+			}))
+			Expect(stream.Values[0][0]).To(Equal("1136214245999999000"))
+			Expect(stream.Values[0][1]).To(HavePrefix(`{"@cf"`))
+		}
+	})
+
+	It("should use parsed message given along message instead of parsing it back", func() {
+		sysAddr.HTTP.BatchSize = 1
+		w, err := syslog.LokiDial(lokiURL("loki")+"?format=json", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		parsed, err := rfc5424.NewParser().Parse([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = syslog.WriteWithKey(w, "my-binding", []byte("not parsed back"), &syslog.Parsed{
+			Message: parsed.(*rfc5424.SyslogMessage),
+			Data:    map[string]interface{}{"@cf": map[string]interface{}{"app": "parsed-app"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		push := lokiPush{}
+		Expect(json.Unmarshal(server.Bodies()[0], &push)).To(Succeed())
+		Expect(push.Streams).To(HaveLen(1))
+		Expect(push.Streams[0].Stream).To(HaveKeyWithValue("app", "parsed-app"))
+		Expect(push.Streams[0].Values[0][1]).To(HavePrefix(`{"@cf"`))
+	})
+
+	It("should push batch in snappy compressed protobuf by default", func() {
+		sysAddr.URLs = []string{lokiURL("loki")}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		writeBoth(w)
+
+		Expect(server.ContentTypes()).To(Equal([]string{"application/x-protobuf"}))
+		decoded, err := snappy.Decode(nil, server.Bodies()[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(decoded)).To(ContainSubstring(`{app="app", cluster_name="paris", deployment="production", env="prod", org="org", space="space"}`))
+		Expect(string(decoded)).To(ContainSubstring(`"@message":"bye"`))
+	})
+})
@@ -0,0 +1,215 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	gosyslog "github.com/influxdata/go-syslog/v3"
+	"github.com/influxdata/go-syslog/v3/rfc5424"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/parser"
+	"github.com/orange-cloudfoundry/logs-service-broker/tpl"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// defaultSeverity - informational severity used when message can't be parsed
+const defaultSeverity = 6
+
+// Parsed -
+// message built by parser with its json message already decoded, given by forwarder along with message bytes
+// for writers not parsing them back. Writers share it and must not modify it.
+type Parsed struct {
+	Message *rfc5424.SyslogMessage
+	Data    map[string]interface{}
+	// Labels - labels of message in forwarder metrics, async writers count their delivery failures with them
+	Labels map[string]string
+}
+
+// logMessage - rfc 5424 message built by parser as received by writers
+type logMessage struct {
+	timestamp time.Time
+	hostname  string
+	severity  int
+	params    map[string]string
+	body      string
+	data      map[string]interface{}
+	parsed    *rfc5424.SyslogMessage
+	// formatted - message in output format, empty for default format
+	formatted string
+}
+
+func newMessageParser() gosyslog.Machine {
+	return rfc5424.NewParser(rfc5424.WithBestEffort())
+}
+
+// readLogMessage - message from its parsed form when given, message is parsed back otherwise, e.g. on spool replay
+func readLogMessage(parser gosyslog.Machine, b []byte, parsed *Parsed) *logMessage {
+	if parsed == nil || parsed.Message == nil || parsed.Message.Message == nil {
+		return parseLogMessage(parser, b)
+	}
+	return newLogMessageWithData(parsed.Message, parsed.Data)
+}
+
+// parseLogMessage -
+// read back message built by parser, structured data of all elements are merged in params
+// and json message is decoded in data. Raw message is used as body when it can't be parsed.
+func parseLogMessage(parser gosyslog.Machine, b []byte) *logMessage {
+	parsedRaw, _ := parser.Parse(b)
+	parsed, _ := parsedRaw.(*rfc5424.SyslogMessage)
+	msg := newLogMessage(parsed)
+	if parsed == nil || parsed.Message == nil {
+		msg.body = strings.TrimRight(string(b), "\n")
+	}
+	return msg
+}
+
+// newLogMessage - same as parseLogMessage from an already parsed message, nil gives an empty message
+func newLogMessage(parsed *rfc5424.SyslogMessage) *logMessage {
+	return newLogMessageWithData(parsed, nil)
+}
+
+// newLogMessageWithData - same as newLogMessage with json message already decoded, it is decoded when data is nil
+func newLogMessageWithData(parsed *rfc5424.SyslogMessage, data map[string]interface{}) *logMessage {
+	msg := &logMessage{
+		timestamp: time.Now(),
+		severity:  defaultSeverity,
+		params:    make(map[string]string),
+	}
+	if parsed == nil {
+		return msg
+	}
+	msg.parsed = parsed
+	if parsed.Timestamp != nil {
+		msg.timestamp = *parsed.Timestamp
+	}
+	if parsed.Hostname != nil {
+		msg.hostname = *parsed.Hostname
+	}
+	if parsed.Severity != nil {
+		msg.severity = int(*parsed.Severity)
+	}
+	if parsed.StructuredData != nil {
+		for _, params := range *parsed.StructuredData {
+			for k, v := range params {
+				msg.params[k] = v
+			}
+		}
+	}
+	if parsed.Message != nil {
+		msg.body = strings.TrimRight(*parsed.Message, "\n")
+		msg.data = data
+		if data == nil {
+			data = make(map[string]interface{})
+			if err := json.Unmarshal([]byte(msg.body), &data); err == nil {
+				msg.data = data
+			}
+		}
+	}
+	return msg
+}
+
+// format - render message with output encoder, nothing is done when default format is used
+func (m *logMessage) format(enc Encoder) {
+	if enc == nil || m.parsed == nil {
+		return
+	}
+	b, err := m.encode(enc)
+	if err != nil {
+		log.Warnf("using rfc 5424 message, encoding in output format failed: %s", err.Error())
+		return
+	}
+	m.formatted = strings.TrimRight(string(b), "\n")
+}
+
+// encode - render message with output encoder, built-in encoders use data of message instead of decoding it again
+func (m *logMessage) encode(enc Encoder) ([]byte, error) {
+	if me, ok := enc.(messageEncoder); ok {
+		return me(m)
+	}
+	return enc.Encode(m.parsed)
+}
+
+// line - message as a single line, in output format when one is set
+func (m *logMessage) line() string {
+	return valueOr(m.formatted, m.body)
+}
+
+// cf - string value of `@cf` field in data, `cloudfoundry` field is used for logs mapped on ecs
+func (m *logMessage) cf(key string) string {
+	cf, _ := m.data["@cf"].(map[string]interface{})
+	if v, ok := cf[key].(string); ok {
+		return v
+	}
+	kind, field, _ := strings.Cut(key, "_")
+	v, _ := utils.FoundVarDelim(m.data, "cloudfoundry."+kind+"."+valueOr(field, "name")).(string)
+	return v
+}
+
+// source - value of `@source` field in data, `cloudfoundry.log.source` and `labels` are used for logs mapped on ecs
+func (m *logMessage) source(key string) (string, bool) {
+	source, _ := m.data["@source"].(map[string]interface{})
+	v, ok := source[key]
+	if !ok {
+		v = utils.FoundVarDelim(m.data, "cloudfoundry.log.source."+key)
+		if v == nil {
+			v = utils.FoundVarDelim(m.data, "labels."+key)
+		}
+	}
+	if v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// document -
+// copy of data of message, or message in output format when it is a json object.
+// Message is placed in `@message` when it is not json.
+func (m *logMessage) document() map[string]interface{} {
+	if m.formatted != "" {
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(m.formatted), &doc); err == nil {
+			return doc
+		}
+		return map[string]interface{}{
+			"@message":   m.formatted,
+			"@timestamp": m.timestamp.Format(time.RFC3339Nano),
+		}
+	}
+	if m.data != nil {
+		doc := make(map[string]interface{}, len(m.data))
+		for k, v := range m.data {
+			doc[k] = v
+		}
+		return doc
+	}
+	return map[string]interface{}{
+		"@message":   m.body,
+		"@timestamp": m.timestamp.Format(time.RFC3339Nano),
+	}
+}
+
+// render - run templating on given entries with data of message, as done for tags
+func (m *logMessage) render(entries map[string]string) (map[string]string, error) {
+	return tpl.NewTemplater(parser.TemplateData{
+		Org:     m.cf("org"),
+		OrgID:   m.cf("org_id"),
+		Space:   m.cf("space"),
+		SpaceID: m.cf("space_id"),
+		App:     m.cf("app"),
+		AppID:   m.cf("app_id"),
+		Logdata: m.data,
+	}).Execute(entries)
+}
+
+// toHTTPScheme - turn `name` scheme into http and `name+https` scheme into https
+func toHTTPScheme(u *url.URL) {
+	if strings.HasSuffix(u.Scheme, "+https") {
+		u.Scheme = "https"
+		return
+	}
+	u.Scheme = "http"
+}
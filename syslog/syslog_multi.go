@@ -24,6 +24,11 @@ func MultiDial(addresses ...string) (*MultiWriter, error) {
 }
 
 func (t *MultiWriter) Write(b []byte) (int, error) {
+	return t.WriteKey("", b, nil)
+}
+
+// WriteKey - write message to all writers, key and parsed message are given to those supporting it
+func (t *MultiWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
 	var wg sync.WaitGroup
 	mutex := &sync.Mutex{}
 	wg.Add(len(t.mw))
@@ -31,7 +36,7 @@ func (t *MultiWriter) Write(b []byte) (int, error) {
 	for _, w := range t.mw {
 		go func(w io.WriteCloser) {
 			defer wg.Done()
-			_, err := w.Write(b)
+			_, err := WriteWithKey(w, key, b, parsed)
 			if err != nil {
 				mutex.Lock()
 				result = multierror.Append(result, err)
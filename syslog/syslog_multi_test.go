@@ -31,7 +31,7 @@ var _ = Describe("SyslogMulti", func() {
 		// nolint:errcheck
 		syslogClient.Write([]byte("my content"))
 
-		Eventually(server1.BufferResp.String).Should(Equal("my content"))
-		Eventually(server2.BufferResp.String).Should(Equal("my content"))
+		Eventually(server1.BufferResp.String).Should(Equal("10 my content"))
+		Eventually(server2.BufferResp.String).Should(Equal("10 my content"))
 	})
 })
@@ -0,0 +1,444 @@
+package syslog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+)
+
+const (
+	OTLPFormatProtobuf = "protobuf"
+	OTLPFormatJSON     = "json"
+	otlpLogsPath       = "/v1/logs"
+	otlpScopeName      = "logs-service-broker"
+)
+
+// otlpResourceAttributes - resource attributes taken from `@cf` data
+var otlpResourceAttributes = [][2]string{
+	{"cloudfoundry.org.id", "org_id"},
+	{"cloudfoundry.org.name", "org"},
+	{"cloudfoundry.space.id", "space_id"},
+	{"cloudfoundry.space.name", "space"},
+	{"cloudfoundry.app.id", "app_id"},
+	{"cloudfoundry.app.name", "app"},
+	{"service.name", "app"},
+}
+
+// otlpSeverities - severity number of `@level` values, see otel log data model
+var otlpSeverities = map[string]int{
+	"TRACE":    1,
+	"DEBUG":    5,
+	"INFO":     9,
+	"WARN":     13,
+	"WARNING":  13,
+	"ERROR":    17,
+	"CRITICAL": 21,
+	"FATAL":    21,
+}
+
+type otlpRecord struct {
+	timeUnixNano     int64
+	observedUnixNano int64
+	severityNumber   int
+	severityText     string
+	body             string
+	attributes       [][2]string
+	traceID          []byte
+	spanID           []byte
+}
+
+type otlpResource struct {
+	attributes [][2]string
+	records    []otlpRecord
+}
+
+// otlpEncoder - build otlp export logs requests from parsed data of messages
+type otlpEncoder struct {
+	label      string
+	tagKeys    []string
+	attributes [][2]string
+	output     Encoder
+}
+
+// OTLPDial -
+// 1. convert otlp url to http(s) one targeting logs export when no path is given
+// 2. create http writer sending logs as otlp log records, http options of syslog address apply
+// 3. retry only status codes allowed by otlp specification
+func OTLPDial(addr string, sysAddr *model.SyslogAddress) (*HttpWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	toHTTPScheme(u)
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	query := u.Query()
+	format := valueOr(query.Get(QueryFormat), sysAddr.OTLP.Format)
+	query.Del(QueryFormat)
+	u.RawQuery = query.Encode()
+
+	// 2.
+	httpConfig := sysAddr.HTTP
+	httpConfig.Format = ""
+	httpConfig.ContentType = ""
+	w, err := HttpDialWithConfig(u.String(), &httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	output, err := outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	enc := &otlpEncoder{
+		label:   w.label,
+		tagKeys: mapKeys(sysAddr.Tags),
+		output:  output,
+	}
+	for _, k := range mapKeys(sysAddr.OTLP.ResourceAttributes) {
+		enc.attributes = append(enc.attributes, [2]string{k, sysAddr.OTLP.ResourceAttributes[k]})
+	}
+	switch format {
+	case "", OTLPFormatProtobuf:
+		w.encode = enc.encodeProtobuf
+		w.handleResponse = enc.handleProtobufResponse
+		w.contentType = "application/x-protobuf"
+	case OTLPFormatJSON:
+		w.encode = enc.encodeJSON
+		w.handleResponse = enc.handleJSONResponse
+		w.contentType = "application/json"
+	default:
+		return nil, fmt.Errorf("unknown otlp format '%s', only `%s` or `%s` are allowed", format, OTLPFormatProtobuf, OTLPFormatJSON)
+	}
+
+	// 3.
+	w.retryStatus = otlpRetryableStatus
+	return w, nil
+}
+
+// otlpRetryableStatus - status codes which can be retried according to otlp/http specification
+func otlpRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// resources - group log records by resource attributes, keeping order of first appearance
+func (e *otlpEncoder) resources(messages []httpMessage) []*otlpResource {
+	p := newMessageParser()
+	index := make(map[string]*otlpResource)
+	resources := make([]*otlpResource, 0)
+	now := time.Now().UnixNano()
+	for _, message := range messages {
+		msg := readLogMessage(p, message.b, message.parsed)
+		msg.format(e.output)
+		attributes := e.resourceAttributes(msg)
+		key := fmt.Sprint(attributes)
+		resource, ok := index[key]
+		if !ok {
+			resource = &otlpResource{attributes: attributes}
+			index[key] = resource
+			resources = append(resources, resource)
+		}
+		record := e.record(msg)
+		record.observedUnixNano = now
+		resource.records = append(resource.records, record)
+	}
+	return resources
+}
+
+func (e *otlpEncoder) resourceAttributes(msg *logMessage) [][2]string {
+	attributes := append([][2]string{}, e.attributes...)
+	for _, attr := range otlpResourceAttributes {
+		if v := msg.cf(attr[1]); v != "" {
+			attributes = append(attributes, [2]string{attr[0], v})
+		}
+	}
+	cf, _ := msg.data["@cf"].(map[string]interface{})
+	if instance, ok := cf["app_instance"]; ok && instance != nil {
+		attributes = append(attributes, [2]string{"cloudfoundry.app.instance.id", fmt.Sprint(instance)})
+	}
+	return attributes
+}
+
+// record -
+// `@timestamp` gives time, `@level` severity, `@message` body and tags attributes,
+// whole json message is used as body when there is no `@message`, message in output format is used when one is set.
+// Dotted fields set by otel schema become attributes and `trace_id` and `span_id` give trace context.
+func (e *otlpEncoder) record(msg *logMessage) otlpRecord {
+	record := otlpRecord{
+		timeUnixNano: msg.timestamp.UnixNano(),
+		body:         msg.body,
+	}
+	if raw, ok := msg.data["@timestamp"].(string); ok {
+		if ts, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			record.timeUnixNano = ts.UnixNano()
+		}
+	}
+	if level, ok := msg.data["@level"].(string); ok {
+		record.severityText = level
+		record.severityNumber = otlpSeverities[strings.ToUpper(level)]
+	}
+	if message, ok := msg.data["@message"].(string); ok {
+		record.body = message
+	}
+	if msg.formatted != "" {
+		record.body = msg.formatted
+	}
+	for _, key := range e.tagKeys {
+		if v := msg.params[key]; v != "" {
+			record.attributes = append(record.attributes, [2]string{key, v})
+		}
+	}
+	keys := make([]string, 0)
+	for k, v := range msg.data {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			continue
+		}
+		if strings.Contains(k, ".") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		record.attributes = append(record.attributes, [2]string{k, fmt.Sprint(msg.data[k])})
+	}
+	record.traceID = otlpID(msg.data["trace_id"], 16)
+	record.spanID = otlpID(msg.data["span_id"], 8)
+	return record
+}
+
+// otlpID - trace or span id decoded from hex, nil when value is not an id of given size
+func otlpID(v interface{}, size int) []byte {
+	s, _ := v.(string)
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) != size {
+		return nil
+	}
+	return id
+}
+
+// encodeProtobuf - ExportLogsServiceRequest as defined in opentelemetry-proto
+func (e *otlpEncoder) encodeProtobuf(messages []httpMessage) ([]byte, error) {
+	var req []byte
+	for _, resource := range e.resources(messages) {
+		var rb []byte
+		for _, attr := range resource.attributes {
+			rb = protowire.AppendTag(rb, 1, protowire.BytesType)
+			rb = protowire.AppendBytes(rb, otlpProtoKeyValue(attr))
+		}
+
+		var scope []byte
+		scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+		scope = protowire.AppendString(scope, otlpScopeName)
+		var sl []byte
+		sl = protowire.AppendTag(sl, 1, protowire.BytesType)
+		sl = protowire.AppendBytes(sl, scope)
+		for _, record := range resource.records {
+			sl = protowire.AppendTag(sl, 2, protowire.BytesType)
+			sl = protowire.AppendBytes(sl, otlpProtoRecord(record))
+		}
+
+		var rl []byte
+		rl = protowire.AppendTag(rl, 1, protowire.BytesType)
+		rl = protowire.AppendBytes(rl, rb)
+		rl = protowire.AppendTag(rl, 2, protowire.BytesType)
+		rl = protowire.AppendBytes(rl, sl)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, rl)
+	}
+	return req, nil
+}
+
+func otlpProtoRecord(record otlpRecord) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(record.timeUnixNano))
+	if record.severityNumber > 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(record.severityNumber))
+	}
+	if record.severityText != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, record.severityText)
+	}
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, otlpProtoString(record.body))
+	for _, attr := range record.attributes {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, otlpProtoKeyValue(attr))
+	}
+	if record.traceID != nil {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, record.traceID)
+	}
+	if record.spanID != nil {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendBytes(b, record.spanID)
+	}
+	b = protowire.AppendTag(b, 11, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(record.observedUnixNano))
+	return b
+}
+
+func otlpProtoKeyValue(attr [2]string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, attr[0])
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, otlpProtoString(attr[1]))
+	return b
+}
+
+// otlpProtoString - AnyValue holding a string
+func otlpProtoString(v string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+type otlpJSONValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string        `json:"key"`
+	Value otlpJSONValue `json:"value"`
+}
+
+func otlpJSONAttributes(attributes [][2]string) []otlpJSONKeyValue {
+	kvs := make([]otlpJSONKeyValue, len(attributes))
+	for i, attr := range attributes {
+		kvs[i] = otlpJSONKeyValue{Key: attr[0], Value: otlpJSONValue{StringValue: attr[1]}}
+	}
+	return kvs
+}
+
+// encodeJSON - ExportLogsServiceRequest in otlp json encoding
+func (e *otlpEncoder) encodeJSON(messages []httpMessage) ([]byte, error) {
+	type jsonRecord struct {
+		TimeUnixNano         string             `json:"timeUnixNano"`
+		ObservedTimeUnixNano string             `json:"observedTimeUnixNano"`
+		SeverityNumber       int                `json:"severityNumber,omitempty"`
+		SeverityText         string             `json:"severityText,omitempty"`
+		Body                 otlpJSONValue      `json:"body"`
+		Attributes           []otlpJSONKeyValue `json:"attributes,omitempty"`
+		TraceID              string             `json:"traceId,omitempty"`
+		SpanID               string             `json:"spanId,omitempty"`
+	}
+	type jsonScopeLogs struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		LogRecords []jsonRecord `json:"logRecords"`
+	}
+	type jsonResourceLogs struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []jsonScopeLogs `json:"scopeLogs"`
+	}
+	req := struct {
+		ResourceLogs []jsonResourceLogs `json:"resourceLogs"`
+	}{}
+	for _, resource := range e.resources(messages) {
+		sl := jsonScopeLogs{}
+		sl.Scope.Name = otlpScopeName
+		for _, record := range resource.records {
+			sl.LogRecords = append(sl.LogRecords, jsonRecord{
+				TimeUnixNano:         strconv.FormatInt(record.timeUnixNano, 10),
+				ObservedTimeUnixNano: strconv.FormatInt(record.observedUnixNano, 10),
+				SeverityNumber:       record.severityNumber,
+				SeverityText:         record.severityText,
+				Body:                 otlpJSONValue{StringValue: record.body},
+				Attributes:           otlpJSONAttributes(record.attributes),
+				TraceID:              hex.EncodeToString(record.traceID),
+				SpanID:               hex.EncodeToString(record.spanID),
+			})
+		}
+		rl := jsonResourceLogs{ScopeLogs: []jsonScopeLogs{sl}}
+		rl.Resource.Attributes = otlpJSONAttributes(resource.attributes)
+		req.ResourceLogs = append(req.ResourceLogs, rl)
+	}
+	return json.Marshal(req)
+}
+
+// handleProtobufResponse - read partial success of ExportLogsServiceResponse
+func (e *otlpEncoder) handleProtobufResponse(_ []httpMessage, body []byte) ([]httpMessage, error) {
+	partial := otlpProtoField(body, 1)
+	if partial == nil {
+		return nil, nil
+	}
+	var rejected int64
+	if raw := otlpProtoField(partial, 1); raw != nil {
+		v, _ := protowire.ConsumeVarint(raw)
+		rejected = int64(v)
+	}
+	e.logRejected(rejected, string(otlpProtoField(partial, 2)))
+	return nil, nil
+}
+
+// otlpProtoField - value of first field with given number, varint fields are given in their wire format
+func otlpProtoField(b []byte, number protowire.Number) []byte {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return nil
+		}
+		if num == number {
+			if typ == protowire.BytesType {
+				v, _ := protowire.ConsumeBytes(b)
+				return v
+			}
+			return b[:m]
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+// handleJSONResponse - read partial success of ExportLogsServiceResponse in json encoding
+func (e *otlpEncoder) handleJSONResponse(_ []httpMessage, body []byte) ([]httpMessage, error) {
+	resp := struct {
+		PartialSuccess struct {
+			RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+			ErrorMessage       string      `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}{}
+	if len(body) == 0 || json.Unmarshal(body, &resp) != nil {
+		return nil, nil
+	}
+	rejected, _ := resp.PartialSuccess.RejectedLogRecords.Int64()
+	e.logRejected(rejected, resp.PartialSuccess.ErrorMessage)
+	return nil, nil
+}
+
+// logRejected - records refused in a partial success must not be retried, they are only reported
+func (e *otlpEncoder) logRejected(rejected int64, message string) {
+	if rejected <= 0 && message == "" {
+		return
+	}
+	log.Warnf("otlp '%s': %d log records rejected: %s", e.label, rejected, message)
+}
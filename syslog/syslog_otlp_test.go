@@ -0,0 +1,158 @@
+package syslog_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpExport struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []struct {
+				TimeUnixNano   string `json:"timeUnixNano"`
+				SeverityNumber int    `json:"severityNumber"`
+				SeverityText   string `json:"severityText"`
+				Body           struct {
+					StringValue string `json:"stringValue"`
+				} `json:"body"`
+				Attributes []otlpKeyValue `json:"attributes"`
+				TraceID    string         `json:"traceId"`
+				SpanID     string         `json:"spanId"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+var _ = Describe("OTLPWriter", func() {
+	var server *recordingServer
+	var sysAddr *model.SyslogAddress
+
+	var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [APP/PROC/WEB/0] - [logsbroker@1368 env="prod"] {"@cf":{"app":"app","app_id":"3","app_instance":0,"org":"org","org_id":"1","space":"space","space_id":"2"},"@level":"ERROR","@message":"hello","@timestamp":"2006-01-02T15:04:05.5Z"}` + "\n"
+
+	BeforeEach(func() {
+		server = newRecordingServer(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"0"}}`))
+		})
+		sysAddr = &model.SyslogAddress{
+			Name: "loghost",
+			Tags: map[string]string{"env": "prod"},
+			HTTP: model.HTTPOutputConfig{MaxRetries: 2},
+			OTLP: model.OTLPConfig{ResourceAttributes: map[string]string{"deployment.environment": "production"}},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	otlpURL := func(scheme string) string {
+		return strings.Replace(server.URL, "http", scheme, 1)
+	}
+
+	It("should map parsed data on otlp log record in json", func() {
+		w, err := syslog.OTLPDial(otlpURL("otlp+http")+"?format=json", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(server.Paths()).To(Equal([]string{"/v1/logs"}))
+		Expect(server.ContentTypes()).To(Equal([]string{"application/json"}))
+		export := otlpExport{}
+		Expect(json.Unmarshal(server.Bodies()[0], &export)).To(Succeed())
+		Expect(export.ResourceLogs).To(HaveLen(1))
+
+		resourceAttributes := make(map[string]string)
+		for _, kv := range export.ResourceLogs[0].Resource.Attributes {
+			resourceAttributes[kv.Key] = kv.Value.StringValue
+		}
+		Expect(resourceAttributes).To(Equal(map[string]string{
+			"deployment.environment":       "production",
+			"cloudfoundry.org.id":          "1",
+			"cloudfoundry.org.name":        "org",
+			"cloudfoundry.space.id":        "2",
+			"cloudfoundry.space.name":      "space",
+			"cloudfoundry.app.id":          "3",
+			"cloudfoundry.app.name":        "app",
+			"cloudfoundry.app.instance.id": "0",
+			"service.name":                 "app",
+		}))
+
+		record := export.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		Expect(record.TimeUnixNano).To(Equal("1136214245500000000"))
+		Expect(record.SeverityNumber).To(Equal(17))
+		Expect(record.SeverityText).To(Equal("ERROR"))
+		Expect(record.Body.StringValue).To(Equal("hello"))
+		Expect(record.Attributes).To(HaveLen(1))
+		Expect(record.Attributes[0].Key).To(Equal("env"))
+		Expect(record.Attributes[0].Value.StringValue).To(Equal("prod"))
+	})
+
+	It("should give otel attributes and trace context of router logs", func() {
+		w, err := syslog.OTLPDial(otlpURL("otlp+http")+"?format=json", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(`<14>1 2006-01-02T15:04:05Z org.space.app - [RTR/0] - - {"@message":"200 GET /","http.request.method":"GET",` +
+			`"http.response.status_code":200,"rtr":{"app_index":0},"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7"}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		export := otlpExport{}
+		Expect(json.Unmarshal(server.Bodies()[0], &export)).To(Succeed())
+		record := export.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		Expect(record.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(record.SpanID).To(Equal("00f067aa0ba902b7"))
+		attributes := make(map[string]string)
+		for _, kv := range record.Attributes {
+			attributes[kv.Key] = kv.Value.StringValue
+		}
+		Expect(attributes).To(Equal(map[string]string{
+			"http.request.method":       "GET",
+			"http.response.status_code": "200",
+		}))
+	})
+
+	It("should send protobuf by default", func() {
+		sysAddr.URLs = []string{otlpURL("otlp+http")}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(server.ContentTypes()).To(Equal([]string{"application/x-protobuf"}))
+		Expect(string(server.Bodies()[0])).To(ContainSubstring("cloudfoundry.app.name"))
+		Expect(string(server.Bodies()[0])).To(ContainSubstring("hello"))
+	})
+
+	It("should retry on status allowed by otlp specification only", func() {
+		server.SetStatuses(http.StatusServiceUnavailable, http.StatusInternalServerError)
+		w, err := syslog.OTLPDial(otlpURL("otlp+http"), sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).To(HaveOccurred())
+		Expect(server.Bodies()).To(HaveLen(2))
+	})
+})
@@ -14,17 +14,22 @@ import (
 	"time"
 )
 
+const (
+	QueryVerify = "verify"
+	QueryCert   = "cert"
+)
+
 type Writer struct {
-	hostname           string
-	network            string
-	raddr              string
-	conn               serverConn
-	mu                 sync.Mutex // guards conn
-	tlsConf            *tls.Config
-	inTls              bool
-	nbConnTry          int
-	muTry              sync.Mutex
-	hasBeenReconnected bool
+	hostname string
+	network  string
+	raddr    string
+	conn     serverConn
+	gen      int        // incremented on each new connection
+	mu       sync.Mutex // guards conn and gen
+	tlsConf  *tls.Config
+	inTls    bool
+	framing  Framing
+	breaker  *circuitBreaker
 }
 
 type serverConn interface {
@@ -74,6 +79,14 @@ func Dial(addr string) (*Writer, error) {
 	if err != nil {
 		return nil, err
 	}
+	framing, err := ParseFraming(u.Query().Get(QueryFraming))
+	if err != nil {
+		return nil, err
+	}
+	breaker, err := newCircuitBreakerFromAddr(u)
+	if err != nil {
+		return nil, err
+	}
 
 	hostname, _ := os.Hostname()
 
@@ -83,9 +96,11 @@ func Dial(addr string) (*Writer, error) {
 		raddr:    u.Host,
 		tlsConf:  tlsConf,
 		inTls:    inTls,
+		framing:  framing,
+		breaker:  breaker,
 	}
 
-	err = w.connect()
+	err = w.connect(0)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +110,7 @@ func Dial(addr string) (*Writer, error) {
 func tlsConfigFromAddr(u *url.URL) (*tls.Config, error) {
 	tlsConf := &tls.Config{}
 
-	verifyParam := u.Query().Get("verify")
+	verifyParam := u.Query().Get(QueryVerify)
 	if verifyParam != "" {
 		verify, err := strconv.ParseBool(verifyParam)
 		if err != nil {
@@ -104,7 +119,7 @@ func tlsConfigFromAddr(u *url.URL) (*tls.Config, error) {
 		tlsConf.InsecureSkipVerify = !verify
 	}
 
-	certPath := u.Query().Get("cert")
+	certPath := u.Query().Get(QueryCert)
 	if certPath == "" {
 		return tlsConf, nil
 	}
@@ -119,31 +134,25 @@ func tlsConfigFromAddr(u *url.URL) (*tls.Config, error) {
 	return tlsConf, nil
 }
 
-// connect makes a connection to the syslog server.
-// It must be called with w.mu held.
-func (w *Writer) connect() (err error) {
+// connect -
+// 1. skip when another goroutine has already reconnected since given connection generation
+// 2. fail fast when circuit breaker is open, checked after 1. as a half-open probe must report its result
+// 3. dial and report result to circuit breaker
+func (w *Writer) connect(gen int) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	w.muTry.Lock()
-	if w.nbConnTry >= 20 {
-		w.muTry.Unlock()
-		return fmt.Errorf("20 connect tries has been already made, erroring for preferring drop instead of server locking cause a lot of goroutine")
+	// 1.
+	if w.conn != nil && w.gen != gen {
+		return nil
 	}
-	w.nbConnTry += 1
-	w.muTry.Unlock()
 
-	if w.nbConnTry > 0 && w.hasBeenReconnected {
-		w.muTry.Lock()
-		// if last connect try we remove
-		if w.nbConnTry == 1 {
-			w.hasBeenReconnected = false
-		}
-		w.nbConnTry -= 1
-		w.muTry.Unlock()
-		return nil
+	// 2.
+	if err := w.breaker.allow(); err != nil {
+		return fmt.Errorf("%s: %w", w.raddr, err)
 	}
 
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	// 3.
 	if w.conn != nil {
 		// ignore err from close, it makes sense to continue anyway
 		err := w.conn.close()
@@ -160,39 +169,54 @@ func (w *Writer) connect() (err error) {
 		c, err = tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, w.network, w.raddr, w.tlsConf)
 	}
 	if err != nil {
-		w.muTry.Lock()
-		w.nbConnTry -= 1
-		w.muTry.Unlock()
+		w.breaker.failure()
 		return err
 	}
+	w.breaker.success()
 
 	w.conn = &netConn{conn: c}
+	w.gen++
 	if w.hostname == "" {
 		w.hostname = c.LocalAddr().String()
 	}
-	w.muTry.Lock()
-	w.hasBeenReconnected = true
-	w.nbConnTry -= 1
-	w.muTry.Unlock()
-
 	return nil
 }
 
+func (w *Writer) currentConn() (serverConn, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn, w.gen
+}
+
 func (w *Writer) writeAndRetry(s string) (int, error) {
-	if w.conn != nil {
-		if n, err := w.write(s); err == nil {
+	conn, gen := w.currentConn()
+	if conn != nil {
+		if n, err := w.write(conn, s); err == nil {
 			return n, err
 		}
 	}
-	if err := w.connect(); err != nil {
+	if err := w.connect(gen); err != nil {
 		return 0, err
 	}
 
-	return w.write(s)
+	conn, _ = w.currentConn()
+	n, err := w.write(conn, s)
+	if err != nil {
+		w.breaker.failure()
+	}
+	return n, err
 }
 
-func (w *Writer) write(msg string) (int, error) {
-	err := w.conn.writeString(msg)
+func (w *Writer) write(conn serverConn, msg string) (int, error) {
+	if conn == nil {
+		return 0, fmt.Errorf("%s: connection closed", w.raddr)
+	}
+	frame := msg
+	// datagram transport delimits messages by itself
+	if w.network != "udp" {
+		frame = w.framing.Frame(msg)
+	}
+	err := conn.writeString(frame)
 	if err != nil {
 		return 0, err
 	}
@@ -202,6 +226,11 @@ func (w *Writer) write(msg string) (int, error) {
 	return len(msg), nil
 }
 
+// BreakerState - state of circuit breaker guarding connection to syslog server
+func (w *Writer) BreakerState() BreakerState {
+	return w.breaker.State()
+}
+
 // Write sends a log message to the syslog daemon.
 func (w *Writer) Write(b []byte) (int, error) {
 	return w.writeAndRetry(string(b))
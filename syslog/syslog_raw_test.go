@@ -29,7 +29,48 @@ var _ = Describe("SyslogRaw", func() {
 		It("should pass to server the content", func() {
 			// nolint:errcheck
 			syslogClient.Write([]byte("my content"))
-			Eventually(server.BufferResp.String).Should(Equal("my content"))
+			Eventually(server.BufferResp.String).Should(Equal("10 my content"))
+		})
+		When("set in octet counting framing", func() {
+			It("should pass to server the content prefixed by its length", func() {
+				var err error
+				syslogClient, err = syslog.NewWriter(server.URL + "?framing=octet-counting")
+				Expect(err).ToNot(HaveOccurred())
+
+				// nolint:errcheck
+				syslogClient.Write([]byte("my content"))
+				Eventually(server.BufferResp.String).Should(Equal("10 my content"))
+			})
+		})
+		When("set in no framing", func() {
+			It("should pass to server the content as is", func() {
+				var err error
+				syslogClient, err = syslog.NewWriter(server.URL + "?framing=none")
+				Expect(err).ToNot(HaveOccurred())
+
+				// nolint:errcheck
+				syslogClient.Write([]byte("my content"))
+				Eventually(server.BufferResp.String).Should(Equal("my content"))
+			})
+		})
+		When("set in non-transparent framing", func() {
+			It("should pass to server the content terminated by a line feed", func() {
+				var err error
+				syslogClient, err = syslog.NewWriter(server.URL + "?framing=non-transparent")
+				Expect(err).ToNot(HaveOccurred())
+
+				// nolint:errcheck
+				syslogClient.Write([]byte("my content"))
+				// nolint:errcheck
+				syslogClient.Write([]byte("my json content\n"))
+				Eventually(server.BufferResp.String).Should(Equal("my content\nmy json content\n"))
+			})
+		})
+		When("set in an unknown framing", func() {
+			It("should return an error", func() {
+				_, err := syslog.NewWriter(server.URL + "?framing=unknown")
+				Expect(err).To(HaveOccurred())
+			})
 		})
 	})
 
@@ -95,7 +136,7 @@ var _ = Describe("SyslogRaw", func() {
 		It("should pass to server the content", func() {
 			// nolint:errcheck
 			syslogClient.Write([]byte("my content"))
-			Eventually(server.BufferResp.String).Should(Equal("my content"))
+			Eventually(server.BufferResp.String).Should(Equal("10 my content"))
 		})
 	})
 })
@@ -0,0 +1,208 @@
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	QueryToken        = "token"
+	QuerySourceType   = "sourcetype"
+	QueryAck          = "ack"
+	QueryChannel      = "channel"
+	splunkEventPath   = "/services/collector/event"
+	splunkChannelKey  = "X-Splunk-Request-Channel"
+	splunkDefaultType = "cloudfoundry"
+)
+
+type splunkEvent struct {
+	Time       json.Number `json:"time"`
+	Host       string      `json:"host,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	SourceType string      `json:"sourcetype,omitempty"`
+	Index      string      `json:"index,omitempty"`
+	Event      interface{} `json:"event"`
+}
+
+// splunkEncoder - build hec requests from parsed data of messages and wait for acknowledgement when enabled
+type splunkEncoder struct {
+	w           *HttpWriter
+	fields      map[string]string
+	ackURL      string
+	ackTimeout  time.Duration
+	ackInterval time.Duration
+	output      Encoder
+}
+
+// SplunkDial -
+// 1. convert splunk url to http(s) one targeting hec event endpoint when no path is given
+// 2. create http writer sending parsed data of messages as hec events, http options of syslog address apply
+// 3. when acknowledgement is enabled, send requests on a channel and wait for their ack before reporting success
+func SplunkDial(addr string, sysAddr *model.SyslogAddress) (*HttpWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.
+	toHTTPScheme(u)
+	if u.Path == "" || u.Path == "/" {
+		u.Path = splunkEventPath
+	}
+	config := sysAddr.Splunk
+	query := u.Query()
+	token := valueOr(query.Get(QueryToken), config.Token)
+	channel := valueOr(query.Get(QueryChannel), config.Channel)
+	ack := config.Ack
+	if raw := query.Get(QueryAck); raw != "" {
+		ack, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid param '%s': %s", QueryAck, err.Error())
+		}
+	}
+	enc := &splunkEncoder{
+		fields: map[string]string{
+			"index":      valueOr(query.Get(QueryIndex), config.Index),
+			"sourcetype": valueOr(query.Get(QuerySourceType), valueOr(config.SourceType, splunkDefaultType)),
+			"source":     config.Source,
+			"host":       config.Host,
+		},
+		ackTimeout:  config.GetAckTimeout(),
+		ackInterval: config.GetAckInterval(),
+	}
+	enc.output, err = outputEncoder(sysAddr.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	for _, param := range []string{QueryToken, QueryChannel, QueryAck, QueryIndex, QuerySourceType} {
+		query.Del(param)
+	}
+	u.RawQuery = query.Encode()
+
+	// 2.
+	httpConfig := sysAddr.HTTP
+	httpConfig.Format = ""
+	httpConfig.ContentType = ""
+	w, err := HttpDialWithConfig(u.String(), &httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		w.headers.Set("Authorization", "Splunk "+token)
+	}
+	enc.w = w
+	w.encode = enc.encode
+	w.contentType = "application/json"
+
+	// 3.
+	if ack || channel != "" {
+		w.headers.Set(splunkChannelKey, valueOr(channel, uuid.NewString()))
+	}
+	if ack {
+		u.Path = path.Join(path.Dir(u.Path), "ack")
+		enc.ackURL = u.String()
+		w.handleResponse = enc.waitAck
+	}
+	return w, nil
+}
+
+// encode - concatenate one hec event per message
+func (e *splunkEncoder) encode(messages []httpMessage) ([]byte, error) {
+	p := newMessageParser()
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, message := range messages {
+		msg := readLogMessage(p, message.b, message.parsed)
+		msg.format(e.output)
+		fields, err := msg.render(e.fields)
+		if err != nil {
+			log.Warnf("splunk '%s': using default fields, templating failed: %s", e.w.label, err.Error())
+			fields = map[string]string{"sourcetype": splunkDefaultType}
+		}
+		event := splunkEvent{
+			Time:       json.Number(fmt.Sprintf("%d.%03d", msg.timestamp.Unix(), msg.timestamp.Nanosecond()/int(time.Millisecond))),
+			Host:       valueOr(fields["host"], msg.hostname),
+			Source:     fields["source"],
+			SourceType: fields["sourcetype"],
+			Index:      fields["index"],
+			Event:      msg.document(),
+		}
+		if err := enc.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// waitAck -
+// query ack endpoint until events are indexed, events are given back for being sent again
+// when they are not acknowledged before timeout
+func (e *splunkEncoder) waitAck(messages []httpMessage, body []byte) ([]httpMessage, error) {
+	resp := struct {
+		AckID *int64 `json:"ackId"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid hec response: %s", err.Error())
+	}
+	if resp.AckID == nil {
+		return nil, fmt.Errorf("no ack id in hec response, indexer acknowledgement may be disabled on token")
+	}
+
+	deadline := time.Now().Add(e.ackTimeout)
+	for {
+		acked, err := e.queryAck(*resp.AckID)
+		if err != nil {
+			log.Warnf("splunk '%s': querying ack %d failed: %s", e.w.label, *resp.AckID, err.Error())
+		}
+		if acked {
+			return nil, nil
+		}
+		if time.Now().Add(e.ackInterval).After(deadline) {
+			log.Warnf("splunk '%s': ack %d not received after %s, sending events again", e.w.label, *resp.AckID, e.ackTimeout)
+			return messages, nil
+		}
+		time.Sleep(e.ackInterval)
+	}
+}
+
+func (e *splunkEncoder) queryAck(ackID int64) (bool, error) {
+	body, err := json.Marshal(map[string][]int64{"acks": {ackID}})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("POST", e.ackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = e.w.headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.w.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer utils.CloseAndLogError(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, &httpStatusError{code: resp.StatusCode, body: string(b)}
+	}
+	result := struct {
+		Acks map[string]bool `json:"acks"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Acks[strconv.FormatInt(ackID, 10)], nil
+}
@@ -0,0 +1,135 @@
+package syslog_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+type hecEvent struct {
+	Time       json.Number            `json:"time"`
+	Host       string                 `json:"host"`
+	SourceType string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+var _ = Describe("SplunkWriter", func() {
+	var server *httptest.Server
+	var mu sync.Mutex
+	var events []hecEvent
+	var headers []http.Header
+	var ackQueries int
+	// ackAfter - number of ack queries answered as not yet indexed
+	var ackAfter int
+	var sysAddr *model.SyslogAddress
+
+	var message = `<14>1 2006-01-02T15:04:05.999999Z org.space.app - [APP/PROC/WEB/0] - - {"@cf":{"app":"app","org":"org","space":"space"},"@message":"hello"}` + "\n"
+
+	BeforeEach(func() {
+		events, headers, ackQueries, ackAfter = nil, nil, 0, 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.URL.Path {
+			case "/services/collector/event":
+				headers = append(headers, r.Header)
+				dec := json.NewDecoder(r.Body)
+				for dec.More() {
+					event := hecEvent{}
+					Expect(dec.Decode(&event)).To(Succeed())
+					events = append(events, event)
+				}
+				fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, len(headers))
+			case "/services/collector/ack":
+				b, _ := io.ReadAll(r.Body)
+				Expect(r.Header.Get("X-Splunk-Request-Channel")).ToNot(BeEmpty())
+				ackQueries++
+				fmt.Fprintf(w, `{"acks":{"%d":%t}}`, len(headers), ackQueries > ackAfter)
+				Expect(string(b)).To(Equal(fmt.Sprintf(`{"acks":[%d]}`, len(headers))))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		sysAddr = &model.SyslogAddress{
+			Name: "loghost",
+			HTTP: model.HTTPOutputConfig{MaxRetries: 1},
+			Splunk: model.SplunkConfig{
+				Token:       "my-token",
+				Index:       "cf_{{ .Org }}",
+				SourceType:  "cf:{{ .App }}",
+				AckInterval: "10ms",
+				AckTimeout:  "50ms",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	splunkURL := func() string {
+		return strings.Replace(server.URL, "http", "splunk", 1)
+	}
+
+	getEvents := func() []hecEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]hecEvent{}, events...)
+	}
+
+	It("should send parsed data as hec event with templated fields", func() {
+		sysAddr.URLs = []string{splunkURL()}
+		w, err := syslog.NewStrategyWriter(sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(getEvents()).To(HaveLen(1))
+		event := getEvents()[0]
+		Expect(event.Time.String()).To(Equal("1136214245.999"))
+		Expect(event.Host).To(Equal("org.space.app"))
+		Expect(event.Index).To(Equal("cf_org"))
+		Expect(event.SourceType).To(Equal("cf:app"))
+		Expect(event.Event).To(HaveKeyWithValue("@message", "hello"))
+		Expect(headers[0].Get("Authorization")).To(Equal("Splunk my-token"))
+		Expect(headers[0].Get("X-Splunk-Request-Channel")).To(BeEmpty())
+	})
+
+	It("should wait for events to be acknowledged", func() {
+		ackAfter = 2
+		w, err := syslog.SplunkDial(splunkURL()+"?ack=true", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ackQueries).To(Equal(3))
+		Expect(getEvents()).To(HaveLen(1))
+	})
+
+	It("should send events again when they are not acknowledged in time", func() {
+		ackAfter = 100
+		w, err := syslog.SplunkDial(splunkURL()+"?ack=true&channel=my-channel", sysAddr)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(w)
+
+		_, err = w.Write([]byte(message))
+		Expect(err).To(HaveOccurred())
+		Expect(getEvents()).To(HaveLen(2))
+		Expect(headers[1].Get("X-Splunk-Request-Channel")).To(Equal("my-channel"))
+	})
+})
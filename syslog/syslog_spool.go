@@ -0,0 +1,532 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+const (
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "cursor"
+	spoolHeaderSize  = 18
+	spoolReplayBatch = 100
+	spoolMaxRecord   = 64 * 1024 * 1024
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+type spoolSegment struct {
+	seq  int64
+	size int64
+}
+
+type spoolRecord struct {
+	seq       int64
+	end       int64
+	timestamp time.Time
+	key       string
+	payload   []byte
+}
+
+// size - size of record in segment
+func (r spoolRecord) size() int64 {
+	return int64(spoolHeaderSize + len(r.key) + len(r.payload))
+}
+
+// SpoolWriter -
+// Write-ahead buffer on disk in front of a writer.
+// Messages which failed to be written are appended to segment files and replayed in order
+// once the writer is able to write again. While spool is not empty every new message is appended
+// to it to keep ordering. Routing key of messages is kept for replaying them with it.
+//
+// Record format in segment: <size uint32><crc32 uint32><timestamp unix nano int64><key size uint16><key><payload>,
+// size is the one of payload and crc32 is computed on key and payload.
+type SpoolWriter struct {
+	w           io.WriteCloser
+	name        string
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	maxAge      time.Duration
+	interval    time.Duration
+
+	mu         sync.Mutex // guards fields below
+	segments   []spoolSegment
+	active     *os.File
+	size       int64
+	readOffset int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpoolWriter -
+// 1. create spool directory for the given name
+// 2. load existing segments and cursor from a previous run
+// 3. run background replay
+func NewSpoolWriter(w io.WriteCloser, name string, config *model.SpoolConfig) (*SpoolWriter, error) {
+	s := &SpoolWriter{
+		w:           w,
+		name:        name,
+		dir:         filepath.Join(config.Dir, name),
+		maxSize:     config.GetMaxSize(),
+		segmentSize: config.GetSegmentSize(),
+		maxAge:      *config.GetMaxAge(),
+		interval:    *config.GetReplayInterval(),
+		done:        make(chan struct{}),
+	}
+
+	// 1.
+	err := os.MkdirAll(s.dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.
+	s.wg.Add(1)
+	go s.replayLoop()
+	return s, nil
+}
+
+// Write -
+// write directly to underlying writer when nothing is waiting in spool, append to spool otherwise
+// or when underlying writer fails.
+func (s *SpoolWriter) Write(b []byte) (int, error) {
+	return s.WriteKey("", b, nil)
+}
+
+// WriteKey -
+// same as Write, key is given to underlying writer for routing and kept in spool for replay,
+// parsed message is only given to underlying writer, replayed messages are parsed back by writers
+func (s *SpoolWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	var result error
+	if !s.Pending() {
+		n, err := WriteWithKey(s.w, key, b, parsed)
+		if err == nil {
+			return n, nil
+		}
+		result = multierror.Append(result, err)
+	}
+	err := s.append(key, b)
+	if err != nil {
+		return 0, multierror.Append(result, err)
+	}
+	metrics.LogsSpooled.WithLabelValues(s.name).Inc()
+	return len(b), nil
+}
+
+// Pending - true when spool contains messages not yet replayed
+func (s *SpoolWriter) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending()
+}
+
+// Replay - replay messages in spool until spool is empty or underlying writer fails
+func (s *SpoolWriter) Replay() {
+	s.dropExpiredSegments()
+	defer s.saveCursorAndLog()
+	for {
+		records, err := s.next(spoolReplayBatch)
+		if err != nil {
+			log.Errorf("spool '%s': failed to read segment: %s", s.name, err.Error())
+			return
+		}
+		if len(records) == 0 {
+			return
+		}
+		for _, record := range records {
+			if s.maxAge > 0 && time.Since(record.timestamp) > s.maxAge {
+				metrics.LogsSpoolDropped.WithLabelValues(s.name, "age").Inc()
+				s.ack(record)
+				continue
+			}
+			_, err := WriteWithKey(s.w, record.key, record.payload, nil)
+			if err != nil {
+				return
+			}
+			metrics.LogsSpoolReplayed.WithLabelValues(s.name).Inc()
+			s.ack(record)
+		}
+	}
+}
+
+// Close - stop replaying, persist cursor and close underlying writer
+func (s *SpoolWriter) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	var result error
+	s.mu.Lock()
+	if err := s.saveCursor(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if s.active != nil {
+		if err := s.active.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		s.active = nil
+	}
+	s.mu.Unlock()
+
+	if err := s.w.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result
+}
+
+func (s *SpoolWriter) replayLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.Pending() {
+				s.Replay()
+			}
+		}
+	}
+}
+
+// load -
+// 1. found segments files ordered by sequence
+// 2. truncate last segment to last valid record, a crash may have left a partial record
+// 3. open last segment for appending
+// 4. restore read position from cursor
+func (s *SpoolWriter) load() error {
+	// 1.
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, spoolSegment{seq: seq, size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	if len(s.segments) == 0 {
+		s.updateSizeMetric()
+		return nil
+	}
+
+	// 2.
+	last := &s.segments[len(s.segments)-1]
+	validSize, _ := s.scanSegment(last.seq)
+	if validSize != last.size {
+		log.Warnf("spool '%s': truncating segment %d to %d bytes after invalid record", s.name, last.seq, validSize)
+		err = os.Truncate(s.segmentPath(last.seq), validSize)
+		if err != nil {
+			return err
+		}
+		s.size -= last.size - validSize
+		last.size = validSize
+	}
+
+	// 3.
+	s.active, err = os.OpenFile(s.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	// 4.
+	b, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err == nil {
+		var seq, offset int64
+		_, err = fmt.Sscanf(string(b), "%d %d", &seq, &offset)
+		if err == nil && seq == s.segments[0].seq && offset <= s.segments[0].size {
+			s.readOffset = offset
+		}
+	}
+	s.updateSizeMetric()
+	return nil
+}
+
+func (s *SpoolWriter) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// pending must be called with s.mu held.
+func (s *SpoolWriter) pending() bool {
+	if len(s.segments) == 0 {
+		return false
+	}
+	return len(s.segments) > 1 || s.readOffset < s.segments[0].size
+}
+
+// append -
+// 1. roll to a new segment when active one is full
+// 2. make room by removing oldest segments when max size is reached
+// 3. append record to active segment
+func (s *SpoolWriter) append(key string, b []byte) error {
+	if len(key) > math.MaxUint16 {
+		key = ""
+	}
+	record := make([]byte, spoolHeaderSize+len(key)+len(b))
+	copy(record[spoolHeaderSize:], key)
+	copy(record[spoolHeaderSize+len(key):], b)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[spoolHeaderSize:]))
+	binary.BigEndian.PutUint64(record[8:16], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(record[16:18], uint16(len(key)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 1.
+	if s.active == nil || s.segments[len(s.segments)-1].size >= s.segmentSize {
+		err := s.roll()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 2.
+	for s.size+int64(len(record)) > s.maxSize {
+		if len(s.segments) <= 1 {
+			metrics.LogsSpoolDropped.WithLabelValues(s.name, "size").Inc()
+			return ErrSpoolFull
+		}
+		_, count := s.scanSegment(s.segments[0].seq)
+		metrics.LogsSpoolDropped.WithLabelValues(s.name, "size").Add(float64(count))
+		s.removeFirstSegment()
+	}
+
+	// 3.
+	n, err := s.active.Write(record)
+	last := &s.segments[len(s.segments)-1]
+	last.size += int64(n)
+	s.size += int64(n)
+	s.updateSizeMetric()
+	return err
+}
+
+// roll must be called with s.mu held.
+func (s *SpoolWriter) roll() error {
+	if s.active != nil {
+		err := s.active.Close()
+		if err != nil {
+			return err
+		}
+		s.active = nil
+	}
+	seq := int64(1)
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, spoolSegment{seq: seq})
+	return nil
+}
+
+// removeFirstSegment must be called with s.mu held.
+func (s *SpoolWriter) removeFirstSegment() {
+	first := s.segments[0]
+	if len(s.segments) == 1 && s.active != nil {
+		if err := s.active.Close(); err != nil {
+			log.Warnf("spool '%s': failed to close segment %d: %s", s.name, first.seq, err.Error())
+		}
+		s.active = nil
+	}
+	if err := os.Remove(s.segmentPath(first.seq)); err != nil {
+		log.Warnf("spool '%s': failed to remove segment %d: %s", s.name, first.seq, err.Error())
+	}
+	s.segments = s.segments[1:]
+	s.size -= first.size
+	s.readOffset = 0
+	s.updateSizeMetric()
+}
+
+// next - read at most max records from the current read position
+func (s *SpoolWriter) next(max int) ([]spoolRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 && s.readOffset >= s.segments[0].size {
+		// fully consumed active segment is removed only when something else has been written in
+		if len(s.segments) == 1 && s.segments[0].size < s.segmentSize {
+			return nil, nil
+		}
+		s.removeFirstSegment()
+	}
+	if len(s.segments) == 0 {
+		return nil, nil
+	}
+
+	first := s.segments[0]
+	f, err := os.Open(s.segmentPath(first.seq))
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogError(f)
+	_, err = f.Seek(s.readOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]spoolRecord, 0)
+	offset := s.readOffset
+	reader := bufio.NewReader(io.LimitReader(f, first.size-s.readOffset))
+	for len(records) < max && offset < first.size {
+		record, err := readSpoolRecord(reader)
+		if err != nil {
+			// corrupted data, skipping rest of the segment
+			log.Warnf("spool '%s': skipping end of segment %d: %s", s.name, first.seq, err.Error())
+			s.readOffset = first.size
+			break
+		}
+		offset += record.size()
+		record.seq = first.seq
+		record.end = offset
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ack - move read position after the given record
+func (s *SpoolWriter) ack(record spoolRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 || s.segments[0].seq != record.seq {
+		return
+	}
+	s.readOffset = record.end
+	if s.readOffset >= s.segments[0].size && len(s.segments) > 1 {
+		s.removeFirstSegment()
+	}
+}
+
+// dropExpiredSegments - remove segments, except the active one, which were last written before max age
+func (s *SpoolWriter) dropExpiredSegments() {
+	if s.maxAge <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.segments) > 1 {
+		info, err := os.Stat(s.segmentPath(s.segments[0].seq))
+		if err != nil || time.Since(info.ModTime()) <= s.maxAge {
+			return
+		}
+		_, count := s.scanSegment(s.segments[0].seq)
+		metrics.LogsSpoolDropped.WithLabelValues(s.name, "age").Add(float64(count))
+		s.removeFirstSegment()
+	}
+}
+
+// scanSegment - give size until last valid record and number of valid records in a segment
+func (s *SpoolWriter) scanSegment(seq int64) (int64, int) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return 0, 0
+	}
+	defer utils.CloseAndLogError(f)
+	reader := bufio.NewReader(f)
+	var size int64
+	count := 0
+	for {
+		record, err := readSpoolRecord(reader)
+		if err != nil {
+			return size, count
+		}
+		size += record.size()
+		count++
+	}
+}
+
+// saveCursor must be called with s.mu held.
+func (s *SpoolWriter) saveCursor() error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	if len(s.segments) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	content := fmt.Sprintf("%d %d", s.segments[0].seq, s.readOffset)
+	return os.WriteFile(path, []byte(content), 0640)
+}
+
+func (s *SpoolWriter) saveCursorAndLog() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveCursor(); err != nil {
+		log.Errorf("spool '%s': failed to save cursor: %s", s.name, err.Error())
+	}
+}
+
+// updateSizeMetric must be called with s.mu held.
+func (s *SpoolWriter) updateSizeMetric() {
+	metrics.SpoolSize.WithLabelValues(s.name).Set(float64(s.size))
+}
+
+func readSpoolRecord(r io.Reader) (spoolRecord, error) {
+	header := make([]byte, spoolHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return spoolRecord{}, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > spoolMaxRecord {
+		return spoolRecord{}, fmt.Errorf("invalid record size %d", size)
+	}
+	keySize := int(binary.BigEndian.Uint16(header[16:18]))
+	content := make([]byte, keySize+int(size))
+	_, err = io.ReadFull(r, content)
+	if err != nil {
+		return spoolRecord{}, err
+	}
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(header[4:8]) {
+		return spoolRecord{}, fmt.Errorf("invalid checksum")
+	}
+	return spoolRecord{
+		timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16]))),
+		key:       string(content[:keySize]),
+		payload:   content[keySize:],
+	}, nil
+}
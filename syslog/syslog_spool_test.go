@@ -0,0 +1,181 @@
+package syslog_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+type toggleWriter struct {
+	mu       sync.Mutex
+	failing  bool
+	messages []string
+	keys     []string
+}
+
+func (t *toggleWriter) Write(b []byte) (int, error) {
+	return t.WriteKey("", b, nil)
+}
+
+func (t *toggleWriter) WriteKey(key string, b []byte, _ *syslog.Parsed) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failing {
+		return 0, fmt.Errorf("destination unavailable")
+	}
+	t.messages = append(t.messages, string(b))
+	t.keys = append(t.keys, key)
+	return len(b), nil
+}
+
+func (t *toggleWriter) Close() error {
+	return nil
+}
+
+func (t *toggleWriter) SetFailing(failing bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failing = failing
+}
+
+func (t *toggleWriter) Keys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.keys...)
+}
+
+func (t *toggleWriter) Messages() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.messages...)
+}
+
+var _ = Describe("SyslogSpool", func() {
+	var dir string
+	var target *toggleWriter
+	var config *model.SpoolConfig
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "spool")
+		Expect(err).ToNot(HaveOccurred())
+		target = &toggleWriter{}
+		config = &model.SpoolConfig{
+			Dir:            dir,
+			ReplayInterval: "10ms",
+		}
+	})
+
+	AfterEach(func() {
+		utils.RemoveDir(dir)
+	})
+
+	It("should write directly to destination when it is healthy", func() {
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+
+		_, err = spoolWriter.Write([]byte("my content"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(target.Messages()).To(Equal([]string{"my content"}))
+		Expect(spoolWriter.Pending()).To(BeFalse())
+	})
+
+	It("should replay in order failed messages once destination is healthy again", func() {
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+
+		target.SetFailing(true)
+		for i := 0; i < 3; i++ {
+			_, err = spoolWriter.Write([]byte(fmt.Sprintf("message %d", i)))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(spoolWriter.Pending()).To(BeTrue())
+		target.SetFailing(false)
+
+		Eventually(target.Messages).Should(Equal([]string{"message 0", "message 1", "message 2"}))
+		Eventually(spoolWriter.Pending).Should(BeFalse())
+	})
+
+	It("should replay messages spooled by a previous run", func() {
+		config.ReplayInterval = "1h"
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		target.SetFailing(true)
+		_, err = spoolWriter.Write([]byte("message 0"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = spoolWriter.Write([]byte("message 1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spoolWriter.Close()).To(Succeed())
+
+		target.SetFailing(false)
+		spoolWriter, err = syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+		Expect(spoolWriter.Pending()).To(BeTrue())
+
+		spoolWriter.Replay()
+		Expect(target.Messages()).To(Equal([]string{"message 0", "message 1"}))
+		Expect(spoolWriter.Pending()).To(BeFalse())
+	})
+
+	It("should replay messages with their routing key", func() {
+		config.ReplayInterval = "1h"
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+
+		target.SetFailing(true)
+		_, err = spoolWriter.WriteKey("binding-1", []byte("message 0"), nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = spoolWriter.Write([]byte("message 1"))
+		Expect(err).ToNot(HaveOccurred())
+		target.SetFailing(false)
+
+		spoolWriter.Replay()
+		Expect(target.Messages()).To(Equal([]string{"message 0", "message 1"}))
+		Expect(target.Keys()).To(Equal([]string{"binding-1", ""}))
+	})
+
+	It("should drop oldest messages when max size is reached", func() {
+		config.ReplayInterval = "1h"
+		config.MaxSizeMB = 1
+		config.SegmentSizeMB = 1
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+
+		target.SetFailing(true)
+		content := strings.Repeat("a", 600*1024)
+		_, err = spoolWriter.Write([]byte(content))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = spoolWriter.Write([]byte(content))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should drop messages older than max age", func() {
+		config.ReplayInterval = "1h"
+		config.MaxAge = "1ns"
+		spoolWriter, err := syslog.NewSpoolWriter(target, "loghost", config)
+		Expect(err).ToNot(HaveOccurred())
+		defer utils.CloseAndLogError(spoolWriter)
+
+		target.SetFailing(true)
+		_, err = spoolWriter.Write([]byte("too old"))
+		Expect(err).ToNot(HaveOccurred())
+		target.SetFailing(false)
+
+		spoolWriter.Replay()
+		Expect(target.Messages()).To(BeEmpty())
+		Expect(spoolWriter.Pending()).To(BeFalse())
+	})
+})
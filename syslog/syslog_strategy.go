@@ -0,0 +1,316 @@
+package syslog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/metrics"
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// KeyWriter - writer able to route a message according to a key, e.g. a binding id,
+// parsed form of message is given when known for not parsing message back
+type KeyWriter interface {
+	WriteKey(key string, b []byte, parsed *Parsed) (int, error)
+}
+
+// WriteWithKey - route message with key and its parsed form when writer supports it, simply write it otherwise
+func WriteWithKey(w io.Writer, key string, b []byte, parsed *Parsed) (int, error) {
+	if kw, ok := w.(KeyWriter); ok && (key != "" || parsed != nil) {
+		return kw.WriteKey(key, b, parsed)
+	}
+	return w.Write(b)
+}
+
+// NewStrategyWriter -
+// create a writer for each address and deliver messages to them according to strategy mode:
+// - fanout: every message is sent to all endpoints
+// - failover: message is sent to the first healthy endpoint in given order
+// - loadbalance: message is sent to a single healthy endpoint chosen by round-robin or by hashing key
+//
+// When spool is enabled, each endpoint has its own spool in fanout mode for not holding back healthy endpoints,
+// otherwise a single spool keeps messages which could be sent to none of the endpoints.
+// Endpoints already dialed are closed when writer can't be created.
+func NewStrategyWriter(sysAddr *model.SyslogAddress) (io.WriteCloser, error) {
+	if len(sysAddr.URLs) == 0 {
+		return nil, fmt.Errorf("one address must be given")
+	}
+	config := &sysAddr.Strategy
+	endpoints := make([]*endpoint, len(sysAddr.URLs))
+	for i, addr := range sysAddr.URLs {
+		w, err := dialAddress(addr, sysAddr)
+		if err != nil {
+			for _, e := range endpoints[:i] {
+				utils.CloseAndLogError(e)
+			}
+			return nil, err
+		}
+		endpoints[i] = newEndpoint(sysAddr.Name, addr, w)
+	}
+
+	switch config.GetMode() {
+	case model.StrategyFailover:
+		return withSpool(&FailoverWriter{
+			endpoints:     endpoints,
+			retryInterval: *config.GetRetryInterval(),
+		}, sysAddr.Name, sysAddr)
+	case model.StrategyLoadBalance:
+		return withSpool(&LoadBalanceWriter{
+			endpoints:     endpoints,
+			retryInterval: *config.GetRetryInterval(),
+			hash:          config.GetBalance() == model.BalanceHash,
+		}, sysAddr.Name, sysAddr)
+	}
+	if len(endpoints) == 1 {
+		return withSpool(endpoints[0], sysAddr.Name, sysAddr)
+	}
+	mw := make([]io.WriteCloser, len(endpoints))
+	for i, e := range endpoints {
+		w, err := withSpool(e, fmt.Sprintf("%s/%d", sysAddr.Name, i+1), sysAddr)
+		if err != nil {
+			utils.CloseAndLogError(&MultiWriter{mw[:i]})
+			for _, e := range endpoints[i+1:] {
+				utils.CloseAndLogError(e)
+			}
+			return nil, err
+		}
+		mw[i] = w
+	}
+	return &MultiWriter{mw}, nil
+}
+
+// withSpool - wrap writer with a spool stored under given name when spool is enabled on syslog address,
+// writer is closed when spool can't be created
+func withSpool(w io.WriteCloser, name string, sysAddr *model.SyslogAddress) (io.WriteCloser, error) {
+	if !sysAddr.Spool.Enabled() {
+		return w, nil
+	}
+	s, err := NewSpoolWriter(w, name, &sysAddr.Spool)
+	if err != nil {
+		utils.CloseAndLogError(w)
+		return nil, err
+	}
+	return s, nil
+}
+
+// dialAddress - create writer for given url using output options of syslog address
+func dialAddress(addr string, sysAddr *model.SyslogAddress) (io.WriteCloser, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		w, err := HttpDialWithConfig(addr, &sysAddr.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		return withOutputFormat(w, sysAddr.OutputFormat)
+	case "loki", "loki+https":
+		return LokiDial(addr, sysAddr)
+	case "elasticsearch", "elasticsearch+https":
+		return ElasticDial(addr, sysAddr)
+	case "otlp+http", "otlp+https":
+		return OTLPDial(addr, sysAddr)
+	case "splunk", "splunk+https":
+		return SplunkDial(addr, sysAddr)
+	case "gelf+udp", "gelf+tcp", "gelf+tls", "gelf+http", "gelf+https":
+		return GelfDial(addr, sysAddr)
+	case "kafka", "kafka+tls":
+		return KafkaDial(addr, sysAddr)
+	case "file":
+		return FileDial(addr, sysAddr)
+	}
+	w, err := Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return withOutputFormat(w, sysAddr.OutputFormat)
+}
+
+// withOutputFormat - wrap writer sending messages as given when an output format other than rfc5424-json is set
+func withOutputFormat(w io.WriteCloser, format string) (io.WriteCloser, error) {
+	enc, err := outputEncoder(format)
+	if err != nil {
+		utils.CloseAndLogError(w)
+		return nil, err
+	}
+	if enc == nil {
+		return w, nil
+	}
+	return NewEncodingWriter(w, enc), nil
+}
+
+// endpoint - writer recording health of its destination
+type endpoint struct {
+	io.WriteCloser
+	name        string
+	label       string
+	mu          sync.Mutex // guards fields below
+	healthy     bool
+	lastFailure time.Time
+}
+
+func newEndpoint(name, addr string, w io.WriteCloser) *endpoint {
+	e := &endpoint{
+		WriteCloser: w,
+		name:        name,
+		label:       endpointLabel(addr),
+		healthy:     true,
+	}
+	metrics.EndpointUp.WithLabelValues(e.name, e.label).Set(1)
+	return e
+}
+
+// endpointLabel - address without credentials and params for being used as metric label
+func endpointLabel(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+func (e *endpoint) Write(b []byte) (int, error) {
+	return e.WriteKey("", b, nil)
+}
+
+// WriteKey - give key to underlying writer, e.g. for choosing a kafka partition
+func (e *endpoint) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	n, err := WriteWithKey(e.WriteCloser, key, b, parsed)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.healthy = false
+		e.lastFailure = time.Now()
+		metrics.EndpointErrors.WithLabelValues(e.name, e.label).Inc()
+		metrics.EndpointUp.WithLabelValues(e.name, e.label).Set(0)
+		return n, err
+	}
+	if !e.healthy {
+		e.healthy = true
+		metrics.EndpointUp.WithLabelValues(e.name, e.label).Set(1)
+	}
+	return n, nil
+}
+
+// available - true when endpoint is healthy or when it is time to give it a new try
+func (e *endpoint) available(retryInterval time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy || time.Since(e.lastFailure) >= retryInterval
+}
+
+// writeFirst -
+// write to first available endpoint in given order, falling back on next ones on failure.
+// When none are available, all are tried anyway instead of dropping message.
+func writeFirst(endpoints []*endpoint, retryInterval time.Duration, key string, b []byte, parsed *Parsed) (int, error) {
+	var result error
+	tried := make([]bool, len(endpoints))
+	for i, e := range endpoints {
+		if !e.available(retryInterval) {
+			continue
+		}
+		tried[i] = true
+		n, err := e.WriteKey(key, b, parsed)
+		if err == nil {
+			return n, nil
+		}
+		result = multierror.Append(result, err)
+	}
+	for i, e := range endpoints {
+		if tried[i] {
+			continue
+		}
+		n, err := e.WriteKey(key, b, parsed)
+		if err == nil {
+			return n, nil
+		}
+		result = multierror.Append(result, err)
+	}
+	return 0, result
+}
+
+func closeEndpoints(endpoints []*endpoint) error {
+	var result error
+	for _, e := range endpoints {
+		if err := e.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// FailoverWriter -
+// active/passive delivery, messages go to the first healthy endpoint in configured order.
+// A failed endpoint is tried again after retry interval, which gives fail-back to primary endpoint.
+type FailoverWriter struct {
+	endpoints     []*endpoint
+	retryInterval time.Duration
+}
+
+func (f *FailoverWriter) Write(b []byte) (int, error) {
+	return f.WriteKey("", b, nil)
+}
+
+func (f *FailoverWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	return writeFirst(f.endpoints, f.retryInterval, key, b, parsed)
+}
+
+func (f *FailoverWriter) Close() error {
+	return closeEndpoints(f.endpoints)
+}
+
+// LoadBalanceWriter -
+// messages are spread over healthy endpoints, by round-robin or by rendezvous hashing on key
+// which keeps messages with same key on same endpoint as long as it is healthy
+type LoadBalanceWriter struct {
+	endpoints     []*endpoint
+	retryInterval time.Duration
+	hash          bool
+	next          atomic.Uint64
+}
+
+func (l *LoadBalanceWriter) Write(b []byte) (int, error) {
+	return l.WriteKey("", b, nil)
+}
+
+func (l *LoadBalanceWriter) WriteKey(key string, b []byte, parsed *Parsed) (int, error) {
+	if !l.hash || key == "" {
+		start := int(l.next.Add(1) % uint64(len(l.endpoints)))
+		ordered := append(append([]*endpoint{}, l.endpoints[start:]...), l.endpoints[:start]...)
+		return writeFirst(ordered, l.retryInterval, key, b, parsed)
+	}
+	return writeFirst(l.rendezvous(key), l.retryInterval, key, b, parsed)
+}
+
+// rendezvous - endpoints ordered by descending score of hash(key, endpoint)
+func (l *LoadBalanceWriter) rendezvous(key string) []*endpoint {
+	scores := make(map[*endpoint]uint64, len(l.endpoints))
+	for _, e := range l.endpoints {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte(e.label))
+		scores[e] = h.Sum64()
+	}
+	ordered := append([]*endpoint{}, l.endpoints...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+	return ordered
+}
+
+func (l *LoadBalanceWriter) Close() error {
+	return closeEndpoints(l.endpoints)
+}
@@ -0,0 +1,267 @@
+package syslog_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/logs-service-broker/model"
+	"github.com/orange-cloudfoundry/logs-service-broker/syslog"
+	"github.com/orange-cloudfoundry/logs-service-broker/utils"
+)
+
+// toggleServer - http endpoint which can be set unhealthy
+type toggleServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failing  bool
+	messages []string
+}
+
+func newToggleServer() *toggleServer {
+	t := &toggleServer{}
+	t.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		t.messages = append(t.messages, string(b))
+	}))
+	return t
+}
+
+func (t *toggleServer) SetFailing(failing bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failing = failing
+}
+
+func (t *toggleServer) Messages() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.messages...)
+}
+
+// acceptClosing - accept connections on listener and count those closed by writer
+func acceptClosing(l net.Listener) *atomic.Int32 {
+	closed := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				closed.Add(1)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return closed
+}
+
+var _ = Describe("SyslogStrategy", func() {
+	var primary *toggleServer
+	var standby *toggleServer
+	var sysAddr *model.SyslogAddress
+
+	BeforeEach(func() {
+		primary = newToggleServer()
+		standby = newToggleServer()
+		sysAddr = &model.SyslogAddress{
+			Name:     "loghost",
+			URLs:     []string{primary.URL, standby.URL},
+			Strategy: model.StrategyConfig{RetryInterval: "0s"},
+		}
+	})
+
+	AfterEach(func() {
+		primary.Close()
+		standby.Close()
+	})
+
+	Context("Fanout with spool", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "spool")
+			Expect(err).ToNot(HaveOccurred())
+			sysAddr.Spool = model.SpoolConfig{Dir: dir, ReplayInterval: "10ms"}
+		})
+
+		AfterEach(func() {
+			utils.RemoveDir(dir)
+		})
+
+		It("should spool for unhealthy endpoint only", func() {
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			standby.SetFailing(true)
+			for i := 0; i < 3; i++ {
+				_, err = w.Write([]byte("message"))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(primary.Messages()).To(HaveLen(3))
+			Expect(standby.Messages()).To(BeEmpty())
+
+			standby.SetFailing(false)
+			Eventually(standby.Messages).Should(HaveLen(3))
+			Consistently(primary.Messages, 50*time.Millisecond).Should(HaveLen(3))
+			Expect(filepath.Join(dir, "loghost", "2")).To(BeADirectory())
+		})
+	})
+
+	Context("Failover", func() {
+		BeforeEach(func() {
+			sysAddr.Strategy.Mode = model.StrategyFailover
+		})
+
+		It("should send to primary and fallback on standby when primary is unhealthy", func() {
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			_, err = w.Write([]byte("message 0"))
+			Expect(err).ToNot(HaveOccurred())
+			primary.SetFailing(true)
+			_, err = w.Write([]byte("message 1"))
+			Expect(err).ToNot(HaveOccurred())
+			primary.SetFailing(false)
+			_, err = w.Write([]byte("message 2"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(primary.Messages()).To(Equal([]string{"message 0", "message 2"}))
+			Expect(standby.Messages()).To(Equal([]string{"message 1"}))
+		})
+
+		It("should not retry unhealthy primary before retry interval", func() {
+			sysAddr.Strategy.RetryInterval = "1h"
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			primary.SetFailing(true)
+			_, err = w.Write([]byte("message 0"))
+			Expect(err).ToNot(HaveOccurred())
+			primary.SetFailing(false)
+			_, err = w.Write([]byte("message 1"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(primary.Messages()).To(BeEmpty())
+			Expect(standby.Messages()).To(Equal([]string{"message 0", "message 1"}))
+		})
+
+		It("should error when all endpoints are unhealthy", func() {
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			primary.SetFailing(true)
+			standby.SetFailing(true)
+			_, err = w.Write([]byte("message 0"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Loadbalance", func() {
+		BeforeEach(func() {
+			sysAddr.Strategy.Mode = model.StrategyLoadBalance
+		})
+
+		It("should spread messages with round-robin", func() {
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			for i := 0; i < 4; i++ {
+				_, err = w.Write([]byte("message"))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(primary.Messages()).To(HaveLen(2))
+			Expect(standby.Messages()).To(HaveLen(2))
+		})
+
+		It("should keep messages with same key on same endpoint when hashing", func() {
+			sysAddr.Strategy.Balance = model.BalanceHash
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			for i := 0; i < 4; i++ {
+				_, err = syslog.WriteWithKey(w, "my-binding", []byte("message"), nil)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(len(primary.Messages()) + len(standby.Messages())).To(Equal(4))
+			Expect([]int{len(primary.Messages()), len(standby.Messages())}).To(ContainElement(4))
+		})
+
+		It("should skip unhealthy endpoint", func() {
+			w, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer utils.CloseAndLogError(w)
+
+			standby.SetFailing(true)
+			for i := 0; i < 4; i++ {
+				_, err = w.Write([]byte("message"))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(primary.Messages()).To(HaveLen(4))
+		})
+	})
+
+	Context("When writer can't be created", func() {
+		var l net.Listener
+		var closed *atomic.Int32
+
+		BeforeEach(func() {
+			var err error
+			l, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			closed = acceptClosing(l)
+		})
+
+		AfterEach(func() {
+			_ = l.Close()
+		})
+
+		It("should close endpoints already dialed when an address can't be dialed", func() {
+			sysAddr.URLs = []string{
+				"tcp://" + l.Addr().String(),
+				"tcp://" + l.Addr().String(),
+				"tcp://" + l.Addr().String() + "?framing=unknown",
+			}
+			_, err := syslog.NewStrategyWriter(sysAddr)
+			Expect(err).To(HaveOccurred())
+			Eventually(closed.Load).Should(BeEquivalentTo(2))
+		})
+
+		It("should close endpoints when spool can't be created", func() {
+			f, err := os.CreateTemp("", "spool")
+			Expect(err).ToNot(HaveOccurred())
+			defer func() { _ = os.Remove(f.Name()) }()
+			utils.CloseAndLogError(f)
+
+			sysAddr.URLs = []string{"tcp://" + l.Addr().String(), "tcp://" + l.Addr().String()}
+			sysAddr.Spool = model.SpoolConfig{Dir: f.Name()}
+			_, err = syslog.NewStrategyWriter(sysAddr)
+			Expect(err).To(HaveOccurred())
+			Eventually(closed.Load).Should(BeEquivalentTo(2))
+		})
+	})
+})
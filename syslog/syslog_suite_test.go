@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -171,3 +172,83 @@ func (s *Server) Close() {
 		Expect(err).ToNot(HaveOccurred())
 	}
 }
+
+// recordedRequest - what http collector has received
+type recordedRequest struct {
+	path   string
+	header http.Header
+	query  string
+	body   string
+}
+
+// recordingServer -
+// http collector recording requests received, it answers with statuses queued by test first
+// and with given response once none is left
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []recordedRequest
+	statuses []int
+}
+
+func newRecordingServer(respond http.HandlerFunc) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, recordedRequest{
+			path:   r.URL.Path,
+			header: r.Header,
+			query:  r.URL.RawQuery,
+			body:   string(b),
+		})
+		if len(s.statuses) > 0 {
+			w.Header().Set("Location", "/elsewhere")
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+			return
+		}
+		if respond != nil {
+			respond(w, r)
+		}
+	}))
+	return s
+}
+
+// SetStatuses - statuses answered to next requests
+func (s *recordingServer) SetStatuses(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = statuses
+}
+
+func (s *recordingServer) Requests() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest{}, s.requests...)
+}
+
+func (s *recordingServer) Paths() []string {
+	var paths []string
+	for _, r := range s.Requests() {
+		paths = append(paths, r.path)
+	}
+	return paths
+}
+
+func (s *recordingServer) ContentTypes() []string {
+	var contentTypes []string
+	for _, r := range s.Requests() {
+		contentTypes = append(contentTypes, r.header.Get("Content-Type"))
+	}
+	return contentTypes
+}
+
+func (s *recordingServer) Bodies() [][]byte {
+	var bodies [][]byte
+	for _, r := range s.Requests() {
+		bodies = append(bodies, []byte(r.body))
+	}
+	return bodies
+}
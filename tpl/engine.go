@@ -49,6 +49,19 @@ func NewTemplater(i interface{}) *Templater {
 	}
 }
 
+// Check - parse templates of entries without executing them
+func Check(entries map[string]string) error {
+	for _, v := range entries {
+		if !strings.Contains(v, "{{") {
+			continue
+		}
+		if _, err := loadOrStoreTemplate(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t Templater) Execute(entries map[string]string) (map[string]string, error) {
 	if len(entries) == 0 {
 		return entries, nil
@@ -71,19 +71,24 @@ func (d UserDoc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		instanceId = v["instanceId"]
 	}
 	var instanceParam *model.InstanceParam
+	var rateLimits model.RateLimits
 	logMetadatas := make([]model.LogMetadata, 0)
 	if instanceId != "" {
 		instanceParam = &model.InstanceParam{}
 		d.db.Set("gorm:auto_preload", true).Order("revision desc").First(instanceParam, "instance_id = ?", instanceId)
 		d.db.Find(&logMetadatas, "instance_id = ?", instanceId)
-
+		plan, err := model.SyslogAddresses(d.config.SyslogAddresses).FoundSyslogWriter(instanceParam.SyslogName)
+		if err == nil {
+			rateLimits = plan.EffectiveRateLimits(instanceParam)
+		}
 	}
 	buf := &bytes.Buffer{}
 	err := mainTpl.Execute(buf, struct {
 		Config        model.Config
 		InstanceParam *model.InstanceParam
 		LogMetadatas  []model.LogMetadata
-	}{*d.config, instanceParam, logMetadatas})
+		RateLimits    model.RateLimits
+	}{*d.config, instanceParam, logMetadatas, rateLimits})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		// nolint:errcheck
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
@@ -38,6 +39,39 @@ func CopyMapString(src map[string]string) map[string]string {
 	return newMap
 }
 
+// JSONValue - value as a json decoder would give it back after encoding it, e.g. numbers are float64 and times are strings
+func JSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, bool, float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, sub := range v {
+			m[k] = JSONValue(sub)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, sub := range v {
+			s[i] = JSONValue(sub)
+		}
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
 func Round(f float64) float64 {
 	return math.Floor(f + .5)
 }
@@ -72,7 +106,10 @@ func foundVarSlice(s []interface{}, delim string) interface{} {
 	case delimFirstElem:
 		v = s[0]
 	default:
-		i, _ := strconv.Atoi(start)
+		i, err := strconv.Atoi(start)
+		if err != nil || i < 0 || i >= len(s) {
+			return nil
+		}
 		v = s[i]
 	}
 	if len(delimSplit) == 1 {